@@ -0,0 +1,327 @@
+package main
+
+// Bot accounts: API keys with scopes, exchanged for short-lived bot tokens
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/auth"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// apiKeyPrefix starts every API key, so leaked keys are easy to recognize and scan for.
+const apiKeyPrefix = "cob_"
+
+// botEmailDomain gives bots a unique, undeliverable email address, as emails are
+// unique across users.
+const botEmailDomain = "@bots.chatorbit.invalid"
+
+// apiKeyDisplayLength is how much of a key is kept in the clear to tell keys apart.
+const apiKeyDisplayLength = 12
+
+var (
+	ErrBotNotFound    = errors.New("Bot not found.")
+	ErrAPIKeyNotFound = errors.New("API key not found.")
+	ErrInvalidAPIKey  = errors.New("Invalid or revoked API key.")
+	ErrInvalidScopes  = errors.New("Grant at least one valid scope: history:read, messages:post or moderate.")
+)
+
+// BotConfig controls the tokens bots exchange their API keys for.
+type BotConfig struct {
+	// TokenTTL is the bot token lifetime; bots exchange their key again afterwards.
+	TokenTTL time.Duration
+	// Audiences are the services that accept bot tokens.
+	Audiences []string
+}
+
+// BotAccount is the public view of a bot user.
+type BotAccount struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func botAccount(user *User) BotAccount {
+	return BotAccount{ID: user.ID.Hex(), Username: user.Username, CreatedBy: user.CreatedBy, CreatedAt: user.CreateTime}
+}
+
+// APIKey is an API key of a bot. Only the hash of the key is stored.
+type APIKey struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	BotID  primitive.ObjectID `bson:"bot_id" json:"bot_id"`
+	Name   string             `bson:"name" json:"name"`
+	Prefix string             `bson:"prefix" json:"prefix"`
+	// KeyHash is the SHA-256 of the key.
+	KeyHash string   `bson:"key_hash" json:"-"`
+	Scopes  []string `bson:"scopes" json:"scopes"`
+	// Rooms restricts the key to these rooms; empty allows every room.
+	Rooms []string `bson:"rooms,omitempty" json:"rooms,omitempty"`
+	// RateLimit is how many messages the key may send per rate window of the chat
+	// service; 0 uses the chat service's default.
+	RateLimit  int        `bson:"rate_limit,omitempty" json:"rate_limit,omitempty"`
+	CreatedBy  string     `bson:"created_by" json:"created_by"`
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+	LastUsedAt *time.Time `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+// grant returns what tokens issued for the key allow.
+func (k *APIKey) grant() *auth.BotGrant {
+	return &auth.BotGrant{KeyID: k.ID.Hex(), Scopes: k.Scopes, Rooms: k.Rooms, RateLimit: k.RateLimit}
+}
+
+var apiKeyCollection *mongo.Collection
+
+// InitAPIKeyCollection sets the API key collection and its indexes.
+func InitAPIKeyCollection(db *mongo.Database) {
+	apiKeyCollection = db.Collection("api_keys")
+
+	_, err := apiKeyCollection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "bot_id", Value: 1}}},
+		},
+	)
+	if err != nil {
+		panic("Failed to create indexes on api_keys collection: " + err.Error())
+	}
+}
+
+// CreateBot creates a bot account. Bots have no password and authenticate with API keys only.
+func CreateBot(ctx context.Context, username, createdBy string) (*User, error) {
+	id := primitive.NewObjectID()
+	user := User{
+		ID:            id,
+		Email:         "bot-" + id.Hex() + botEmailDomain,
+		Username:      username,
+		CreateTime:    time.Now(),
+		EmailVerified: true,
+		Bot:           true,
+		CreatedBy:     createdBy,
+	}
+	if err := InsertUser(ctx, user); err != nil {
+		return nil, err
+	}
+	logSecurityEvent("bot_created", "bot="+user.ID.Hex()+" by="+createdBy)
+	return &user, nil
+}
+
+// ListBots returns every bot account.
+func ListBots(ctx context.Context) ([]BotAccount, error) {
+	cursor, err := userCollection.Find(ctx, bson.M{"bot": true}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	bots := make([]BotAccount, 0, len(users))
+	for i := range users {
+		bots = append(bots, botAccount(&users[i]))
+	}
+	return bots, nil
+}
+
+func findBot(ctx context.Context, botID string) (*User, error) {
+	uid, err := primitive.ObjectIDFromHex(botID)
+	if err != nil {
+		return nil, ErrBotNotFound
+	}
+	user, err := FindUserByID(ctx, uid)
+	if err != nil || !user.Bot {
+		return nil, ErrBotNotFound
+	}
+	return user, nil
+}
+
+// APIKeySpec is what a new API key may do.
+type APIKeySpec struct {
+	Name      string
+	Scopes    []string
+	Rooms     []string
+	RateLimit int
+}
+
+// CreateAPIKey creates an API key for a bot and returns it in the clear. Only its
+// hash is stored, so it is shown this once.
+func CreateAPIKey(ctx context.Context, botID string, spec APIKeySpec, createdBy string) (string, *APIKey, error) {
+	bot, err := findBot(ctx, botID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(spec.Scopes) == 0 || spec.RateLimit < 0 {
+		return "", nil, ErrInvalidScopes
+	}
+	for _, scope := range spec.Scopes {
+		if !auth.ValidScope(scope) {
+			return "", nil, ErrInvalidScopes
+		}
+	}
+	var rooms []string
+	for _, room := range spec.Rooms {
+		if room = strings.TrimSpace(room); room != "" {
+			rooms = append(rooms, room)
+		}
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	key := apiKeyPrefix + secret
+	apiKey := APIKey{
+		ID:        primitive.NewObjectID(),
+		BotID:     bot.ID,
+		Name:      strings.TrimSpace(spec.Name),
+		Prefix:    key[:apiKeyDisplayLength],
+		KeyHash:   hashToken(key),
+		Scopes:    spec.Scopes,
+		Rooms:     rooms,
+		RateLimit: spec.RateLimit,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+	if _, err := apiKeyCollection.InsertOne(ctx, apiKey); err != nil {
+		return "", nil, err
+	}
+	logSecurityEvent("api_key_created", "bot="+botID+" key="+apiKey.ID.Hex()+" by="+createdBy)
+	return key, &apiKey, nil
+}
+
+// ListAPIKeys returns the API keys of a bot, including revoked ones.
+func ListAPIKeys(ctx context.Context, botID string) ([]APIKey, error) {
+	bot, err := findBot(ctx, botID)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := apiKeyCollection.Find(ctx, bson.M{"bot_id": bot.ID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	keys := []APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an API key and the bot tokens issued for it, which also
+// closes their live connections.
+func RevokeAPIKey(ctx context.Context, cfg Config, botID, keyID string) error {
+	bot, err := findBot(ctx, botID)
+	if err != nil {
+		return err
+	}
+	kid, err := primitive.ObjectIDFromHex(keyID)
+	if err != nil {
+		return ErrAPIKeyNotFound
+	}
+	result, err := apiKeyCollection.UpdateOne(ctx,
+		bson.M{"_id": kid, "bot_id": bot.ID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	if err := tokenRevocations.RevokeSession(ctx, keyID, cfg.Bots.TokenTTL+cfg.Verifier.Leeway); err != nil {
+		return err
+	}
+	logSecurityEvent("api_key_revoked", "bot="+botID+" key="+keyID)
+	return nil
+}
+
+// ExchangeAPIKey issues a bot token for an API key. The token carries the key's
+// scopes, rooms and rate limit, and uses the key ID as its session, so revoking
+// the key revokes the token.
+func ExchangeAPIKey(ctx context.Context, cfg Config, key string) (string, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", ErrInvalidAPIKey
+	}
+	now := time.Now()
+	var apiKey APIKey
+	err := apiKeyCollection.FindOneAndUpdate(ctx,
+		bson.M{"key_hash": hashToken(key), "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"last_used_at": now}},
+	).Decode(&apiKey)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", ErrInvalidAPIKey
+	}
+	if err != nil {
+		return "", err
+	}
+	bot, err := FindUserByID(ctx, apiKey.BotID)
+	if err != nil || !bot.Bot {
+		return "", ErrInvalidAPIKey
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	claims := auth.Claims{
+		UserID:           bot.ID.Hex(),
+		Roles:            botRoles(bot, &apiKey),
+		Name:             bot.Username,
+		AccountCreatedAt: bot.CreateTime.Unix(),
+		Version:          bot.TokenVersion,
+		SessionID:        apiKey.ID.Hex(),
+		Bot:              apiKey.grant(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    cfg.Issuer,
+			Subject:   bot.ID.Hex(),
+			Audience:  jwt.ClaimStrings(cfg.Bots.Audiences),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.Bots.TokenTTL)),
+		},
+	}
+	return signingKeys.Sign(claims)
+}
+
+// botRoles returns the roles of a bot token. The bot's moderation roles are only
+// carried with the moderate scope, and a key restricted to rooms turns global
+// moderation into moderation of those rooms.
+func botRoles(bot *User, key *APIKey) auth.Roles {
+	roles := auth.Roles{auth.RoleBot}
+	grant := key.grant()
+	if !grant.HasScope(auth.ScopeModerate) {
+		return roles
+	}
+	add := func(role string) {
+		if !roles.Has(role) {
+			roles = append(roles, role)
+		}
+	}
+	for _, role := range bot.Roles {
+		if roomID, _, ok := auth.ParseRoomRole(role); ok {
+			if grant.AllowsRoom(roomID) {
+				add(role)
+			}
+			continue
+		}
+		if !auth.Roles([]string{role}).CanModerateAll() {
+			continue
+		}
+		if len(grant.Rooms) == 0 {
+			add(role)
+			continue
+		}
+		for _, roomID := range grant.Rooms {
+			add(auth.RoomRole(roomID, auth.RoleModerator))
+		}
+	}
+	return roles
+}
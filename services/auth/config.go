@@ -1,3 +1,176 @@
 package main
 
 // Local config loading for auth service
+// OAuth2 login and user session management
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/auth"
+)
+
+// Config holds the tunable settings of the auth service, loaded from the environment.
+type Config struct {
+	// AccessTokenTTL is the lifetime of the JWT access token.
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is the lifetime of a refresh token; every rotation issues a new one.
+	RefreshTokenTTL time.Duration
+	// KeysDir holds the PEM signing keys; empty generates an ephemeral dev key.
+	KeysDir string
+	// KeysReloadInterval is how often KeysDir is re-read to pick up rotated keys.
+	KeysReloadInterval time.Duration
+	// KeyOverlap is how long a retired key keeps verifying tokens. It should exceed
+	// the access token lifetime plus the JWKS cache time of other services.
+	KeyOverlap time.Duration
+	// Issuer and Audiences are set on issued tokens; every service requires its own audience.
+	Issuer    string
+	Audiences []string
+	// Verifier validates tokens presented to this service.
+	Verifier auth.VerifierConfig
+	// PublicURL is the externally reachable base URL of this service, used in email links.
+	PublicURL string
+	// RequireEmailVerification rejects logins of accounts that have not verified their email.
+	RequireEmailVerification bool
+	// EmailVerificationTTL is how long an email verification link stays valid.
+	EmailVerificationTTL time.Duration
+	// PasswordResetURL is the page the reset email links to, with the token in ?token=.
+	PasswordResetURL string
+	// PasswordResetTTL is how long a password reset token stays valid.
+	PasswordResetTTL time.Duration
+	// PasswordResetLimits limits reset link requests.
+	PasswordResetLimits PasswordResetLimitConfig
+	// LoginGuard limits failed login attempts.
+	LoginGuard LoginGuardConfig
+	// TOTPIssuer names the service in authenticator apps.
+	TOTPIssuer string
+	// MFATokenTTL is how long the second login step may take after the password check.
+	MFATokenTTL time.Duration
+	// OIDCProviders are the identity providers users can log in with.
+	OIDCProviders []OIDCProviderConfig
+	// OIDCSuccessURL, when set, is where the browser is redirected after a social login
+	// instead of receiving the tokens as JSON.
+	OIDCSuccessURL string
+	// Guest configures anonymous guest tokens.
+	Guest GuestConfig
+	// Bots configures the tokens bots exchange their API keys for.
+	Bots BotConfig
+	// Privacy configures data export and account deletion jobs.
+	Privacy PrivacyConfig
+	// Mail configures how emails are sent.
+	Mail MailConfig
+}
+
+// LoadConfig reads the auth service configuration from environment variables.
+func LoadConfig() Config {
+	return Config{
+		AccessTokenTTL:  time.Duration(getEnvInt("ACCESS_TOKEN_TTL_SECONDS", 15*60)) * time.Second,
+		RefreshTokenTTL: time.Duration(getEnvInt("REFRESH_TOKEN_TTL_SECONDS", 30*24*3600)) * time.Second,
+
+		KeysDir:            strings.TrimSpace(os.Getenv("JWT_KEYS_DIR")),
+		KeysReloadInterval: time.Duration(getEnvInt("JWT_KEYS_RELOAD_SECONDS", 60)) * time.Second,
+		KeyOverlap:         time.Duration(getEnvInt("JWT_KEY_OVERLAP_SECONDS", 3600)) * time.Second,
+
+		Issuer:    getEnvOrDefault("JWT_ISSUER", auth.DefaultIssuer),
+		Audiences: getEnvList("JWT_AUDIENCES", []string{auth.AudienceAuth, auth.AudienceChat, auth.AudienceUser}),
+		Verifier:  auth.VerifierConfigFromEnv(auth.AudienceAuth),
+
+		PublicURL:                strings.TrimRight(getEnvOrDefault("AUTH_PUBLIC_URL", "http://localhost:8089"), "/"),
+		RequireEmailVerification: getEnvBool("REQUIRE_EMAIL_VERIFICATION", true),
+		EmailVerificationTTL:     time.Duration(getEnvInt("EMAIL_VERIFICATION_TTL_SECONDS", 24*3600)) * time.Second,
+		PasswordResetURL:         getEnvOrDefault("PASSWORD_RESET_URL", "http://localhost:8080/reset-password"),
+		PasswordResetTTL:         time.Duration(getEnvInt("PASSWORD_RESET_TTL_SECONDS", 30*60)) * time.Second,
+		PasswordResetLimits: PasswordResetLimitConfig{
+			MaxPerEmail: getEnvInt("PASSWORD_RESET_MAX_PER_EMAIL", 3),
+			MaxPerIP:    getEnvInt("PASSWORD_RESET_MAX_PER_IP", 30),
+			Window:      time.Duration(getEnvInt("PASSWORD_RESET_RATE_WINDOW_SECONDS", 15*60)) * time.Second,
+		},
+		LoginGuard: LoginGuardConfig{
+			MaxAccountFailures: getEnvInt("LOGIN_MAX_ACCOUNT_FAILURES", 5),
+			MaxIPFailures:      getEnvInt("LOGIN_MAX_IP_FAILURES", 20),
+			FailureWindow:      time.Duration(getEnvInt("LOGIN_FAILURE_WINDOW_SECONDS", 15*60)) * time.Second,
+			LockoutBase:        time.Duration(getEnvInt("LOGIN_LOCKOUT_BASE_SECONDS", 30)) * time.Second,
+			LockoutMax:         time.Duration(getEnvInt("LOGIN_LOCKOUT_MAX_SECONDS", 3600)) * time.Second,
+		},
+		TOTPIssuer:     getEnvOrDefault("TOTP_ISSUER", "ChatOrbit"),
+		MFATokenTTL:    time.Duration(getEnvInt("MFA_TOKEN_TTL_SECONDS", 5*60)) * time.Second,
+		OIDCProviders:  loadOIDCProviders(),
+		OIDCSuccessURL: strings.TrimSpace(os.Getenv("OIDC_SUCCESS_URL")),
+		Guest: GuestConfig{
+			TTL:        time.Duration(getEnvInt("GUEST_TOKEN_TTL_SECONDS", 4*3600)) * time.Second,
+			Audiences:  getEnvList("GUEST_TOKEN_AUDIENCES", []string{auth.AudienceChat}),
+			RateLimit:  getEnvInt("GUEST_TOKENS_PER_IP", 10),
+			RateWindow: time.Duration(getEnvInt("GUEST_TOKEN_RATE_WINDOW_SECONDS", 3600)) * time.Second,
+		},
+		Bots: BotConfig{
+			TokenTTL:  time.Duration(getEnvInt("BOT_TOKEN_TTL_SECONDS", 3600)) * time.Second,
+			Audiences: getEnvList("BOT_TOKEN_AUDIENCES", []string{auth.AudienceChat}),
+		},
+		Privacy: PrivacyConfig{
+			DeletionPolicy: parseDeletionPolicy(os.Getenv("ACCOUNT_DELETION_POLICY")),
+			Retention:      time.Duration(getEnvInt("PRIVACY_JOB_RETENTION_SECONDS", 7*24*3600)) * time.Second,
+			PollInterval:   time.Duration(getEnvInt("PRIVACY_POLL_SECONDS", 5)) * time.Second,
+			ReauthWindow:   time.Duration(getEnvInt("ACCOUNT_DELETION_REAUTH_SECONDS", 5*60)) * time.Second,
+		},
+		Mail: MailConfig{
+			Mailer:       getEnvOrDefault("MAILER", "log"),
+			From:         getEnvOrDefault("MAIL_FROM", "ChatOrbit <no-reply@chatorbit.local>"),
+			Dir:          strings.TrimSpace(os.Getenv("MAIL_DIR")),
+			SMTPHost:     strings.TrimSpace(os.Getenv("SMTP_HOST")),
+			SMTPPort:     getEnvInt("SMTP_PORT", 587),
+			SMTPUsername: os.Getenv("SMTP_USERNAME"),
+			SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		},
+	}
+}
+
+// loadOIDCProviders reads the providers named in OIDC_PROVIDERS, each configured by
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET and OIDC_<NAME>_SCOPES.
+func loadOIDCProviders() []OIDCProviderConfig {
+	var providers []OIDCProviderConfig
+	for _, name := range getEnvList("OIDC_PROVIDERS", nil) {
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		providers = append(providers, OIDCProviderConfig{
+			Name:         strings.ToLower(name),
+			Issuer:       strings.TrimSpace(os.Getenv(prefix + "ISSUER")),
+			ClientID:     strings.TrimSpace(os.Getenv(prefix + "CLIENT_ID")),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       getEnvList(prefix+"SCOPES", []string{"openid", "email", "profile"}),
+		})
+	}
+	return providers
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(key)))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvList(key string, defaultValue []string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return defaultValue
+	}
+	return items
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return n
+}
@@ -8,6 +8,10 @@ require github.com/celesteyang/ChatOrbit/shared/logger v0.1.1
 
 require github.com/gin-contrib/cors v1.7.6
 
+require github.com/celesteyang/ChatOrbit/shared/auth v0.0.0
+
+require github.com/go-redis/redis/v8 v8.11.5
+
 require (
 	github.com/KyleBanks/depth v1.2.1 // indirect
 	github.com/PuerkitoBio/purell v1.1.1 // indirect
@@ -15,7 +19,9 @@ require (
 	github.com/bytedance/sonic v1.13.3 // indirect
 	github.com/bytedance/sonic/loader v0.2.4 // indirect
 	github.com/celesteyang/ChatOrbit/shared/swagger v0.1.0
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/cloudwego/base64x v0.1.5 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/gabriel-vasile/mimetype v1.4.9 // indirect
 	github.com/gin-contrib/sse v1.1.0 // indirect
 	github.com/gin-gonic/gin v1.10.1
@@ -66,3 +72,9 @@ require (
 )
 
 // replace github.com/celesteyang/ChatOrbit/shared/logger => ../../shared/logger
+
+replace github.com/celesteyang/ChatOrbit/shared/auth => ../../shared/auth
+
+require github.com/celesteyang/ChatOrbit/shared/privacy v0.0.0
+
+replace github.com/celesteyang/ChatOrbit/shared/privacy => ../../shared/privacy
@@ -0,0 +1,143 @@
+package main
+
+// Guest tokens: anonymous, read-only access to chat for viewers without an account
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/auth"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// guestIDPrefix keeps guest IDs apart from account IDs.
+const guestIDPrefix = "guest:"
+
+// GuestConfig controls guest tokens.
+type GuestConfig struct {
+	// TTL is the guest token lifetime; guests request a new token afterwards.
+	TTL time.Duration
+	// Audiences are the services that accept guest tokens.
+	Audiences []string
+	// RateLimit is how many guest tokens one client IP may request per RateWindow.
+	RateLimit  int
+	RateWindow time.Duration
+}
+
+// GuestRateLimitedError is returned when a client IP requested too many guest tokens.
+type GuestRateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *GuestRateLimitedError) Error() string {
+	return "Too many guest sessions requested. Try again later."
+}
+
+// guestLimits counts guest tokens issued per client IP.
+var guestLimits *redis.Client
+
+// InitGuestTokens sets the Redis client that rate-limits guest tokens.
+func InitGuestTokens(client *redis.Client) {
+	guestLimits = client
+}
+
+// GuestToken is an issued guest token with the identity it carries.
+type GuestToken struct {
+	Token       string
+	UserID      string
+	DisplayName string
+}
+
+// IssueGuestToken creates a guest token with a new guest ID and display name,
+// unless the client IP has reached its limit.
+func IssueGuestToken(ctx context.Context, cfg Config, ip string) (*GuestToken, error) {
+	if err := takeGuestSlot(ctx, cfg.Guest, ip); err != nil {
+		return nil, err
+	}
+
+	id, err := randomToken(12)
+	if err != nil {
+		return nil, err
+	}
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	name, err := guestDisplayName()
+	if err != nil {
+		return nil, err
+	}
+
+	userID := guestIDPrefix + id
+	now := time.Now()
+	claims := auth.Claims{
+		UserID: userID,
+		Roles:  auth.Roles{auth.RoleGuest},
+		Name:   name,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    cfg.Issuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings(cfg.Guest.Audiences),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.Guest.TTL)),
+		},
+	}
+	token, err := signingKeys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+	return &GuestToken{Token: token, UserID: userID, DisplayName: name}, nil
+}
+
+// takeGuestSlot counts a guest token against the client IP's limit.
+func takeGuestSlot(ctx context.Context, cfg GuestConfig, ip string) error {
+	if cfg.RateLimit <= 0 {
+		return nil
+	}
+	key := "auth:guest_tokens:ip:" + ip
+	pipe := guestLimits.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	// The window starts with the first token; a key left without expiry is repaired too.
+	retryAfter := ttl.Val()
+	if retryAfter < 0 {
+		retryAfter = cfg.RateWindow
+		if err := guestLimits.Expire(ctx, key, cfg.RateWindow).Err(); err != nil {
+			return err
+		}
+	}
+	if incr.Val() > int64(cfg.RateLimit) {
+		return &GuestRateLimitedError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+var (
+	guestAdjectives = []string{"Quiet", "Swift", "Brave", "Lucky", "Sunny", "Clever", "Gentle", "Mighty", "Cosmic", "Happy", "Witty", "Calm"}
+	guestAnimals    = []string{"Otter", "Falcon", "Panda", "Fox", "Koala", "Tiger", "Owl", "Dolphin", "Badger", "Lynx", "Heron", "Turtle"}
+)
+
+// guestDisplayName generates a name such as "QuietOtter42".
+func guestDisplayName() (string, error) {
+	adjective, err := rand.Int(rand.Reader, big.NewInt(int64(len(guestAdjectives))))
+	if err != nil {
+		return "", err
+	}
+	animal, err := rand.Int(rand.Reader, big.NewInt(int64(len(guestAnimals))))
+	if err != nil {
+		return "", err
+	}
+	number, err := rand.Int(rand.Reader, big.NewInt(100))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s%02d", guestAdjectives[adjective.Int64()], guestAnimals[animal.Int64()], number.Int64()), nil
+}
@@ -2,9 +2,19 @@ package main
 
 // HTTP handlers for auth service
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/celesteyang/ChatOrbit/shared/auth"
 	"github.com/gin-gonic/gin"
 )
 
@@ -23,7 +33,8 @@ type RegisterRequest struct {
 }
 
 // @Summary      Register a new user
-// @Description  Register a new user with email, username, and password
+// @Description  Register a new user with email, username, and password.
+// @Description  The account starts unverified and is emailed a verification link.
 // @Tags         Register
 // @Accept       json
 // @Produce      json
@@ -31,7 +42,13 @@ type RegisterRequest struct {
 // @Failure      400  {object}  ErrorResponse
 // @Router       /register [post]
 // @Param        request body RegisterRequest true "Register request body"
-func RegisterHandler(c *gin.Context) {
+func RegisterHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		register(c, cfg)
+	}
+}
+
+func register(c *gin.Context, cfg Config) {
 	var req RegisterRequest
 
 	log.Println("[Register] Incoming request")
@@ -45,7 +62,7 @@ func RegisterHandler(c *gin.Context) {
 	// Log the data we actually care about (not password)
 	log.Printf("[Register] Email=%s Username=%s IP=%s\n", req.Email, req.Username, c.ClientIP())
 
-	if err := RegisterUser(c.Request.Context(), req.Email, req.Username, req.Password, c.ClientIP()); err != nil {
+	if err := RegisterUser(c.Request.Context(), cfg, req.Email, req.Username, req.Password, c.ClientIP()); err != nil {
 		log.Println("[Register] Registration error:", err)
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		return
@@ -53,7 +70,66 @@ func RegisterHandler(c *gin.Context) {
 
 	log.Println("[Register] SUCCESS for", req.Email)
 
-	c.JSON(http.StatusOK, MessageResponse{Message: "Registration successful"})
+	c.JSON(http.StatusOK, MessageResponse{Message: "Registration successful. Check your email to verify your account."})
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+// @Summary      Verify email
+// @Description  Marks the account's email verified using the token from the verification link.
+// @Description  The link opens this endpoint with ?token=; clients may also POST the token.
+// @Tags         Register
+// @Accept       json
+// @Produce      json
+// @Param        token  query  string  false  "Verification token"
+// @Param        request body VerifyEmailRequest false "Verify email request body"
+// @Success      200  {object}  MessageResponse
+// @Failure      400  {object}  ErrorResponse
+// @Router       /verify-email [get]
+// @Router       /verify-email [post]
+func VerifyEmailHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req VerifyEmailRequest
+		if err := c.ShouldBind(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid input"})
+			return
+		}
+
+		if err := VerifyEmail(c.Request.Context(), cfg, req.Token); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, MessageResponse{Message: "Email verified"})
+	}
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// @Summary      Resend verification email
+// @Description  Sends a new verification link if the email belongs to an unverified account.
+// @Description  The response is the same whether or not it does.
+// @Tags         Register
+// @Accept       json
+// @Produce      json
+// @Param        request body ResendVerificationRequest true "Resend verification request body"
+// @Success      200  {object}  MessageResponse
+// @Failure      400  {object}  ErrorResponse
+// @Router       /resend-verification [post]
+func ResendVerificationHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ResendVerificationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid input"})
+			return
+		}
+
+		ResendVerificationEmail(c.Request.Context(), cfg, req.Email)
+		c.JSON(http.StatusOK, MessageResponse{Message: "If the account needs verification, an email has been sent."})
+	}
 }
 
 type LoginRequest struct {
@@ -61,20 +137,56 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required,min=6"`
 }
 type LoginResponse struct {
-	Token string `json:"token"`
+	// Token is the short-lived JWT access token.
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	// ExpiresIn is the access token lifetime in seconds.
+	ExpiresIn int64 `json:"expires_in"`
+	// MFARequired means the account has 2FA: no tokens are returned, and MFAToken
+	// is exchanged at /login/2fa together with a code.
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+}
+
+// refreshCookie holds the refresh token for browser clients.
+const refreshCookie = "refresh_token"
+
+// setTokenCookies stores a token pair in HttpOnly cookies.
+func setTokenCookies(c *gin.Context, cfg Config, tokens *TokenPair) {
+	c.SetCookie("token", tokens.AccessToken, int(cfg.AccessTokenTTL.Seconds()), "/", "", false, true)
+	c.SetCookie(refreshCookie, tokens.RefreshToken, int(cfg.RefreshTokenTTL.Seconds()), "/", "", false, true)
+}
+
+func tokenResponse(cfg Config, tokens *TokenPair) LoginResponse {
+	return LoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    int64(cfg.AccessTokenTTL.Seconds()),
+	}
 }
 
 // @Summary      Login
-// @Description  Login a user with email and password
+// @Description  Login a user with email and password. Returns a short-lived access token and a refresh token.
+// @Description  For accounts with two-factor authentication it returns mfa_required and an mfa_token for /login/2fa instead.
+// @Description  Repeated failures lock out the account and the client IP for exponentially growing periods (429 with Retry-After).
 // @Tags         Login
 // @Accept       json
 // @Produce      json
 // @Success      200  {object}  LoginResponse
 // @Failure      400  {object}  ErrorResponse
 // @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      429  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
 // @Router       /login [post]
 // @Param        request body LoginRequest true "Login request body"
-func LoginHandler(c *gin.Context) {
+func LoginHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		login(c, cfg)
+	}
+}
+
+func login(c *gin.Context, cfg Config) {
 	var req LoginRequest
 
 	log.Println("[Login] Incoming request")
@@ -87,18 +199,440 @@ func LoginHandler(c *gin.Context) {
 
 	log.Printf("[Login] Attempt username/email=%s IP=%s\n", req.Email, c.ClientIP())
 
-	token, err := LoginUser(c.Request.Context(), req.Email, req.Password)
+	tokens, err := LoginUser(c.Request.Context(), cfg, req.Email, req.Password, clientInfo(c))
 	if err != nil {
-		log.Println("[Login] Auth error for", req.Email, ":", err)
-		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+		log.Println("[Login] error for", req.Email, ":", err)
+		loginError(c, err)
+		return
+	}
+
+	if tokens.MFAToken != "" {
+		c.JSON(http.StatusOK, LoginResponse{MFARequired: true, MFAToken: tokens.MFAToken})
 		return
 	}
 
 	log.Println("[Login] SUCCESS for", req.Email)
 
 	// Without frontend cookie
-	c.SetCookie("token", token, 3600*24, "/", "", false, true)
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	setTokenCookies(c, cfg, tokens)
+	c.JSON(http.StatusOK, tokenResponse(cfg, tokens))
+}
+
+// clientInfo describes the client of a request for the session it logs in.
+func clientInfo(c *gin.Context) ClientInfo {
+	return ClientInfo{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+}
+
+// loginError responds to a failed login step: 429 with Retry-After during a lockout,
+// and a generic message for unexpected errors.
+func loginError(c *gin.Context, err error) {
+	var locked *LoginLockedError
+	switch {
+	case errors.As(err, &locked):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, ErrInvalidCredentials), errors.Is(err, ErrInvalidMFACode), errors.Is(err, ErrInvalidMFAToken):
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, ErrEmailNotVerified):
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to log in"})
+	}
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	// Code is the current TOTP code or an unused recovery code.
+	Code string `json:"code" binding:"required"`
+}
+
+// @Summary      Login second step
+// @Description  Exchanges the mfa_token from /login and a TOTP code or recovery code for an access token and a refresh token.
+// @Tags         Login
+// @Accept       json
+// @Produce      json
+// @Param        request body MFALoginRequest true "2FA login request body"
+// @Success      200  {object}  LoginResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      429  {object}  ErrorResponse
+// @Router       /login/2fa [post]
+func MFALoginHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MFALoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid input"})
+			return
+		}
+
+		tokens, err := CompleteMFALogin(c.Request.Context(), cfg, req.MFAToken, req.Code, clientInfo(c))
+		if err != nil {
+			log.Println("[Login2FA] error:", err)
+			loginError(c, err)
+			return
+		}
+
+		setTokenCookies(c, cfg, tokens)
+		c.JSON(http.StatusOK, tokenResponse(cfg, tokens))
+	}
+}
+
+type TOTPEnrollResponse struct {
+	Secret string `json:"secret"`
+	// OTPAuthURI is shown as a QR code for authenticator apps.
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// @Summary      Start 2FA enrollment
+// @Description  Generates a TOTP secret and its otpauth URI. 2FA is enabled once /2fa/confirm receives a valid code.
+// @Tags         TwoFactor
+// @Produce      json
+// @Success      200  {object}  TOTPEnrollResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Router       /2fa/enroll [post]
+func TOTPEnrollHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret, uri, err := EnrollTOTP(c.Request.Context(), cfg, c.GetString("user_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, TOTPEnrollResponse{Secret: secret, OTPAuthURI: uri})
+	}
+}
+
+type TOTPConfirmRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type RecoveryCodesResponse struct {
+	// RecoveryCodes each work once in place of a TOTP code. They are not shown again.
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// @Summary      Confirm 2FA enrollment
+// @Description  Enables 2FA with a code from the authenticator app and returns one-time recovery codes.
+// @Tags         TwoFactor
+// @Accept       json
+// @Produce      json
+// @Param        request body TOTPConfirmRequest true "Confirm request body"
+// @Success      200  {object}  RecoveryCodesResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Router       /2fa/confirm [post]
+func TOTPConfirmHandler(c *gin.Context) {
+	var req TOTPConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid input"})
+		return
+	}
+
+	codes, err := ConfirmTOTP(c.Request.Context(), c.GetString("user_id"), req.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+type TOTPDisableRequest struct {
+	Password string `json:"password" binding:"required"`
+	// Code is the current TOTP code or an unused recovery code.
+	Code string `json:"code" binding:"required"`
+}
+
+// @Summary      Disable 2FA
+// @Description  Turns two-factor authentication off. Requires the password and a TOTP code or recovery code.
+// @Tags         TwoFactor
+// @Accept       json
+// @Produce      json
+// @Param        request body TOTPDisableRequest true "Disable request body"
+// @Success      200  {object}  MessageResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      429  {object}  ErrorResponse
+// @Router       /2fa/disable [post]
+func TOTPDisableHandler(c *gin.Context) {
+	var req TOTPDisableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid input"})
+		return
+	}
+
+	err := DisableTOTP(c.Request.Context(), c.GetString("user_id"), req.Password, req.Code, c.ClientIP())
+	var locked *LoginLockedError
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, MessageResponse{Message: "Two-factor authentication disabled"})
+	case errors.As(err, &locked), errors.Is(err, ErrInvalidCredentials), errors.Is(err, ErrInvalidMFACode):
+		loginError(c, err)
+	default:
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	}
+}
+
+// oidcStateCookie binds a social login to the browser that started it.
+const oidcStateCookie = "oidc_state"
+
+type OIDCProvidersResponse struct {
+	Providers []string `json:"providers"`
+}
+
+// @Summary      Identity providers
+// @Description  Names of the OIDC providers users can log in with.
+// @Tags         OAuth
+// @Produce      json
+// @Success      200  {object}  OIDCProvidersResponse
+// @Router       /oauth/providers [get]
+func OIDCProvidersHandler(c *gin.Context) {
+	names := make([]string, 0, len(oidcProviders))
+	for name := range oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.JSON(http.StatusOK, OIDCProvidersResponse{Providers: names})
+}
+
+// @Summary      Social login
+// @Description  Redirects to the identity provider to log in with the authorization code flow and PKCE.
+// @Tags         OAuth
+// @Param        provider  path  string  true  "Provider name"
+// @Success      302
+// @Failure      404  {object}  ErrorResponse
+// @Router       /oauth/{provider}/login [get]
+func OIDCLoginHandler(c *gin.Context) {
+	authURL, state, err := StartOIDCLogin(c.Request.Context(), c.Param("provider"), "")
+	if err != nil {
+		oidcError(c, err)
+		return
+	}
+	c.SetCookie(oidcStateCookie, state, int(oidcStateTTL.Seconds()), "/oauth", "", false, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+type OIDCLinkResponse struct {
+	// AuthorizationURL is where the browser goes to confirm the identity to link.
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// @Summary      Link identity
+// @Description  Starts linking an identity at the provider to the logged-in account.
+// @Description  The browser is sent to authorization_url and the callback links the identity.
+// @Tags         OAuth
+// @Produce      json
+// @Param        provider  path  string  true  "Provider name"
+// @Success      200  {object}  OIDCLinkResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Router       /oauth/{provider}/link [post]
+func OIDCLinkHandler(c *gin.Context) {
+	authURL, state, err := StartOIDCLogin(c.Request.Context(), c.Param("provider"), c.GetString("user_id"))
+	if err != nil {
+		oidcError(c, err)
+		return
+	}
+	c.SetCookie(oidcStateCookie, state, int(oidcStateTTL.Seconds()), "/oauth", "", false, true)
+	c.JSON(http.StatusOK, OIDCLinkResponse{AuthorizationURL: authURL})
+}
+
+// @Summary      Social login callback
+// @Description  The identity provider redirects here. Logs in, or links the identity when linking was started.
+// @Description  An unknown identity is linked to the account with the same email if the provider verified it,
+// @Description  or gets a new account. Redirects to OIDC_SUCCESS_URL when it is configured.
+// @Tags         OAuth
+// @Produce      json
+// @Param        provider  path   string  true  "Provider name"
+// @Param        code      query  string  true  "Authorization code"
+// @Param        state     query  string  true  "State"
+// @Success      200  {object}  LoginResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Router       /oauth/{provider}/callback [get]
+func OIDCCallbackHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if providerError := c.Query("error"); providerError != "" {
+			log.Println("[OIDC] Provider returned error:", providerError, c.Query("error_description"))
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Login was cancelled or denied by the identity provider."})
+			return
+		}
+		state := c.Query("state")
+		if cookie, err := c.Cookie(oidcStateCookie); err != nil || state == "" || cookie != state {
+			oidcError(c, ErrInvalidOIDCState)
+			return
+		}
+		c.SetCookie(oidcStateCookie, "", -1, "/oauth", "", false, true)
+
+		tokens, err := CompleteOIDCLogin(c.Request.Context(), cfg, c.Param("provider"), state, c.Query("code"), clientInfo(c))
+		if err != nil {
+			log.Println("[OIDC] Callback error:", err)
+			oidcError(c, err)
+			return
+		}
+
+		switch {
+		case tokens == nil:
+			c.JSON(http.StatusOK, MessageResponse{Message: "Identity linked"})
+		case tokens.MFAToken != "" && cfg.OIDCSuccessURL != "":
+			c.Redirect(http.StatusFound, cfg.OIDCSuccessURL+"?mfa_token="+url.QueryEscape(tokens.MFAToken))
+		case tokens.MFAToken != "":
+			c.JSON(http.StatusOK, LoginResponse{MFARequired: true, MFAToken: tokens.MFAToken})
+		case cfg.OIDCSuccessURL != "":
+			setTokenCookies(c, cfg, tokens)
+			c.Redirect(http.StatusFound, cfg.OIDCSuccessURL)
+		default:
+			setTokenCookies(c, cfg, tokens)
+			c.JSON(http.StatusOK, tokenResponse(cfg, tokens))
+		}
+	}
+}
+
+// oidcError maps social login errors to responses without exposing provider details.
+func oidcError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownProvider), errors.Is(err, ErrIdentityNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, ErrInvalidOIDCState), errors.Is(err, ErrLastLoginMethod):
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, ErrOIDCEmailNotVerified):
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, ErrIdentityLinked):
+		c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+	default:
+		c.JSON(http.StatusBadGateway, ErrorResponse{Error: "Login with the identity provider failed."})
+	}
+}
+
+type IdentitiesResponse struct {
+	Identities []Identity `json:"identities"`
+}
+
+// @Summary      Linked identities
+// @Description  The external identities linked to the logged-in account.
+// @Tags         OAuth
+// @Produce      json
+// @Success      200  {object}  IdentitiesResponse
+// @Failure      401  {object}  ErrorResponse
+// @Router       /oauth/identities [get]
+func ListIdentitiesHandler(c *gin.Context) {
+	identities, err := ListIdentities(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, IdentitiesResponse{Identities: identities})
+}
+
+// @Summary      Unlink identity
+// @Description  Removes a linked identity. The last way to log in cannot be removed.
+// @Tags         OAuth
+// @Produce      json
+// @Param        provider  path  string  true  "Provider name"
+// @Param        subject   path  string  true  "Subject at the provider"
+// @Success      200  {object}  MessageResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Router       /oauth/identities/{provider}/{subject} [delete]
+func UnlinkIdentityHandler(c *gin.Context) {
+	err := UnlinkIdentity(c.Request.Context(), c.GetString("user_id"), c.Param("provider"), c.Param("subject"))
+	if err != nil {
+		oidcError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, MessageResponse{Message: "Identity unlinked"})
+}
+
+type GuestTokenResponse struct {
+	// Token is a read-only chat token; guests have no refresh token.
+	Token       string `json:"token"`
+	UserID      string `json:"user_id"`
+	DisplayName string `json:"display_name"`
+	// ExpiresIn is the guest token lifetime in seconds.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+// @Summary      Guest token
+// @Description  Issues an anonymous guest token for watching chat rooms that allow guests.
+// @Description  Guests receive messages and count toward presence but cannot send. Rate-limited per client IP.
+// @Tags         Login
+// @Produce      json
+// @Success      200  {object}  GuestTokenResponse
+// @Failure      429  {object}  ErrorResponse
+// @Router       /guest [post]
+func GuestTokenHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		guest, err := IssueGuestToken(c.Request.Context(), cfg, c.ClientIP())
+		var limited *GuestRateLimitedError
+		if errors.As(err, &limited) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			log.Println("[Guest] error:", err)
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create guest session"})
+			return
+		}
+
+		c.JSON(http.StatusOK, GuestTokenResponse{
+			Token:       guest.Token,
+			UserID:      guest.UserID,
+			DisplayName: guest.DisplayName,
+			ExpiresIn:   int64(cfg.Guest.TTL.Seconds()),
+		})
+	}
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refreshTokenFrom reads the refresh token from the request body or the refresh cookie.
+func refreshTokenFrom(c *gin.Context) string {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err == nil && req.RefreshToken != "" {
+		return req.RefreshToken
+	}
+	if token, err := c.Cookie(refreshCookie); err == nil {
+		return token
+	}
+	return ""
+}
+
+// @Summary      Refresh tokens
+// @Description  Exchanges a refresh token (body or refresh_token cookie) for a new access token and refresh token.
+// @Description  Each refresh token works once; reusing one revokes every token issued from the same login.
+// @Tags         Login
+// @Accept       json
+// @Produce      json
+// @Param        request body RefreshRequest false "Refresh request body"
+// @Success      200  {object}  LoginResponse
+// @Failure      401  {object}  ErrorResponse
+// @Router       /refresh [post]
+func RefreshHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		refreshToken := refreshTokenFrom(c)
+		if refreshToken == "" {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: ErrInvalidRefreshToken.Error()})
+			return
+		}
+
+		tokens, err := RotateRefreshToken(c.Request.Context(), cfg, refreshToken, clientInfo(c))
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			log.Println("[Refresh] error:", err)
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to refresh token"})
+			return
+		}
+
+		setTokenCookies(c, cfg, tokens)
+		c.JSON(http.StatusOK, tokenResponse(cfg, tokens))
+	}
 }
 
 type ChangePasswordRequest struct {
@@ -138,16 +672,587 @@ func ChangePasswordHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, MessageResponse{Message: "Password changed successfully"})
 }
 
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// @Summary      Forgot password
+// @Description  Emails a single-use password reset link if the email belongs to an account.
+// @Description  The response is the same whether or not it does. Requests are limited per email and per client IP.
+// @Tags         ChangePassword
+// @Accept       json
+// @Produce      json
+// @Param        request body ForgotPasswordRequest true "Forgot password request body"
+// @Success      200  {object}  MessageResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      429  {object}  ErrorResponse
+// @Router       /forgot-password [post]
+func ForgotPasswordHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ForgotPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid input"})
+			return
+		}
+
+		// Limits are counted whether or not the account exists, so a 429 reveals nothing either.
+		err := takeResetSlot(c.Request.Context(), cfg.PasswordResetLimits, req.Email, c.ClientIP())
+		var limited *ResetRateLimitedError
+		if errors.As(err, &limited) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, ErrorResponse{Error: err.Error()})
+			return
+		}
+		logSecurityEvent("password_reset_requested", fmt.Sprintf("email_hash=%s ip=%s", hashEmail(req.Email), c.ClientIP()))
+
+		// Sent in the background so the response time does not reveal whether the account exists.
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			RequestPasswordReset(ctx, cfg, req.Email)
+		}()
+		c.JSON(http.StatusOK, MessageResponse{Message: "If an account exists for this email, a password reset link has been sent."})
+	}
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=6"`
+}
+
+// @Summary      Reset password
+// @Description  Sets a new password with the token from the reset email and logs the user out on all devices.
+// @Tags         ChangePassword
+// @Accept       json
+// @Produce      json
+// @Param        request body ResetPasswordRequest true "Reset password request body"
+// @Success      200  {object}  MessageResponse
+// @Failure      400  {object}  ErrorResponse
+// @Router       /reset-password [post]
+func ResetPasswordHandler(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid input"})
+		return
+	}
+
+	err := ResetPassword(c.Request.Context(), req.Token, req.NewPassword)
+	if errors.Is(err, ErrInvalidResetToken) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		log.Println("[ResetPassword] error:", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Password reset successfully"})
+}
+
 // @Summary      Logout
-// @Description  Logout the user by clearing the JWT cookie
+// @Description  Logout the user by clearing the JWT cookies and revoking the refresh token (body or cookie)
 // @Tags         Logout
 // @Accept       json
 // @Produce      json
+// @Param        request body RefreshRequest false "Logout request body"
 // @Success      200  {object}  MessageResponse
 // @Router       /logout [post]
-func LogoutHandler(c *gin.Context) {
-	c.SetCookie("token", "", -1, "/", "localhost", true, true)
-	// without frontend cookie
-	// c.SetCookie("token", "", -1, "/", "", false, true)
-	c.JSON(http.StatusOK, MessageResponse{Message: "Logged out successfully"})
+func LogoutHandler(cfg Config, verifier *auth.Verifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if tokenStr := auth.TokenFromRequest(c); tokenStr != "" {
+			if claims, err := verifier.Verify(c.Request.Context(), tokenStr); err == nil {
+				if err := RevokeJWT(c.Request.Context(), claims); err != nil {
+					log.Println("[Logout] Failed to revoke access token:", err)
+				}
+				// Ending the session also revokes its other access tokens and closes its sockets.
+				if claims.SessionID != "" {
+					if err := revokeSession(c.Request.Context(), cfg, claims.UserID, claims.SessionID, RevokedLogout); err != nil {
+						log.Println("[Logout] Failed to end session:", err)
+					}
+				}
+			}
+		}
+		if refreshToken := refreshTokenFrom(c); refreshToken != "" {
+			if err := RevokeRefreshToken(c.Request.Context(), cfg, refreshToken, RevokedLogout); err != nil {
+				log.Println("[Logout] Failed to revoke refresh token:", err)
+			}
+		}
+		c.SetCookie("token", "", -1, "/", "localhost", true, true)
+		c.SetCookie(refreshCookie, "", -1, "/", "", false, true)
+		// without frontend cookie
+		// c.SetCookie("token", "", -1, "/", "", false, true)
+		c.JSON(http.StatusOK, MessageResponse{Message: "Logged out successfully"})
+	}
+}
+
+// @Summary      Logout everywhere
+// @Description  Revokes every access token and refresh token of the logged-in user, on all devices
+// @Tags         Logout
+// @Produce      json
+// @Success      200  {object}  MessageResponse
+// @Failure      401  {object}  ErrorResponse
+// @Router       /logout-all [post]
+func LogoutAllHandler(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+		return
+	}
+
+	if err := LogoutEverywhere(c.Request.Context(), userID); err != nil {
+		log.Println("[LogoutAll] error for", userID, ":", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to log out everywhere"})
+		return
+	}
+
+	c.SetCookie("token", "", -1, "/", "", false, true)
+	c.SetCookie(refreshCookie, "", -1, "/", "", false, true)
+	c.JSON(http.StatusOK, MessageResponse{Message: "Logged out everywhere"})
+}
+
+// @Summary      List sessions
+// @Description  Lists the devices the logged-in user is logged in on. current marks the session of this request.
+// @Tags         Sessions
+// @Produce      json
+// @Success      200  {array}   Session
+// @Failure      401  {object}  ErrorResponse
+// @Router       /sessions [get]
+func ListSessionsHandler(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+		return
+	}
+
+	sessions, err := ListSessions(c.Request.Context(), userID, currentSessionID(c))
+	if err != nil {
+		log.Println("[Sessions] error for", userID, ":", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to list sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// @Summary      Revoke a session
+// @Description  Logs out one device: its tokens stop working and its live chat connections are closed.
+// @Tags         Sessions
+// @Produce      json
+// @Param        id path string true "Session ID"
+// @Success      200  {object}  MessageResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Router       /sessions/{id} [delete]
+func RevokeSessionHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+			return
+		}
+
+		sessionID := c.Param("id")
+		err := RevokeSession(c.Request.Context(), cfg, userID, sessionID)
+		if errors.Is(err, ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			log.Println("[RevokeSession] error for", userID, ":", err)
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke session"})
+			return
+		}
+
+		if sessionID == currentSessionID(c) {
+			c.SetCookie("token", "", -1, "/", "", false, true)
+			c.SetCookie(refreshCookie, "", -1, "/", "", false, true)
+		}
+		c.JSON(http.StatusOK, MessageResponse{Message: "Session revoked"})
+	}
+}
+
+// @Summary      Revoke all sessions
+// @Description  Logs out every device of the logged-in user, like /logout-all.
+// @Description  With keep_current=true the session of this request stays logged in.
+// @Tags         Sessions
+// @Produce      json
+// @Param        keep_current query bool false "Keep the current session"
+// @Success      200  {object}  MessageResponse
+// @Failure      401  {object}  ErrorResponse
+// @Router       /sessions [delete]
+func RevokeAllSessionsHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+			return
+		}
+
+		current := currentSessionID(c)
+		if c.Query("keep_current") == "true" && current != "" {
+			if err := RevokeOtherSessions(c.Request.Context(), cfg, userID, current); err != nil {
+				log.Println("[RevokeSessions] error for", userID, ":", err)
+				c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke sessions"})
+				return
+			}
+			c.JSON(http.StatusOK, MessageResponse{Message: "Other sessions revoked"})
+			return
+		}
+
+		if err := LogoutEverywhere(c.Request.Context(), userID); err != nil {
+			log.Println("[RevokeSessions] error for", userID, ":", err)
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke sessions"})
+			return
+		}
+		c.SetCookie("token", "", -1, "/", "", false, true)
+		c.SetCookie(refreshCookie, "", -1, "/", "", false, true)
+		c.JSON(http.StatusOK, MessageResponse{Message: "All sessions revoked"})
+	}
+}
+
+// currentSessionID returns the session of the request's access token.
+func currentSessionID(c *gin.Context) string {
+	if claims := auth.ClaimsFrom(c); claims != nil {
+		return claims.SessionID
+	}
+	return ""
+}
+
+// @Summary      Export my data
+// @Description  Queues an export of everything the auth, user and chat services hold about the logged-in user.
+// @Description  Poll /account/jobs/{id} until the status is completed, then download the archive from /account/export/{id}.
+// @Description  While an export is unfinished the same job is returned.
+// @Tags         Privacy
+// @Produce      json
+// @Success      202  {object}  PrivacyJob
+// @Failure      401  {object}  ErrorResponse
+// @Router       /account/export [post]
+func RequestExportHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+			return
+		}
+
+		job, err := RequestDataExport(c.Request.Context(), cfg, userID)
+		if err != nil {
+			log.Println("[Export] error for", userID, ":", err)
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to start export"})
+			return
+		}
+		c.JSON(http.StatusAccepted, job)
+	}
+}
+
+// @Summary      Download my data
+// @Description  Downloads the zip archive of a completed export, with a folder per service.
+// @Tags         Privacy
+// @Produce      application/zip
+// @Param        id path string true "Job ID"
+// @Success      200  {file}    file
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Router       /account/export/{id} [get]
+func DownloadExportHandler(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	job, err := CompletedExport(ctx, userID, c.Param("id"))
+	switch {
+	case errors.Is(err, ErrPrivacyJobNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	case errors.Is(err, ErrExportNotReady):
+		c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		log.Println("[Export] error for", userID, ":", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to load export"})
+		return
+	}
+
+	filename := "chatorbit_export_" + job.CompletedAt.UTC().Format("20060102T150405Z") + ".zip"
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+	if err := WriteExportArchive(ctx, c.Writer, job); err != nil {
+		log.Println("[Export] Failed to write archive", job.ID, ":", err)
+	}
+}
+
+type DeleteAccountRequest struct {
+	// Password is required unless the account only logs in through identity providers.
+	// Such accounts have to log in again shortly before instead.
+	Password string `json:"password"`
+	// Code is the current TOTP code or an unused recovery code, required with 2FA.
+	Code string `json:"code"`
+}
+
+// @Summary      Delete my account
+// @Description  Logs the user out everywhere right away and queues the deletion of their data in every service.
+// @Description  Depending on ACCOUNT_DELETION_POLICY, the profile and messages are anonymized or removed.
+// @Description  The tokens of the account stop working, so poll /account/jobs/{id} with the returned job ID.
+// @Description  Accounts without a password must call this from a session that logged in within ACCOUNT_DELETION_REAUTH_SECONDS.
+// @Tags         Privacy
+// @Accept       json
+// @Produce      json
+// @Param        request body DeleteAccountRequest true "Confirmation"
+// @Success      202  {object}  PrivacyJob
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      429  {object}  ErrorResponse
+// @Router       /account/delete [post]
+func DeleteAccountHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+			return
+		}
+		var req DeleteAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid input"})
+			return
+		}
+
+		job, err := RequestAccountDeletion(c.Request.Context(), cfg, userID, currentSessionID(c), req.Password, req.Code, c.ClientIP())
+		var locked *LoginLockedError
+		switch {
+		case err == nil:
+		case errors.As(err, &locked), errors.Is(err, ErrInvalidCredentials), errors.Is(err, ErrInvalidMFACode):
+			loginError(c, err)
+			return
+		case errors.Is(err, ErrReauthenticationRequired):
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+			return
+		default:
+			log.Println("[DeleteAccount] error for", userID, ":", err)
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete account"})
+			return
+		}
+
+		c.SetCookie("token", "", -1, "/", "", false, true)
+		c.SetCookie(refreshCookie, "", -1, "/", "", false, true)
+		c.JSON(http.StatusAccepted, job)
+	}
+}
+
+// @Summary      Privacy job status
+// @Description  Status of an export or deletion, overall and per service: pending, running, completed or failed.
+// @Description  The job ID is the credential, so no token is needed.
+// @Tags         Privacy
+// @Produce      json
+// @Param        id path string true "Job ID"
+// @Success      200  {object}  PrivacyJob
+// @Failure      404  {object}  ErrorResponse
+// @Router       /account/jobs/{id} [get]
+func PrivacyJobHandler(c *gin.Context) {
+	job, err := GetPrivacyJob(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, ErrPrivacyJobNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		log.Println("[PrivacyJob] error:", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to load job"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+type CreateBotRequest struct {
+	Username string `json:"username" binding:"required,min=2,max=100"`
+}
+
+// @Summary      Create bot
+// @Description  Creates a bot account. Bots cannot log in with a password; they authenticate with API keys.
+// @Description  Grant a bot moderation roles through the user service's roles endpoint.
+// @Tags         Bots
+// @Accept       json
+// @Produce      json
+// @Param        request body CreateBotRequest true "Create bot request body"
+// @Success      200  {object}  BotAccount
+// @Failure      400  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Router       /bots [post]
+func CreateBotHandler(c *gin.Context) {
+	var req CreateBotRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Username) == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid input"})
+		return
+	}
+
+	bot, err := CreateBot(c.Request.Context(), strings.TrimSpace(req.Username), c.GetString("user_id"))
+	if err != nil {
+		log.Println("[CreateBot] error:", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create bot"})
+		return
+	}
+	c.JSON(http.StatusOK, botAccount(bot))
+}
+
+// @Summary      List bots
+// @Tags         Bots
+// @Produce      json
+// @Success      200  {array}   BotAccount
+// @Failure      403  {object}  ErrorResponse
+// @Router       /bots [get]
+func ListBotsHandler(c *gin.Context) {
+	bots, err := ListBots(c.Request.Context())
+	if err != nil {
+		log.Println("[ListBots] error:", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to list bots"})
+		return
+	}
+	c.JSON(http.StatusOK, bots)
+}
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name"`
+	// Scopes are any of history:read, messages:post and moderate.
+	Scopes []string `json:"scopes" binding:"required"`
+	// Rooms restricts the key to these rooms; empty allows every room.
+	Rooms []string `json:"rooms"`
+	// RateLimit is how many messages the key may send per rate window of the chat service; 0 uses its default.
+	RateLimit int `json:"rate_limit"`
+}
+
+type CreateAPIKeyResponse struct {
+	// Key is shown only once.
+	Key    string `json:"key"`
+	APIKey APIKey `json:"api_key"`
+}
+
+// @Summary      Create API key
+// @Description  Creates an API key for a bot. The key is returned only once; only its hash is stored.
+// @Tags         Bots
+// @Accept       json
+// @Produce      json
+// @Param        id path string true "Bot ID"
+// @Param        request body CreateAPIKeyRequest true "Create API key request body"
+// @Success      200  {object}  CreateAPIKeyResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Router       /bots/{id}/keys [post]
+func CreateAPIKeyHandler(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid input"})
+		return
+	}
+
+	key, apiKey, err := CreateAPIKey(c.Request.Context(), c.Param("id"), APIKeySpec{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		Rooms:     req.Rooms,
+		RateLimit: req.RateLimit,
+	}, c.GetString("user_id"))
+	if err != nil {
+		botError(c, "[CreateAPIKey]", err)
+		return
+	}
+	c.JSON(http.StatusOK, CreateAPIKeyResponse{Key: key, APIKey: *apiKey})
+}
+
+// @Summary      List API keys
+// @Tags         Bots
+// @Produce      json
+// @Param        id path string true "Bot ID"
+// @Success      200  {array}   APIKey
+// @Failure      404  {object}  ErrorResponse
+// @Router       /bots/{id}/keys [get]
+func ListAPIKeysHandler(c *gin.Context) {
+	keys, err := ListAPIKeys(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		botError(c, "[ListAPIKeys]", err)
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// @Summary      Revoke API key
+// @Description  Revokes an API key. Bot tokens issued for it stop working and their live chat connections are closed.
+// @Tags         Bots
+// @Produce      json
+// @Param        id path string true "Bot ID"
+// @Param        keyID path string true "API key ID"
+// @Success      200  {object}  MessageResponse
+// @Failure      404  {object}  ErrorResponse
+// @Router       /bots/{id}/keys/{keyID} [delete]
+func RevokeAPIKeyHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := RevokeAPIKey(c.Request.Context(), cfg, c.Param("id"), c.Param("keyID")); err != nil {
+			botError(c, "[RevokeAPIKey]", err)
+			return
+		}
+		c.JSON(http.StatusOK, MessageResponse{Message: "API key revoked"})
+	}
+}
+
+type BotTokenResponse struct {
+	Token string `json:"token"`
+	// ExpiresIn is the bot token lifetime in seconds.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+// @Summary      Bot token
+// @Description  Exchanges an API key, sent in the X-API-Key header, for a short-lived bot token.
+// @Description  The token carries the key's scopes, rooms and rate limit.
+// @Tags         Bots
+// @Produce      json
+// @Param        X-API-Key header string true "API key"
+// @Success      200  {object}  BotTokenResponse
+// @Failure      401  {object}  ErrorResponse
+// @Router       /bots/token [post]
+func BotTokenHandler(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := ExchangeAPIKey(c.Request.Context(), cfg, strings.TrimSpace(c.GetHeader("X-API-Key")))
+		if errors.Is(err, ErrInvalidAPIKey) {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			log.Println("[BotToken] error:", err)
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to issue bot token"})
+			return
+		}
+		c.JSON(http.StatusOK, BotTokenResponse{Token: token, ExpiresIn: int64(cfg.Bots.TokenTTL.Seconds())})
+	}
+}
+
+// botError responds to a failed bot or API key request.
+func botError(c *gin.Context, tag string, err error) {
+	switch {
+	case errors.Is(err, ErrBotNotFound), errors.Is(err, ErrAPIKeyNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, ErrInvalidScopes):
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	default:
+		log.Println(tag, "error:", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to manage API keys"})
+	}
+}
+
+// @Summary      JSON Web Key Set
+// @Description  Public keys that verify access tokens, including recently retired keys during a rotation.
+// @Description  Tokens name their key in the kid header.
+// @Tags         Keys
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Router       /.well-known/jwks.json [get]
+func JWKSHandler(c *gin.Context) {
+	set, err := signingKeys.JWKS()
+	if err != nil {
+		log.Println("[JWKS] error:", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to load keys"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, set)
 }
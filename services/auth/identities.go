@@ -0,0 +1,296 @@
+package main
+
+// Social login: OIDC login state and external identities linked to accounts
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// oidcStateTTL is how long a user has to complete a login at the provider.
+const oidcStateTTL = 10 * time.Minute
+
+var (
+	ErrUnknownProvider      = errors.New("Unknown identity provider.")
+	ErrInvalidOIDCState     = errors.New("Invalid or expired login attempt. Try again.")
+	ErrOIDCEmailNotVerified = errors.New("The identity provider has not verified your email address.")
+	ErrIdentityLinked       = errors.New("This identity is already linked to an account.")
+	ErrIdentityNotFound     = errors.New("Linked identity not found.")
+	ErrLastLoginMethod      = errors.New("Set a password or link another identity before removing this one.")
+)
+
+// Identity is an account at an external identity provider linked to a user.
+type Identity struct {
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"subject"`
+	Email    string    `bson:"email,omitempty" json:"email,omitempty"`
+	LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
+}
+
+// oidcState is what a login started with AuthCodeURL needs to be completed. It is
+// kept server-side under the random state parameter and used once.
+type oidcState struct {
+	Provider     string `json:"provider"`
+	CodeVerifier string `json:"code_verifier"`
+	Nonce        string `json:"nonce"`
+	// LinkUserID is set when a logged-in user links an identity instead of logging in.
+	LinkUserID string `json:"link_user_id,omitempty"`
+}
+
+var (
+	oidcProviders  map[string]*OIDCProvider
+	oidcStateStore *redis.Client
+)
+
+// InitOIDCProviders sets up the configured identity providers and the Redis client
+// holding login state. Providers redirect back to <PublicURL>/oauth/<name>/callback.
+func InitOIDCProviders(client *redis.Client, cfg Config) {
+	oidcStateStore = client
+	oidcProviders = make(map[string]*OIDCProvider, len(cfg.OIDCProviders))
+	for _, provider := range cfg.OIDCProviders {
+		redirectURL := cfg.PublicURL + "/oauth/" + provider.Name + "/callback"
+		oidcProviders[provider.Name] = NewOIDCProvider(provider, redirectURL, nil)
+	}
+}
+
+func oidcStateKey(state string) string {
+	return "auth:oidc_state:" + state
+}
+
+// StartOIDCLogin returns the provider URL to send the user to and the state to bind
+// to the browser. linkUserID is empty for a login.
+func StartOIDCLogin(ctx context.Context, providerName, linkUserID string) (authURL, state string, err error) {
+	provider, ok := oidcProviders[providerName]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	st := oidcState{Provider: providerName, LinkUserID: linkUserID}
+	if state, err = randomToken(32); err != nil {
+		return "", "", err
+	}
+	if st.Nonce, err = randomToken(16); err != nil {
+		return "", "", err
+	}
+	if st.CodeVerifier, err = randomToken(32); err != nil {
+		return "", "", err
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return "", "", err
+	}
+	if err := oidcStateStore.Set(ctx, oidcStateKey(state), data, oidcStateTTL).Err(); err != nil {
+		return "", "", err
+	}
+
+	authURL, err = provider.AuthCodeURL(ctx, state, st.Nonce, st.CodeVerifier)
+	return authURL, state, err
+}
+
+// consumeOIDCState loads and deletes the state of a login, so a callback works once.
+func consumeOIDCState(ctx context.Context, state string) (*oidcState, error) {
+	pipe := oidcStateStore.TxPipeline()
+	get := pipe.Get(ctx, oidcStateKey(state))
+	pipe.Del(ctx, oidcStateKey(state))
+	if _, err := pipe.Exec(ctx); err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrInvalidOIDCState
+		}
+		return nil, err
+	}
+	var st oidcState
+	if err := json.Unmarshal([]byte(get.Val()), &st); err != nil {
+		return nil, ErrInvalidOIDCState
+	}
+	return &st, nil
+}
+
+// CompleteOIDCLogin handles the provider's callback: it validates the ID token and
+// either links the identity to the user who started linking, or logs in. A nil
+// token pair with a nil error means an identity was linked.
+func CompleteOIDCLogin(ctx context.Context, cfg Config, providerName, state, code string, client ClientInfo) (*TokenPair, error) {
+	st, err := consumeOIDCState(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+	provider, ok := oidcProviders[providerName]
+	if !ok || st.Provider != providerName {
+		return nil, ErrInvalidOIDCState
+	}
+
+	claims, err := provider.Authenticate(ctx, code, st.CodeVerifier, st.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	identity := Identity{Provider: providerName, Subject: claims.Subject, Email: claims.Email, LinkedAt: time.Now()}
+
+	if st.LinkUserID != "" {
+		user, err := findUserByHexID(ctx, st.LinkUserID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, LinkIdentity(ctx, user, identity)
+	}
+
+	user, err := userForIdentity(ctx, identity, claims, client.IP)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Login success (OIDC", providerName+"):", user.Email)
+	return issueLoginTokens(ctx, cfg, user, client)
+}
+
+// userForIdentity finds the account an identity logs in to. An unknown identity is
+// linked to the account with the same email, or gets a new account, but only if the
+// provider verified the email.
+func userForIdentity(ctx context.Context, identity Identity, claims *OIDCClaims, ip string) (*User, error) {
+	user, err := FindUserByIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	if claims.Email == "" || !bool(claims.EmailVerified) {
+		return nil, ErrOIDCEmailNotVerified
+	}
+
+	user, err = FindUserByEmail(ctx, claims.Email)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return createOIDCUser(ctx, identity, claims, ip)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := LinkIdentity(ctx, user, identity); err != nil {
+		return nil, err
+	}
+	if !user.EmailVerified {
+		if err := MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+			return nil, err
+		}
+		user.EmailVerified = true
+	}
+	return user, nil
+}
+
+// createOIDCUser creates a passwordless account for an identity with a verified email.
+func createOIDCUser(ctx context.Context, identity Identity, claims *OIDCClaims, ip string) (*User, error) {
+	username := claims.Name
+	if username == "" {
+		username = claims.PreferredUsername
+	}
+	if username == "" {
+		username = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	now := time.Now()
+	user := User{
+		ID:              primitive.NewObjectID(),
+		Email:           claims.Email,
+		Username:        username,
+		CreateTime:      now,
+		IPAddress:       ip,
+		EmailVerified:   true,
+		EmailVerifiedAt: &now,
+		Identities:      []Identity{identity},
+	}
+	if err := InsertUser(ctx, user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrIdentityLinked
+		}
+		return nil, err
+	}
+	logSecurityEvent("identity_linked", "user="+user.ID.Hex()+" provider="+identity.Provider+" new_account=true")
+	return &user, nil
+}
+
+// FindUserByIdentity finds the user an external identity is linked to.
+func FindUserByIdentity(ctx context.Context, provider, subject string) (*User, error) {
+	filter := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}}
+	var user User
+	if err := userCollection.FindOne(ctx, filter).Decode(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// LinkIdentity links an external identity to a user. An identity belongs to one
+// account at most; linking it again to the same account is a no-op.
+func LinkIdentity(ctx context.Context, user *User, identity Identity) error {
+	owner, err := FindUserByIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		if owner.ID == user.ID {
+			return nil
+		}
+		return ErrIdentityLinked
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	_, err = userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$push": bson.M{"identities": identity}, "$set": bson.M{"update_time": time.Now()}},
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrIdentityLinked
+	}
+	if err != nil {
+		return err
+	}
+	logSecurityEvent("identity_linked", "user="+user.ID.Hex()+" provider="+identity.Provider)
+	return nil
+}
+
+// UnlinkIdentity removes a linked identity, unless the account could no longer log in without it.
+func UnlinkIdentity(ctx context.Context, userID, provider, subject string) error {
+	user, err := findUserByHexID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, identity := range user.Identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			found = true
+		}
+	}
+	if !found {
+		return ErrIdentityNotFound
+	}
+	if user.PasswordHash == "" && len(user.Identities) == 1 {
+		return ErrLastLoginMethod
+	}
+
+	_, err = userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$pull": bson.M{"identities": bson.M{"provider": provider, "subject": subject}},
+			"$set":  bson.M{"update_time": time.Now()},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	logSecurityEvent("identity_unlinked", "user="+userID+" provider="+provider)
+	return nil
+}
+
+// ListIdentities returns the identities linked to a user.
+func ListIdentities(ctx context.Context, userID string) ([]Identity, error) {
+	user, err := findUserByHexID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Identities == nil {
+		return []Identity{}, nil
+	}
+	return user.Identities, nil
+}
@@ -0,0 +1,292 @@
+package main
+
+// Asymmetric signing keys for access tokens, published as a JWKS document
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/auth"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// activeKeyFile names the file in the keys directory that holds the kid of the signing key.
+const activeKeyFile = "active"
+
+// retiredSuffix names the file next to <kid>.pem that records when the key was retired,
+// so its overlap window does not start over when the service restarts.
+const retiredSuffix = ".retired"
+
+// signingKey is one private key of the key ring. RetiredAt is zero while the key
+// signs new tokens; afterwards it only verifies, until the overlap window ends.
+type signingKey struct {
+	ID        string
+	Alg       string
+	Private   crypto.Signer
+	RetiredAt time.Time
+}
+
+// KeyRing holds the active signing key and the retired keys still accepted for verification.
+type KeyRing struct {
+	dir     string
+	overlap time.Duration
+
+	mu     sync.RWMutex
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+// signingKeys signs and verifies the access tokens of this service.
+var signingKeys *KeyRing
+
+// InitSigningKeys loads the signing keys from cfg.KeysDir and reloads them every
+// cfg.KeysReloadInterval, so keys can be rotated without a restart. Without a keys
+// directory an ephemeral key is generated, which only suits a single dev instance.
+func InitSigningKeys(cfg Config) error {
+	ring := &KeyRing{dir: cfg.KeysDir, overlap: cfg.KeyOverlap, keys: map[string]*signingKey{}}
+	if cfg.KeysDir == "" {
+		log.Println("JWT_KEYS_DIR is not set, signing with an ephemeral key")
+		private, err := generateDevKey()
+		if err != nil {
+			return err
+		}
+		ring.active = private
+		ring.keys[private.ID] = private
+		signingKeys = ring
+		return nil
+	}
+
+	if err := ring.Reload(); err != nil {
+		return err
+	}
+	signingKeys = ring
+	go ring.watch(cfg.KeysReloadInterval)
+	return nil
+}
+
+func generateDevKey() (*signingKey, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := randomToken(8)
+	if err != nil {
+		return nil, err
+	}
+	return &signingKey{ID: "dev-" + kid, Alg: auth.AlgEdDSA, Private: private}, nil
+}
+
+func (r *KeyRing) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := r.Reload(); err != nil {
+			log.Println("[Keys] Failed to reload signing keys:", err)
+		}
+	}
+}
+
+// Reload reads every <kid>.pem file of the keys directory. The active key is the
+// kid named in the "active" file, or the greatest kid when there is none. A key
+// that stops being active keeps verifying for the overlap window after it was
+// retired; see retiredAt for how that time is kept across restarts.
+func (r *KeyRing) Reload() error {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return err
+	}
+	loaded := map[string]crypto.Signer{}
+	modified := map[string]time.Time{}
+	var ids []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".pem") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(r.dir, name))
+		if err != nil {
+			return err
+		}
+		private, err := parsePrivateKey(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		kid := strings.TrimSuffix(name, ".pem")
+		loaded[kid] = private
+		ids = append(ids, kid)
+		if info, err := os.Stat(filepath.Join(r.dir, name)); err == nil {
+			modified[kid] = info.ModTime()
+		}
+	}
+	if len(ids) == 0 {
+		return errors.New("No signing keys found in " + r.dir)
+	}
+	sort.Strings(ids)
+
+	activeID := ids[len(ids)-1]
+	activeSince := modified[activeID]
+	if data, err := os.ReadFile(filepath.Join(r.dir, activeKeyFile)); err == nil {
+		activeID = strings.TrimSpace(string(data))
+		if info, err := os.Stat(filepath.Join(r.dir, activeKeyFile)); err == nil {
+			activeSince = info.ModTime()
+		}
+	}
+	if _, ok := loaded[activeID]; !ok {
+		return errors.New("Active signing key " + activeID + " not found")
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := make(map[string]*signingKey, len(loaded))
+	for kid, private := range loaded {
+		alg, err := auth.AlgorithmFor(private.Public())
+		if err != nil {
+			return err
+		}
+		key := &signingKey{ID: kid, Alg: alg, Private: private}
+		if kid != activeID {
+			key.RetiredAt = r.retiredAt(kid, activeSince, now)
+		}
+		keys[kid] = key
+	}
+	if r.active == nil || r.active.ID != activeID {
+		log.Println("[Keys] Signing with key", activeID)
+	}
+	r.keys = keys
+	r.active = keys[activeID]
+	return nil
+}
+
+// retiredAt returns when an inactive key was retired. The time is taken, in order,
+// from the key's earlier reload, its <kid>.retired file, or now for the key this
+// instance was signing with. Otherwise the key was retired before this instance
+// started, so the time the active key was put in place (activeSince) is used.
+// New retirement times are written to <kid>.retired where the directory is
+// writable; read-only directories, such as mounted secrets, fall back to activeSince
+// on every start, which also does not move.
+// Must be called with r.mu held.
+func (r *KeyRing) retiredAt(kid string, activeSince, now time.Time) time.Time {
+	if previous, ok := r.keys[kid]; ok && !previous.RetiredAt.IsZero() {
+		return previous.RetiredAt
+	}
+	path := filepath.Join(r.dir, kid+retiredSuffix)
+	if data, err := os.ReadFile(path); err == nil {
+		if retired, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data))); err == nil {
+			return retired
+		}
+		log.Println("[Keys] Ignoring invalid retirement time in", path)
+	}
+
+	retired := activeSince
+	if (r.active != nil && r.active.ID == kid) || retired.IsZero() {
+		retired = now
+	}
+	if err := os.WriteFile(path, []byte(retired.UTC().Format(time.RFC3339)+"\n"), 0o644); err != nil {
+		log.Println("[Keys] Failed to record retirement of key", kid, ":", err)
+	}
+	return retired
+}
+
+// parsePrivateKey decodes a PEM encoded RSA (PKCS#1 or PKCS#8) or Ed25519 (PKCS#8) private key.
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("No PEM block found")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, errors.New("Unsupported PEM block " + block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("Unsupported private key type %T", key)
+	}
+}
+
+// verifying returns the keys that still verify tokens: the active key and keys
+// retired less than the overlap window ago.
+func (r *KeyRing) verifying(now time.Time) []*signingKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var keys []*signingKey
+	for _, key := range r.keys {
+		if key.RetiredAt.IsZero() || now.Sub(key.RetiredAt) < r.overlap {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+// Sign signs the claims with the active key and names it in the kid header.
+func (r *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	r.mu.RLock()
+	active := r.active
+	r.mu.RUnlock()
+
+	method := jwt.SigningMethod(jwt.SigningMethodEdDSA)
+	if active.Alg == auth.AlgRS256 {
+		method = jwt.SigningMethodRS256
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = active.ID
+	return token.SignedString(active.Private)
+}
+
+// Keyfunc returns the public key named by the token's kid, if it still verifies
+// and matches the token's algorithm.
+func (r *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range r.verifying(time.Now()) {
+		if key.ID != kid {
+			continue
+		}
+		if token.Method.Alg() != key.Alg {
+			return nil, errors.New("Unexpected signing algorithm " + token.Method.Alg())
+		}
+		return key.Private.Public(), nil
+	}
+	return nil, auth.ErrUnknownKey
+}
+
+// JWKS returns the public keys that verify tokens, for other services to fetch.
+func (r *KeyRing) JWKS() (auth.JWKS, error) {
+	set := auth.JWKS{Keys: []auth.JWK{}}
+	for _, key := range r.verifying(time.Now()) {
+		jwk, err := auth.NewJWK(key.ID, key.Private.Public())
+		if err != nil {
+			return set, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeTestKey(t *testing.T, dir, kid string) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setActiveKey(t *testing.T, dir, kid string, at time.Time) {
+	t.Helper()
+	path := filepath.Join(dir, activeKeyFile)
+	if err := os.WriteFile(path, []byte(kid+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loadKeyRing(t *testing.T, dir string, overlap time.Duration) *KeyRing {
+	t.Helper()
+	ring := &KeyRing{dir: dir, overlap: overlap, keys: map[string]*signingKey{}}
+	if err := ring.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	return ring
+}
+
+func signTestToken(t *testing.T, ring *KeyRing) string {
+	t.Helper()
+	token, err := ring.Sign(jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func verifies(ring *KeyRing, token string) bool {
+	_, err := jwt.Parse(token, ring.Keyfunc)
+	return err == nil
+}
+
+func verifyingIDs(ring *KeyRing, now time.Time) []string {
+	var ids []string
+	for _, key := range ring.verifying(now) {
+		ids = append(ids, key.ID)
+	}
+	return ids
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	dir := t.TempDir()
+	writeTestKey(t, dir, "2026-01")
+	ring := loadKeyRing(t, dir, time.Hour)
+	oldToken := signTestToken(t, ring)
+
+	writeTestKey(t, dir, "2026-02")
+	rotatedAt := time.Now()
+	if err := ring.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	newToken := signTestToken(t, ring)
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := parsed.Header["kid"]; kid != "2026-02" {
+		t.Fatalf("signed with %v, want the new key", kid)
+	}
+	if !verifies(ring, oldToken) || !verifies(ring, newToken) {
+		t.Fatal("tokens of both keys should verify during the overlap window")
+	}
+	jwks, err := ring.JWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("JWKS has %d keys during the overlap window, want 2", len(jwks.Keys))
+	}
+
+	ids := verifyingIDs(ring, rotatedAt.Add(time.Hour+time.Minute))
+	if len(ids) != 1 || ids[0] != "2026-02" {
+		t.Fatalf("keys verifying after the overlap window = %v, want only the new key", ids)
+	}
+
+	// Reloading again must not restart the overlap window.
+	retired := ring.keys["2026-01"].RetiredAt
+	if err := ring.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ring.keys["2026-01"].RetiredAt; !got.Equal(retired) {
+		t.Fatalf("RetiredAt moved from %v to %v on reload", retired, got)
+	}
+}
+
+func TestKeyRingRetirementSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	writeTestKey(t, dir, "2026-01")
+	ring := loadKeyRing(t, dir, time.Hour)
+
+	writeTestKey(t, dir, "2026-02")
+	setActiveKey(t, dir, "2026-02", time.Now())
+	if err := ring.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	retired := ring.keys["2026-01"].RetiredAt
+
+	// A restarted instance reads the recorded time instead of retiring the key again.
+	restarted := loadKeyRing(t, dir, time.Hour)
+	if got := restarted.keys["2026-01"].RetiredAt; !got.Equal(retired.Truncate(time.Second)) {
+		t.Fatalf("RetiredAt after restart = %v, want %v", got, retired)
+	}
+	if ids := verifyingIDs(restarted, retired.Add(time.Hour+time.Minute)); len(ids) != 1 {
+		t.Fatalf("keys verifying after the overlap window = %v, want only the new key", ids)
+	}
+}
+
+func TestKeyRingRetiredBeforeStart(t *testing.T) {
+	dir := t.TempDir()
+	writeTestKey(t, dir, "2026-01")
+	writeTestKey(t, dir, "2026-02")
+	// The rotation happened two hours before this instance first saw the keys.
+	setActiveKey(t, dir, "2026-02", time.Now().Add(-2*time.Hour))
+
+	ring := loadKeyRing(t, dir, time.Hour)
+	if ids := verifyingIDs(ring, time.Now()); len(ids) != 1 || ids[0] != "2026-02" {
+		t.Fatalf("keys verifying = %v, want only the active key", ids)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2026-01"+retiredSuffix)); err != nil {
+		t.Fatalf("retirement time not recorded: %v", err)
+	}
+}
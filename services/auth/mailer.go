@@ -0,0 +1,108 @@
+package main
+
+// Outgoing email: a log/file mailer for development and an SMTP mailer
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mail is a plain-text email.
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails to users.
+type Mailer interface {
+	Send(ctx context.Context, mail Mail) error
+}
+
+// MailConfig selects and configures the mailer.
+type MailConfig struct {
+	// Mailer is "log" (development) or "smtp".
+	Mailer string
+	From   string
+	// Dir, when set, makes the log mailer write every message to a file in it.
+	Dir string
+
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+}
+
+// mailer sends the verification and account emails of this service.
+var mailer Mailer
+
+// InitMailer sets the mailer selected by the configuration.
+func InitMailer(cfg MailConfig) {
+	switch cfg.Mailer {
+	case "smtp":
+		mailer = &SMTPMailer{cfg: cfg}
+	default:
+		mailer = &LogMailer{from: cfg.From, dir: cfg.Dir}
+	}
+}
+
+// LogMailer writes messages to the log, or to files in dir, instead of sending them.
+type LogMailer struct {
+	from string
+	dir  string
+}
+
+func (m *LogMailer) Send(ctx context.Context, mail Mail) error {
+	message := formatMail(m.from, mail)
+	if m.dir == "" {
+		log.Printf("[Mail] To=%s Subject=%s\n%s\n", mail.To, mail.Subject, mail.Body)
+		return nil
+	}
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitizeFileName(mail.To))
+	return os.WriteFile(filepath.Join(m.dir, name), message, 0o600)
+}
+
+// SMTPMailer sends messages through an SMTP server, using STARTTLS when the server offers it.
+type SMTPMailer struct {
+	cfg MailConfig
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, mail Mail) error {
+	addr := net.JoinHostPort(m.cfg.SMTPHost, fmt.Sprint(m.cfg.SMTPPort))
+	var smtpAuth smtp.Auth
+	if m.cfg.SMTPUsername != "" {
+		smtpAuth = smtp.PlainAuth("", m.cfg.SMTPUsername, m.cfg.SMTPPassword, m.cfg.SMTPHost)
+	}
+	return smtp.SendMail(addr, smtpAuth, m.cfg.From, []string{mail.To}, formatMail(m.cfg.From, mail))
+}
+
+// formatMail renders a message with the headers every mailer sends.
+func formatMail(from string, mail Mail) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mail.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(mail.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+func sanitizeFileName(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, value)
+}
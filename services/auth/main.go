@@ -13,10 +13,12 @@ import (
 	"os"
 	"time"
 
+	"github.com/celesteyang/ChatOrbit/shared/auth"
 	"github.com/celesteyang/ChatOrbit/shared/logger"
 	"github.com/celesteyang/ChatOrbit/shared/swagger"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
@@ -64,6 +66,34 @@ func main() {
 	}
 	db := client.Database("chatorbit")
 	InitUserCollection(db)
+	InitRefreshTokenCollection(db)
+	InitPasswordResetCollection(db)
+	InitSessionCollection(db)
+	InitAPIKeyCollection(db)
+	InitPrivacyCollections(db)
+
+	// 連接 Redis
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: getEnvOrDefault("REDIS_ADDR", ""),
+		DB:   0,
+	})
+	if _, err := redisClient.Ping(context.Background()).Result(); err != nil {
+		logger.Fatal("Redis connection failed", zap.Error(err))
+	}
+	InitTokenRevocations(redisClient)
+
+	cfg := LoadConfig()
+	if err := InitSigningKeys(cfg); err != nil {
+		logger.Fatal("Failed to load signing keys", zap.Error(err))
+	}
+	// Verifies this service's own access tokens; handlers that need it take it as a parameter.
+	verifier := auth.NewVerifier(signingKeys.Keyfunc, cfg.Verifier, tokenRevocations)
+	InitMailer(cfg.Mail)
+	InitLoginGuard(redisClient, cfg.LoginGuard)
+	InitOIDCProviders(redisClient, cfg)
+	InitGuestTokens(redisClient)
+	InitPasswordResetLimits(redisClient)
+	go NewPrivacyWorker().Run(context.Background(), cfg.Privacy.PollInterval)
 
 	// CORS 設定，允許前端跨域並攜帶 Cookie
 	r.Use(cors.New(cors.Config{
@@ -73,10 +103,45 @@ func main() {
 		AllowCredentials: true, // 允許 Cookie
 	}))
 
-	r.POST("/register", RegisterHandler)
-	r.POST("/login", LoginHandler)
-	r.POST("/change-password", AuthMiddleware(), ChangePasswordHandler)
-	r.POST("/logout", AuthMiddleware(), LogoutHandler)
+	r.GET("/.well-known/jwks.json", JWKSHandler)
+	r.POST("/register", RegisterHandler(cfg))
+	r.GET("/verify-email", VerifyEmailHandler(cfg))
+	r.POST("/verify-email", VerifyEmailHandler(cfg))
+	r.POST("/resend-verification", ResendVerificationHandler(cfg))
+	r.POST("/login", LoginHandler(cfg))
+	r.POST("/guest", GuestTokenHandler(cfg))
+	r.POST("/login/2fa", MFALoginHandler(cfg))
+	r.POST("/refresh", RefreshHandler(cfg))
+	r.POST("/change-password", verifier.Middleware(), ChangePasswordHandler)
+	r.POST("/forgot-password", ForgotPasswordHandler(cfg))
+	r.POST("/reset-password", ResetPasswordHandler)
+	r.POST("/logout", LogoutHandler(cfg, verifier))
+	r.POST("/logout-all", verifier.Middleware(), LogoutAllHandler)
+	r.GET("/sessions", verifier.Middleware(), ListSessionsHandler)
+	r.DELETE("/sessions", verifier.Middleware(), RevokeAllSessionsHandler(cfg))
+	r.DELETE("/sessions/:id", verifier.Middleware(), RevokeSessionHandler(cfg))
+	r.GET("/oauth/providers", OIDCProvidersHandler)
+	r.GET("/oauth/identities", verifier.Middleware(), ListIdentitiesHandler)
+	r.DELETE("/oauth/identities/:provider/:subject", verifier.Middleware(), UnlinkIdentityHandler)
+	r.GET("/oauth/:provider/login", OIDCLoginHandler)
+	r.POST("/oauth/:provider/link", verifier.Middleware(), OIDCLinkHandler)
+	r.GET("/oauth/:provider/callback", OIDCCallbackHandler(cfg))
+	r.POST("/2fa/enroll", verifier.Middleware(), TOTPEnrollHandler(cfg))
+	r.POST("/2fa/confirm", verifier.Middleware(), TOTPConfirmHandler)
+	r.POST("/2fa/disable", verifier.Middleware(), TOTPDisableHandler)
+	r.POST("/account/export", verifier.Middleware(), RequestExportHandler(cfg))
+	r.GET("/account/export/:id", verifier.Middleware(), DownloadExportHandler)
+	r.POST("/account/delete", verifier.Middleware(), DeleteAccountHandler(cfg))
+	r.GET("/account/jobs/:id", PrivacyJobHandler)
+
+	// Bot accounts are managed by admins and staff; bots exchange an API key for a token.
+	r.POST("/bots/token", BotTokenHandler(cfg))
+	bots := r.Group("/bots", verifier.Middleware(), auth.RequireRole(auth.RoleAdmin, auth.RoleStaff))
+	bots.POST("", CreateBotHandler)
+	bots.GET("", ListBotsHandler)
+	bots.POST("/:id/keys", CreateAPIKeyHandler)
+	bots.GET("/:id/keys", ListAPIKeysHandler)
+	bots.DELETE("/:id/keys/:keyID", RevokeAPIKeyHandler(cfg))
 
 	logger.Debug("Debugging information for auth service")
 	r.Run()
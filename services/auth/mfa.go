@@ -0,0 +1,235 @@
+package main
+
+// Two-factor authentication: TOTP enrollment, the second login step and recovery codes
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// audienceMFA is the audience of partial tokens, which only the second login step accepts.
+const audienceMFA = "chatorbit-mfa"
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("Two-factor authentication is already enabled.")
+	ErrMFANotEnabled     = errors.New("Two-factor authentication is not enabled.")
+	ErrMFANotEnrolled    = errors.New("Start two-factor enrollment first.")
+	ErrInvalidMFACode    = errors.New("Invalid authentication code.")
+	ErrInvalidMFAToken   = errors.New("Invalid or expired login session. Log in again.")
+)
+
+// mfaClaims are the claims of a partial token, issued after the password check of an
+// account with 2FA. The jti makes it single-use and ver ties it to the token version.
+type mfaClaims struct {
+	Version int64 `json:"ver"`
+	jwt.RegisteredClaims
+}
+
+// GenerateMFAToken signs a partial token for the second login step.
+func GenerateMFAToken(cfg Config, user *User) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := mfaClaims{
+		Version: user.TokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    cfg.Issuer,
+			Subject:   user.ID.Hex(),
+			Audience:  jwt.ClaimStrings{audienceMFA},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.MFATokenTTL)),
+		},
+	}
+	return signingKeys.Sign(claims)
+}
+
+// EnrollTOTP generates a new pending secret for the user and returns it with its otpauth URI.
+// 2FA is enabled only once ConfirmTOTP receives a valid code for the secret.
+func EnrollTOTP(ctx context.Context, cfg Config, userID string) (secret, uri string, err error) {
+	user, err := findUserByHexID(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+	if user.TOTPEnabled {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+
+	secret, err = newTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	_, err = userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"totp_pending_secret": secret, "update_time": time.Now()}},
+	)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, totpURI(cfg.TOTPIssuer, user.Email, secret), nil
+}
+
+// ConfirmTOTP enables 2FA if the code matches the pending secret, and returns new
+// recovery codes. Only their hashes are stored, so they are shown this once.
+func ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	user, err := findUserByHexID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.TOTPPendingSecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+	step, ok := validateTOTP(user.TOTPPendingSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "totp_pending_secret": user.TOTPPendingSecret, "totp_enabled": bson.M{"$ne": true}},
+		bson.M{
+			"$set": bson.M{
+				"totp_enabled":   true,
+				"totp_secret":    user.TOTPPendingSecret,
+				"totp_last_step": step,
+				"recovery_codes": hashes,
+				"update_time":    time.Now(),
+			},
+			"$unset": bson.M{"totp_pending_secret": ""},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.ModifiedCount == 0 {
+		return nil, ErrMFANotEnrolled
+	}
+	logSecurityEvent("mfa_enabled", "user="+userID)
+	return codes, nil
+}
+
+// DisableTOTP turns 2FA off after checking the password and a current code or recovery code.
+func DisableTOTP(ctx context.Context, userID, password, code, ip string) error {
+	user, err := findUserByHexID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrMFANotEnabled
+	}
+	if err := loginGuard.Check(ctx, user.Email, ip); err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		loginGuard.RecordFailure(ctx, user.Email, ip)
+		return ErrInvalidCredentials
+	}
+	if !useSecondFactor(ctx, user, code) {
+		loginGuard.RecordFailure(ctx, user.Email, ip)
+		return ErrInvalidMFACode
+	}
+
+	_, err = userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$set":   bson.M{"totp_enabled": false, "update_time": time.Now()},
+			"$unset": bson.M{"totp_secret": "", "totp_pending_secret": "", "totp_last_step": "", "recovery_codes": ""},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	logSecurityEvent("mfa_disabled", "user="+userID)
+	return nil
+}
+
+// CompleteMFALogin exchanges a partial token and a TOTP or recovery code for a full token pair.
+// Wrong codes count as failed logins.
+func CompleteMFALogin(ctx context.Context, cfg Config, mfaToken, code string, client ClientInfo) (*TokenPair, error) {
+	ip := client.IP
+	claims := &mfaClaims{}
+	token, err := purposeParser(cfg, audienceMFA).ParseWithClaims(mfaToken, claims, signingKeys.Keyfunc)
+	if err != nil || !token.Valid || claims.ID == "" {
+		return nil, ErrInvalidMFAToken
+	}
+	if err := tokenRevocations.Check(ctx, claims.ID, "", claims.Subject, claims.Version); err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+	user, err := findUserByHexID(ctx, claims.Subject)
+	if err != nil || !user.TOTPEnabled {
+		return nil, ErrInvalidMFAToken
+	}
+
+	if err := loginGuard.Check(ctx, user.Email, ip); err != nil {
+		return nil, err
+	}
+	if !useSecondFactor(ctx, user, code) {
+		log.Println("Login failed (2FA code)", user.Email)
+		loginGuard.RecordFailure(ctx, user.Email, ip)
+		return nil, ErrInvalidMFACode
+	}
+	loginGuard.RecordSuccess(ctx, user.Email)
+
+	// The partial token is spent; revoking it keeps it from being exchanged twice.
+	if err := tokenRevocations.RevokeToken(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+		return nil, err
+	}
+	log.Println("Login success (2FA):", user.Email)
+	return StartSession(ctx, cfg, user, client)
+}
+
+// useSecondFactor accepts a TOTP code that was not used before, or consumes a recovery code.
+func useSecondFactor(ctx context.Context, user *User, code string) bool {
+	if step, ok := validateTOTP(user.TOTPSecret, code, time.Now()); ok {
+		result, err := userCollection.UpdateOne(ctx,
+			bson.M{"_id": user.ID, "totp_last_step": bson.M{"$lt": step}},
+			bson.M{"$set": bson.M{"totp_last_step": step}},
+		)
+		if err != nil {
+			log.Println("Failed to record TOTP step", user.ID.Hex(), err)
+			return false
+		}
+		return result.ModifiedCount == 1
+	}
+
+	hash := hashRecoveryCode(code)
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "recovery_codes": hash},
+		bson.M{"$pull": bson.M{"recovery_codes": hash}},
+	)
+	if err != nil {
+		log.Println("Failed to consume recovery code", user.ID.Hex(), err)
+		return false
+	}
+	if result.ModifiedCount == 1 {
+		logSecurityEvent("recovery_code_used", "user="+user.ID.Hex())
+		return true
+	}
+	return false
+}
+
+func findUserByHexID(ctx context.Context, userID string) (*User, error) {
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("Invalid user account ID.")
+	}
+	user, err := FindUserByID(ctx, uid)
+	if err != nil {
+		return nil, errors.New("User not found")
+	}
+	return user, nil
+}
@@ -8,6 +8,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type User struct {
@@ -19,13 +20,54 @@ type User struct {
 	LoginTime    time.Time          `bson:"login_time,omitempty" json:"login_time,omitempty"`
 	IPAddress    string             `bson:"login_ip,omitempty" json:"login_ip,omitempty"`
 	UpdateTime   time.Time          `bson:"update_time,omitempty" json:"update_time,omitempty"`
+	// Roles holds global roles (admin, staff, moderator) and room-scoped roles ("room:<roomID>:moderator").
+	Roles []string `bson:"roles,omitempty" json:"roles,omitempty"`
+	// TokenVersion is embedded in every token; bumping it revokes all tokens issued before.
+	TokenVersion int64 `bson:"token_version,omitempty" json:"-"`
+	// EmailVerified is set once the user follows the link sent to Email.
+	EmailVerified   bool       `bson:"email_verified" json:"email_verified"`
+	EmailVerifiedAt *time.Time `bson:"email_verified_at,omitempty" json:"email_verified_at,omitempty"`
+	// TOTP two-factor authentication. The pending secret awaits confirmation with a code;
+	// TOTPLastStep is the last accepted time step, so a code cannot be replayed.
+	TOTPEnabled       bool   `bson:"totp_enabled,omitempty" json:"totp_enabled"`
+	TOTPSecret        string `bson:"totp_secret,omitempty" json:"-"`
+	TOTPPendingSecret string `bson:"totp_pending_secret,omitempty" json:"-"`
+	TOTPLastStep      int64  `bson:"totp_last_step,omitempty" json:"-"`
+	// RecoveryCodes holds the hashes of the unused one-time recovery codes.
+	RecoveryCodes []string `bson:"recovery_codes,omitempty" json:"-"`
+	// Identities are the external accounts (OIDC providers) the user can log in with.
+	Identities []Identity `bson:"identities,omitempty" json:"identities,omitempty"`
+	// Bot accounts log in with API keys only; CreatedBy is the staff member who created one.
+	Bot       bool   `bson:"bot,omitempty" json:"bot,omitempty"`
+	CreatedBy string `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	// DeletionRequestedAt is set when the user asks for the account to be deleted;
+	// the account cannot log in from then on.
+	DeletionRequestedAt *time.Time `bson:"deletion_requested_at,omitempty" json:"-"`
 }
 
 var userCollection *mongo.Collection
 
-// Set the MongoDB collection for users.
+// Set the MongoDB collection for users and its indexes.
+// Accounts created before email verification existed are marked verified.
 func InitUserCollection(db *mongo.Database) {
 	userCollection = db.Collection("users")
+
+	_, err := userCollection.UpdateMany(context.Background(),
+		bson.M{"email_verified": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"email_verified": true}},
+	)
+	if err != nil {
+		panic("Failed to backfill email_verified on users collection: " + err.Error())
+	}
+	// An external identity can be linked to one account only.
+	_, err = userCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"identities.provider": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		panic("Failed to create identity index on users collection: " + err.Error())
+	}
 }
 
 // Check if the given email is already registered.
@@ -78,6 +120,41 @@ func UpdateLoginInfo(ctx context.Context, userID primitive.ObjectID, ip string)
 	return err
 }
 
+// MarkEmailVerified marks the user's email verified if it is still the given address.
+func MarkEmailVerified(ctx context.Context, userID primitive.ObjectID, email string) error {
+	now := time.Now()
+	filter := bson.M{"_id": userID, "email": email}
+	update := bson.M{
+		"$set": bson.M{
+			"email_verified":    true,
+			"email_verified_at": now,
+			"update_time":       now,
+		},
+	}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// BumpTokenVersion increments the user's token version and returns the new value.
+func BumpTokenVersion(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	var user User
+	err := userCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$inc": bson.M{"token_version": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err != nil {
+		return 0, err
+	}
+	return user.TokenVersion, nil
+}
+
 // Find user by ObjectID.
 func FindUserByID(ctx context.Context, userID primitive.ObjectID) (*User, error) {
 	filter := bson.M{"_id": userID}
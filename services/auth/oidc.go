@@ -0,0 +1,213 @@
+package main
+
+// OpenID Connect client: discovery, authorization code flow with PKCE and ID token validation
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/auth"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCProviderConfig configures one OpenID Connect identity provider.
+type OIDCProviderConfig struct {
+	// Name identifies the provider in URLs and linked identities.
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+// oidcMetadata is the part of the provider's discovery document the client uses.
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCClaims are the ID token claims used to find or create the account.
+type OIDCClaims struct {
+	Email             string       `json:"email"`
+	EmailVerified     flexibleBool `json:"email_verified"`
+	Name              string       `json:"name"`
+	PreferredUsername string       `json:"preferred_username"`
+	Nonce             string       `json:"nonce"`
+	AuthorizedParty   string       `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// flexibleBool accepts both true and "true", as providers differ in how they encode email_verified.
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	*b = flexibleBool(value == "true")
+	return nil
+}
+
+// OIDCProvider runs the authorization code flow against one provider. The discovery
+// document is fetched on first use from the issuer, so any compliant server works,
+// including a local mock.
+type OIDCProvider struct {
+	cfg         OIDCProviderConfig
+	redirectURL string
+	http        *http.Client
+
+	mu       sync.Mutex
+	metadata *oidcMetadata
+	keys     *auth.JWKSClient
+}
+
+// NewOIDCProvider returns a client for the provider that redirects back to redirectURL.
+func NewOIDCProvider(cfg OIDCProviderConfig, redirectURL string, httpClient *http.Client) *OIDCProvider {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCProvider{cfg: cfg, redirectURL: redirectURL, http: httpClient}
+}
+
+// discover fetches and caches the provider's discovery document.
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcMetadata, *auth.JWKSClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, p.keys, nil
+	}
+
+	wellKnown := strings.TrimRight(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OIDC discovery for %s: unexpected status %d", p.cfg.Name, resp.StatusCode)
+	}
+
+	var metadata oidcMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, nil, fmt.Errorf("OIDC discovery for %s: %w", p.cfg.Name, err)
+	}
+	if metadata.Issuer != p.cfg.Issuer {
+		return nil, nil, fmt.Errorf("OIDC discovery for %s: issuer %q does not match %q", p.cfg.Name, metadata.Issuer, p.cfg.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, nil, fmt.Errorf("OIDC discovery for %s: incomplete provider metadata", p.cfg.Name)
+	}
+	p.metadata = &metadata
+	p.keys = auth.NewJWKSClient(metadata.JWKSURI, time.Hour)
+	return p.metadata, p.keys, nil
+}
+
+// pkceChallenge returns the S256 code challenge of a code verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the provider URL that starts the login.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	metadata, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.redirectURL)
+	query.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", pkceChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Authenticate exchanges an authorization code for tokens and returns the validated ID token claims.
+func (p *OIDCProvider) Authenticate(ctx context.Context, code, codeVerifier, nonce string) (*OIDCClaims, error) {
+	metadata, keys, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", p.cfg.ClientID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC token exchange with %s: unexpected status %d", p.cfg.Name, resp.StatusCode)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("OIDC token exchange with %s: %w", p.cfg.Name, err)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("OIDC token exchange with %s: no id_token in response", p.cfg.Name)
+	}
+	return p.verifyIDToken(tokens.IDToken, nonce, keys)
+}
+
+// verifyIDToken checks the ID token's signature, issuer, audience, expiry and nonce.
+func (p *OIDCProvider) verifyIDToken(rawIDToken, nonce string, keys *auth.JWKSClient) (*OIDCClaims, error) {
+	claims := &OIDCClaims{}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(auth.DefaultAlgorithms),
+		jwt.WithIssuer(p.cfg.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if _, err := parser.ParseWithClaims(rawIDToken, claims, keys.Keyfunc); err != nil {
+		return nil, fmt.Errorf("invalid ID token from %s: %w", p.cfg.Name, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("invalid ID token from %s: missing sub", p.cfg.Name)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("invalid ID token from " + p.cfg.Name + ": nonce mismatch")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.cfg.ClientID {
+		return nil, errors.New("invalid ID token from " + p.cfg.Name + ": unexpected authorized party")
+	}
+	return claims, nil
+}
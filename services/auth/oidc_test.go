@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testClientID = "chatorbit"
+
+// mockOIDCProvider is a minimal OpenID provider. Its token endpoint checks the
+// PKCE verifier against the challenge of the last authorization URL and returns
+// an ID token built from idClaims.
+type mockOIDCProvider struct {
+	*httptest.Server
+	key       ed25519.PrivateKey
+	challenge string
+	idClaims  *OIDCClaims
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDCProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(oidcMetadata{
+			Issuer:                m.URL,
+			AuthorizationEndpoint: m.URL + "/authorize",
+			TokenEndpoint:         m.URL + "/token",
+			JWKSURI:               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, _ *http.Request) {
+		jwk, err := auth.NewJWK("mock", key.Public())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(auth.JWKS{Keys: []auth.JWK{jwk}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "code" || pkceChallenge(r.PostFormValue("code_verifier")) != m.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, m.idClaims)
+		token.Header["kid"] = "mock"
+		signed, err := token.SignedString(m.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockOIDCProvider) provider() *OIDCProvider {
+	cfg := OIDCProviderConfig{Name: "mock", Issuer: m.URL, ClientID: testClientID, Scopes: []string{"openid", "email"}}
+	return NewOIDCProvider(cfg, "http://localhost:8089/oauth/mock/callback", m.Client())
+}
+
+// start runs AuthCodeURL like StartOIDCLogin and records the PKCE challenge the provider received.
+func (m *mockOIDCProvider) start(t *testing.T, p *OIDCProvider, state, nonce, verifier string) url.Values {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	m.challenge = query.Get("code_challenge")
+	return query
+}
+
+func (m *mockOIDCProvider) validClaims(nonce string) *OIDCClaims {
+	now := time.Now()
+	return &OIDCClaims{
+		Email:         "ada@example.com",
+		EmailVerified: true,
+		Nonce:         nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.URL,
+			Subject:   "provider-user-1",
+			Audience:  jwt.ClaimStrings{testClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	}
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	m := newMockOIDCProvider(t)
+	query := m.start(t, m.provider(), "state-1", "nonce-1", "verifier-1")
+
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"state":                 "state-1",
+		"nonce":                 "nonce-1",
+		"code_challenge":        pkceChallenge("verifier-1"),
+		"code_challenge_method": "S256",
+	}
+	for key, value := range want {
+		if got := query.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	if query.Get("code_verifier") != "" {
+		t.Error("the code verifier must not leave the server")
+	}
+}
+
+func TestOIDCAuthenticate(t *testing.T) {
+	m := newMockOIDCProvider(t)
+	tests := []struct {
+		name     string
+		verifier string
+		nonce    string
+		claims   func(*OIDCClaims)
+		wantErr  bool
+	}{
+		{name: "valid", verifier: "verifier", nonce: "nonce"},
+		{name: "wrong PKCE verifier", verifier: "other-verifier", nonce: "nonce", wantErr: true},
+		{name: "nonce mismatch", verifier: "verifier", nonce: "other-nonce", wantErr: true},
+		{
+			name: "wrong audience", verifier: "verifier", nonce: "nonce", wantErr: true,
+			claims: func(c *OIDCClaims) { c.Audience = jwt.ClaimStrings{"someone-else"} },
+		},
+		{
+			name: "wrong issuer", verifier: "verifier", nonce: "nonce", wantErr: true,
+			claims: func(c *OIDCClaims) { c.Issuer = "https://evil.example.com" },
+		},
+		{
+			name: "expired", verifier: "verifier", nonce: "nonce", wantErr: true,
+			claims: func(c *OIDCClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-2 * time.Minute)) },
+		},
+		{
+			name: "missing subject", verifier: "verifier", nonce: "nonce", wantErr: true,
+			claims: func(c *OIDCClaims) { c.Subject = "" },
+		},
+		{
+			name: "several audiences without azp", verifier: "verifier", nonce: "nonce", wantErr: true,
+			claims: func(c *OIDCClaims) { c.Audience = jwt.ClaimStrings{testClientID, "other-client"} },
+		},
+		{
+			name: "several audiences with azp", verifier: "verifier", nonce: "nonce",
+			claims: func(c *OIDCClaims) {
+				c.Audience = jwt.ClaimStrings{testClientID, "other-client"}
+				c.AuthorizedParty = testClientID
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := m.provider()
+			// The login was started with "nonce" and "verifier"; the callback presents tt's values.
+			m.start(t, p, "state", "nonce", "verifier")
+			m.idClaims = m.validClaims("nonce")
+			if tt.claims != nil {
+				tt.claims(m.idClaims)
+			}
+
+			claims, err := p.Authenticate(context.Background(), "code", tt.verifier, tt.nonce)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Authenticate() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if claims.Subject != "provider-user-1" || claims.Email != "ada@example.com" || !bool(claims.EmailVerified) {
+				t.Fatalf("Authenticate() claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	m := newMockOIDCProvider(t)
+	cfg := OIDCProviderConfig{Name: "mock", Issuer: m.URL + "/", ClientID: testClientID}
+	p := NewOIDCProvider(cfg, "http://localhost:8089/oauth/mock/callback", m.Client())
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Fatal("AuthCodeURL() accepted a discovery document for another issuer")
+	}
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/oauth/:provider/callback", OIDCCallbackHandler(Config{}))
+
+	tests := []struct {
+		name   string
+		query  string
+		cookie string
+	}{
+		{"no cookie", "?state=abc&code=code", ""},
+		{"cookie of another login", "?state=abc&code=code", "xyz"},
+		{"no state", "?code=code", "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/oauth/mock/callback"+tt.query, nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrInvalidOIDCState.Error()) {
+				t.Fatalf("response = %d %s, want 400 invalid state", w.Code, w.Body.String())
+			}
+		})
+	}
+}
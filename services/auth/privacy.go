@@ -0,0 +1,316 @@
+package main
+
+// Privacy jobs: data exports and account deletions carried out by every service
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/privacy"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrPrivacyJobNotFound = errors.New("Job not found.")
+	ErrExportNotReady     = errors.New("The export is not ready yet.")
+	// ErrReauthenticationRequired is returned when an account without a password asks for
+	// its deletion from a session that did not log in recently.
+	ErrReauthenticationRequired = errors.New("Please log in again to confirm.")
+)
+
+// PrivacyConfig controls data export and account deletion jobs.
+type PrivacyConfig struct {
+	// DeletionPolicy is privacy.PolicyAnonymize or privacy.PolicyDelete.
+	DeletionPolicy string
+	// Retention is how long finished jobs and their export archives are kept.
+	Retention time.Duration
+	// PollInterval is how often the worker looks for new tasks.
+	PollInterval time.Duration
+	// ReauthWindow is how recently an account without a password must have logged in
+	// to the session asking for its deletion.
+	ReauthWindow time.Duration
+}
+
+func parseDeletionPolicy(value string) string {
+	if strings.EqualFold(strings.TrimSpace(value), privacy.PolicyDelete) {
+		return privacy.PolicyDelete
+	}
+	return privacy.PolicyAnonymize
+}
+
+// PrivacyTask is one service's part of a privacy job.
+type PrivacyTask struct {
+	Status      string     `json:"status"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// PrivacyJob is the status of a data export or an account deletion, overall and per service.
+type PrivacyJob struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Policy is the deletion policy in force when the deletion was requested.
+	Policy string `json:"policy,omitempty"`
+	// Status is pending, running, completed or failed.
+	Status      string                 `json:"status"`
+	Tasks       map[string]PrivacyTask `json:"tasks"`
+	CreatedAt   time.Time              `json:"created_at"`
+	CompletedAt *time.Time             `json:"completed_at,omitempty"`
+	ExpiresAt   time.Time              `json:"expires_at"`
+}
+
+func newPrivacyJob(job *privacy.Job) *PrivacyJob {
+	tasks := make(map[string]PrivacyTask, len(job.Tasks))
+	for service, task := range job.Tasks {
+		tasks[service] = PrivacyTask{Status: task.Status, StartedAt: task.StartedAt, CompletedAt: task.CompletedAt}
+	}
+	return &PrivacyJob{
+		ID:          job.ID,
+		Type:        job.Type,
+		Policy:      job.Policy,
+		Status:      job.Status,
+		Tasks:       tasks,
+		CreatedAt:   job.CreatedAt,
+		CompletedAt: job.CompletedAt,
+		ExpiresAt:   job.ExpiresAt,
+	}
+}
+
+var privacyStore *privacy.Store
+
+// InitPrivacyCollections sets the privacy job store and creates its indexes.
+func InitPrivacyCollections(db *mongo.Database) {
+	privacyStore = privacy.NewStore(db)
+	if err := privacyStore.CreateIndexes(context.Background()); err != nil {
+		panic("Failed to create indexes on privacy collections: " + err.Error())
+	}
+}
+
+// RequestDataExport queues an export of everything the services hold about the user.
+func RequestDataExport(ctx context.Context, cfg Config, userID string) (*PrivacyJob, error) {
+	user, err := findUserByHexID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	job, err := privacyStore.Start(ctx, user.ID.Hex(), privacy.Export, "", cfg.Privacy.Retention)
+	if err != nil {
+		return nil, err
+	}
+	logSecurityEvent("data_export_requested", "user="+userID+" job="+job.ID)
+	return newPrivacyJob(job), nil
+}
+
+// RequestAccountDeletion checks the password, and the second factor if enabled,
+// then logs the user out everywhere and queues the deletion of their data under
+// the configured policy. Accounts without a password (social login only) instead
+// need a session that logged in within cfg.Privacy.ReauthWindow.
+func RequestAccountDeletion(ctx context.Context, cfg Config, userID, sessionID, password, code, ip string) (*PrivacyJob, error) {
+	user, err := findUserByHexID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := loginGuard.Check(ctx, user.Email, ip); err != nil {
+		return nil, err
+	}
+	if user.PasswordHash != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+			loginGuard.RecordFailure(ctx, user.Email, ip)
+			return nil, ErrInvalidCredentials
+		}
+	} else if err := requireRecentLogin(ctx, user.ID, sessionID, cfg.Privacy.ReauthWindow); err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled && !useSecondFactor(ctx, user, code) {
+		loginGuard.RecordFailure(ctx, user.Email, ip)
+		return nil, ErrInvalidMFACode
+	}
+
+	job, err := privacyStore.Start(ctx, user.ID.Hex(), privacy.Delete, cfg.Privacy.DeletionPolicy, cfg.Privacy.Retention)
+	if err != nil {
+		return nil, err
+	}
+	// The worker locks the account again, should this fail.
+	if err := lockAccount(ctx, user.ID); err != nil {
+		log.Println("[DeleteAccount] Failed to lock account", userID, ":", err)
+	}
+	logSecurityEvent("account_deletion_requested", "user="+userID+" job="+job.ID+" policy="+job.Policy)
+	return newPrivacyJob(job), nil
+}
+
+// requireRecentLogin checks that the user's session was created by a login within
+// window. Refreshing tokens keeps a session going but does not count as a login.
+func requireRecentLogin(ctx context.Context, uid primitive.ObjectID, sessionID string, window time.Duration) error {
+	if sessionID == "" {
+		return ErrReauthenticationRequired
+	}
+	var session Session
+	err := sessionCollection.FindOne(ctx, bson.M{"_id": sessionID, "user_id": uid}).Decode(&session)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrReauthenticationRequired
+	}
+	if err != nil {
+		return err
+	}
+	if time.Since(session.CreatedAt) > window {
+		return ErrReauthenticationRequired
+	}
+	return nil
+}
+
+// lockAccount keeps an account being deleted from logging in and revokes all of its tokens.
+func lockAccount(ctx context.Context, uid primitive.ObjectID) error {
+	_, err := userCollection.UpdateOne(ctx,
+		bson.M{"_id": uid, "deletion_requested_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deletion_requested_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	return revokeAllTokens(ctx, uid, RevokedAccountDeleted)
+}
+
+// GetPrivacyJob returns a job with its status.
+func GetPrivacyJob(ctx context.Context, jobID string) (*PrivacyJob, error) {
+	job, err := privacyStore.Get(ctx, jobID)
+	if errors.Is(err, privacy.ErrJobNotFound) {
+		return nil, ErrPrivacyJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newPrivacyJob(job), nil
+}
+
+// CompletedExport returns the user's export job once every service has written its files.
+func CompletedExport(ctx context.Context, userID, jobID string) (*privacy.Job, error) {
+	job, err := privacyStore.Get(ctx, jobID)
+	if errors.Is(err, privacy.ErrJobNotFound) {
+		return nil, ErrPrivacyJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if job.Type != privacy.Export || job.UserID != userID {
+		return nil, ErrPrivacyJobNotFound
+	}
+	if job.Status != privacy.StatusCompleted {
+		return nil, ErrExportNotReady
+	}
+	return job, nil
+}
+
+// WriteExportArchive writes the files of an export job as a zip archive with a
+// folder per service.
+func WriteExportArchive(ctx context.Context, w io.Writer, job *privacy.Job) error {
+	return privacyStore.WriteArchive(ctx, w, job)
+}
+
+// NewPrivacyWorker returns the worker carrying out this service's tasks of privacy jobs.
+func NewPrivacyWorker() *privacy.Worker {
+	return privacy.NewWorker(privacyStore, privacy.ServiceAuth, exportAccountData, deleteAccountData, reportPrivacyTask)
+}
+
+func reportPrivacyTask(job *privacy.Job, err error) {
+	switch {
+	case job == nil:
+		log.Println("[Privacy] Failed to claim task:", err)
+	case err != nil:
+		log.Println("[Privacy]", job.Type, "job", job.ID, "failed:", err)
+	}
+}
+
+// accountExport is the account data held by the auth service. Secrets such as the
+// password hash and TOTP secret are left out.
+type accountExport struct {
+	ID              string     `json:"id"`
+	Email           string     `json:"email"`
+	Username        string     `json:"username"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastLoginAt     time.Time  `json:"last_login_at"`
+	LastLoginIP     string     `json:"last_login_ip,omitempty"`
+	EmailVerified   bool       `json:"email_verified"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	TOTPEnabled     bool       `json:"totp_enabled"`
+	Identities      []Identity `json:"identities,omitempty"`
+}
+
+// exportAccountData writes the user's account and sessions to the archive.
+func exportAccountData(ctx context.Context, job *privacy.Job) error {
+	uid, err := primitive.ObjectIDFromHex(job.UserID)
+	if err != nil {
+		return err
+	}
+	user, err := FindUserByID(ctx, uid)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	account := accountExport{
+		ID:              user.ID.Hex(),
+		Email:           user.Email,
+		Username:        user.Username,
+		CreatedAt:       user.CreateTime,
+		LastLoginAt:     user.LoginTime,
+		LastLoginIP:     user.IPAddress,
+		EmailVerified:   user.EmailVerified,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		TOTPEnabled:     user.TOTPEnabled,
+		Identities:      user.Identities,
+	}
+	if err := privacyStore.WriteFile(ctx, job, privacy.ServiceAuth, "account.json", account); err != nil {
+		return err
+	}
+	sessions, err := ListSessions(ctx, job.UserID, "")
+	if err != nil {
+		return err
+	}
+	return privacyStore.WriteFile(ctx, job, privacy.ServiceAuth, "sessions.json", sessions)
+}
+
+// deleteAccountData revokes the user's tokens and removes their credentials, sessions
+// and login history. The profile itself is anonymized or removed by the user service.
+func deleteAccountData(ctx context.Context, job *privacy.Job) error {
+	uid, err := primitive.ObjectIDFromHex(job.UserID)
+	if err != nil {
+		return err
+	}
+	// The user service may already have removed the user document.
+	if err := lockAccount(ctx, uid); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	if err := RevokeUserRefreshTokens(ctx, uid, RevokedAccountDeleted); err != nil {
+		return err
+	}
+	if _, err := refreshTokenCollection.DeleteMany(ctx, bson.M{"user_id": uid}); err != nil {
+		return err
+	}
+	if _, err := passwordResetCollection.DeleteMany(ctx, bson.M{"user_id": uid}); err != nil {
+		return err
+	}
+	_, err = userCollection.UpdateOne(ctx, bson.M{"_id": uid}, bson.M{"$unset": bson.M{
+		"password_hash":       "",
+		"login_time":          "",
+		"login_ip":            "",
+		"email_verified_at":   "",
+		"totp_enabled":        "",
+		"totp_secret":         "",
+		"totp_pending_secret": "",
+		"totp_last_step":      "",
+		"recovery_codes":      "",
+		"identities":          "",
+	}})
+	if err != nil {
+		return err
+	}
+	logSecurityEvent("account_data_deleted", "user="+job.UserID+" job="+job.ID)
+	return nil
+}
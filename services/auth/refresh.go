@@ -0,0 +1,196 @@
+package main
+
+// Refresh tokens: opaque, stored hashed, rotated on every use
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidRefreshToken is returned for unknown, expired, revoked or reused refresh tokens.
+var ErrInvalidRefreshToken = errors.New("Invalid refresh token.")
+
+// Reasons a refresh token family is revoked.
+const (
+	RevokedLogout         = "logout"
+	RevokedLogoutAll      = "logout_all"
+	RevokedReuse          = "reuse_detected"
+	RevokedPasswordReset  = "password_reset"
+	RevokedSession        = "session_revoked"
+	RevokedAccountDeleted = "account_deleted"
+)
+
+// RefreshToken is one issued refresh token. Tokens issued by rotating each other
+// share a FamilyID, which starts at login and is the ID of the login's Session.
+type RefreshToken struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	UserID        primitive.ObjectID `bson:"user_id"`
+	FamilyID      string             `bson:"family_id"`
+	TokenHash     string             `bson:"token_hash"`
+	CreatedAt     time.Time          `bson:"created_at"`
+	ExpiresAt     time.Time          `bson:"expires_at"`
+	RotatedAt     *time.Time         `bson:"rotated_at,omitempty"`
+	RevokedAt     *time.Time         `bson:"revoked_at,omitempty"`
+	RevokedReason string             `bson:"revoked_reason,omitempty"`
+}
+
+// TokenPair is what a successful login or refresh returns. For accounts with 2FA
+// the password check only yields an MFAToken, exchanged for the pair by CompleteMFALogin.
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	MFAToken     string
+}
+
+var refreshTokenCollection *mongo.Collection
+
+// InitRefreshTokenCollection sets the refresh token collection and its indexes.
+// Expired tokens are removed by a TTL index.
+func InitRefreshTokenCollection(db *mongo.Database) {
+	refreshTokenCollection = db.Collection("refresh_tokens")
+
+	_, err := refreshTokenCollection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "family_id", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+	)
+	if err != nil {
+		panic("Failed to create indexes on refresh_tokens collection: " + err.Error())
+	}
+}
+
+// randomToken returns n random bytes, URL-safe encoded.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the value stored for an opaque token. Tokens are random,
+// so a plain SHA-256 is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueTokens creates an access token and a refresh token for the user in the
+// session's refresh token family. New logins go through StartSession.
+func IssueTokens(ctx context.Context, cfg Config, user *User, familyID string) (*TokenPair, error) {
+	accessToken, err := GenerateJWT(cfg, user, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	_, err = refreshTokenCollection.InsertOne(ctx, RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(refreshToken),
+		CreatedAt: now,
+		ExpiresAt: now.Add(cfg.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new token pair in the same family.
+// Presenting a token that was already rotated means it leaked, so the whole session
+// is revoked, including its access tokens, and the caller has to log in again.
+func RotateRefreshToken(ctx context.Context, cfg Config, refreshToken string, client ClientInfo) (*TokenPair, error) {
+	var current RefreshToken
+	err := refreshTokenCollection.FindOne(ctx, bson.M{"token_hash": hashToken(refreshToken)}).Decode(&current)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// Mark the token rotated only if nobody else did; losing the race counts as reuse.
+	now := time.Now()
+	result, err := refreshTokenCollection.UpdateOne(ctx,
+		bson.M{"_id": current.ID, "rotated_at": bson.M{"$exists": false}, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"rotated_at": now}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.ModifiedCount == 0 {
+		log.Println("Refresh token reuse detected, revoking family", current.FamilyID, "user", current.UserID.Hex())
+		if err := revokeSession(ctx, cfg, current.UserID.Hex(), current.FamilyID, RevokedReuse); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := FindUserByID(ctx, current.UserID)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err := touchSession(ctx, cfg, current.FamilyID, client); err != nil {
+		log.Println("Failed to update session", current.FamilyID, err)
+	}
+	return IssueTokens(ctx, cfg, user, current.FamilyID)
+}
+
+// RevokeRefreshFamily revokes every token of a family and ends its session.
+func RevokeRefreshFamily(ctx context.Context, familyID, reason string) error {
+	_, err := refreshTokenCollection.UpdateMany(ctx,
+		bson.M{"family_id": familyID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now(), "revoked_reason": reason}},
+	)
+	if err != nil {
+		return err
+	}
+	return deleteSessions(ctx, bson.M{"_id": familyID})
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user and ends all of the user's sessions.
+func RevokeUserRefreshTokens(ctx context.Context, userID primitive.ObjectID, reason string) error {
+	_, err := refreshTokenCollection.UpdateMany(ctx,
+		bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now(), "revoked_reason": reason}},
+	)
+	if err != nil {
+		return err
+	}
+	return deleteSessions(ctx, bson.M{"user_id": userID})
+}
+
+// RevokeRefreshToken revokes the session of the given refresh token, if it exists.
+func RevokeRefreshToken(ctx context.Context, cfg Config, refreshToken, reason string) error {
+	var current RefreshToken
+	err := refreshTokenCollection.FindOne(ctx, bson.M{"token_hash": hashToken(refreshToken)}).Decode(&current)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return revokeSession(ctx, cfg, current.UserID.Hex(), current.FamilyID, reason)
+}
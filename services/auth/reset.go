@@ -0,0 +1,211 @@
+package main
+
+// Password reset: single-use tokens, stored hashed, sent by the mailer
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidResetToken is returned for unknown, expired or already used reset tokens.
+var ErrInvalidResetToken = errors.New("Invalid or expired password reset token.")
+
+// PasswordReset is one issued password reset token.
+type PasswordReset struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	TokenHash string             `bson:"token_hash"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty"`
+}
+
+var passwordResetCollection *mongo.Collection
+
+// InitPasswordResetCollection sets the password reset collection and its indexes.
+// Expired tokens are removed by a TTL index.
+func InitPasswordResetCollection(db *mongo.Database) {
+	passwordResetCollection = db.Collection("password_resets")
+
+	_, err := passwordResetCollection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+	)
+	if err != nil {
+		panic("Failed to create indexes on password_resets collection: " + err.Error())
+	}
+}
+
+// PasswordResetLimitConfig limits how often reset links can be requested.
+type PasswordResetLimitConfig struct {
+	// MaxPerEmail and MaxPerIP are the requests allowed within Window for one email
+	// address and one client IP. 0 disables a limit.
+	MaxPerEmail int
+	MaxPerIP    int
+	Window      time.Duration
+}
+
+// ResetRateLimitedError is returned when an email address or client IP asked for too many reset links.
+type ResetRateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ResetRateLimitedError) Error() string {
+	return "Too many password reset requests. Try again later."
+}
+
+// resetLimits counts reset requests per email address and per client IP.
+var resetLimits *redis.Client
+
+// InitPasswordResetLimits sets the Redis client that rate-limits reset requests.
+func InitPasswordResetLimits(client *redis.Client) {
+	resetLimits = client
+}
+
+// hashEmail identifies an email address in logs and counters without storing it.
+func hashEmail(email string) string {
+	return hashToken(strings.ToLower(strings.TrimSpace(email)))
+}
+
+// takeResetSlot counts a reset request against the email address and the client IP. Failures to reach Redis are logged and let the
+// request proceed.
+func takeResetSlot(ctx context.Context, cfg PasswordResetLimitConfig, email, ip string) error {
+	scopes := []struct {
+		name  string
+		id    string
+		limit int
+	}{
+		{name: "email", id: hashEmail(email), limit: cfg.MaxPerEmail},
+		{name: "ip", id: ip, limit: cfg.MaxPerIP},
+	}
+	var retryAfter time.Duration
+	for _, scope := range scopes {
+		if scope.limit <= 0 {
+			continue
+		}
+		key := "auth:password_resets:" + scope.name + ":" + scope.id
+		pipe := resetLimits.TxPipeline()
+		incr := pipe.Incr(ctx, key)
+		ttl := pipe.PTTL(ctx, key)
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Println("[ForgotPassword] Failed to count request:", err)
+			continue
+		}
+		// The window starts with the first request; a key left without expiry is repaired too.
+		window := ttl.Val()
+		if window < 0 {
+			window = cfg.Window
+			if err := resetLimits.Expire(ctx, key, cfg.Window).Err(); err != nil {
+				log.Println("[ForgotPassword] Failed to expire request counter:", err)
+			}
+		}
+		if incr.Val() > int64(scope.limit) && window > retryAfter {
+			retryAfter = window
+			logSecurityEvent("password_reset_limited", fmt.Sprintf("scope=%s id=%s requests=%d", scope.name, scope.id, incr.Val()))
+		}
+	}
+	if retryAfter > 0 {
+		return &ResetRateLimitedError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// RequestPasswordReset emails a reset link when the address belongs to an account.
+// Only the newest link of a user works. It reports nothing about whether the account exists.
+func RequestPasswordReset(ctx context.Context, cfg Config, email string) {
+	user, err := FindUserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Println("[ForgotPassword] Lookup failed for", email, ":", err)
+		}
+		return
+	}
+	// Bots authenticate with API keys only and never get a password; accounts
+	// being deleted cannot log in again.
+	if user.Bot || user.DeletionRequestedAt != nil {
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		log.Println("[ForgotPassword] Failed to generate token:", err)
+		return
+	}
+	if _, err := passwordResetCollection.DeleteMany(ctx, bson.M{"user_id": user.ID}); err != nil {
+		log.Println("[ForgotPassword] Failed to remove previous tokens for", email, ":", err)
+		return
+	}
+	now := time.Now()
+	_, err = passwordResetCollection.InsertOne(ctx, PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(cfg.PasswordResetTTL),
+	})
+	if err != nil {
+		log.Println("[ForgotPassword] Failed to store token for", email, ":", err)
+		return
+	}
+
+	link := cfg.PasswordResetURL + "?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your ChatOrbit account. Choose a new password here:\n\n%s\n\nThe link expires in %s and works once. If you did not ask for this, ignore this email.\n",
+		user.Username, link, cfg.PasswordResetTTL)
+	if err := mailer.Send(ctx, Mail{To: user.Email, Subject: "Reset your ChatOrbit password", Body: body}); err != nil {
+		log.Println("[ForgotPassword] Failed to send email to", email, ":", err)
+	}
+}
+
+// ResetPassword consumes a reset token, sets the new password and logs the user out
+// everywhere. Receiving the email proves the address, so it is also marked verified.
+func ResetPassword(ctx context.Context, token, newPassword string) error {
+	now := time.Now()
+	var reset PasswordReset
+	err := passwordResetCollection.FindOneAndUpdate(ctx,
+		bson.M{
+			"token_hash": hashToken(token),
+			"used_at":    bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"used_at": now}},
+	).Decode(&reset)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	user, err := FindUserByID(ctx, reset.UserID)
+	if err != nil {
+		return ErrInvalidResetToken
+	}
+
+	newHashed, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := UpdateUserPassword(ctx, user.ID, string(newHashed)); err != nil {
+		return err
+	}
+	if !user.EmailVerified {
+		if err := MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+			log.Println("[ResetPassword] Failed to mark email verified for", user.ID.Hex(), ":", err)
+		}
+	}
+	return revokeAllTokens(ctx, user.ID, RevokedPasswordReset)
+}
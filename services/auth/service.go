@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -15,11 +16,13 @@ import (
 //
 // It first checks if the email already exists in the database. If not,
 // it hashes the user's password using bcrypt, creates a new User object,
-// and inserts it into the database.
+// and inserts it into the database. The account starts unverified and is
+// sent an email verification link.
 //
 // Parameters:
 //
 //	ctx: The context for the request.
+//	cfg: The service configuration.
 //	email: The email address for the new user.
 //	username: The username for the new user.
 //	password: The plaintext password.
@@ -29,7 +32,7 @@ import (
 //
 //	An error if the email is already registered, password hashing fails,
 //	or the database insertion fails.
-func RegisterUser(ctx context.Context, email, username string, password string, ip string) error {
+func RegisterUser(ctx context.Context, cfg Config, email, username string, password string, ip string) error {
 	// Check if email already exists
 	exists, err := IsEmailExists(ctx, email)
 	if err != nil {
@@ -47,6 +50,7 @@ func RegisterUser(ctx context.Context, email, username string, password string,
 
 	// Create User object
 	user := User{
+		ID:           primitive.NewObjectID(),
 		Email:        email,
 		Username:     username,
 		PasswordHash: string(hashedPassword),
@@ -54,47 +58,132 @@ func RegisterUser(ctx context.Context, email, username string, password string,
 		IPAddress:    ip,
 	}
 
-	return InsertUser(ctx, user)
+	if err := InsertUser(ctx, user); err != nil {
+		return err
+	}
+
+	// The account exists either way; a failed email can be resent.
+	if err := SendVerificationEmail(ctx, cfg, &user); err != nil {
+		log.Println("Failed to send verification email", email, err)
+	}
+	return nil
 }
 
+// ErrInvalidCredentials is the single error for a wrong email or password, so
+// failed logins do not reveal whether an account exists.
+var ErrInvalidCredentials = errors.New("Invalid email or password.")
+
+// dummyPasswordHash is compared against when the email is unknown, so such logins
+// take as long as a wrong password.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("chatorbit-unknown-account"), bcrypt.DefaultCost)
+
 // LoginUser authenticates a user by their email and password.
 //
-// It first finds a user by their email, then compares the provided password
-// with the stored hashed password. If authentication is successful,
-// it generates and returns a new JWT.
+// It first checks that neither the account nor the client IP is locked out,
+// then finds the user by email and compares the provided password with the
+// stored hash. Failures are counted by the login guard. If authentication is
+// successful, it generates and returns a new JWT.
 //
 // Parameters:
 //
 //	ctx: The context for the request.
 //	email: The user's email address.
 //	password: The plaintext password provided by the user.
+//	client: The client IP address and User-Agent, recorded on the new session.
 //
 // Returns:
 //
-//	A JWT string if the login is successful.
-//	An error if the user is not found, the password is incorrect, or token generation fails.
-func LoginUser(ctx context.Context, email, password string) (string, error) {
+//	An access token and a refresh token starting a new session if the login is successful,
+//	or only an MFA token if the account has two-factor authentication enabled.
+//	A LoginLockedError while the account or IP is locked out.
+//	ErrInvalidCredentials if the user is not found or the password is incorrect.
+//	ErrEmailNotVerified if the account must verify its email first.
+//	An error if token generation fails.
+func LoginUser(ctx context.Context, cfg Config, email, password string, client ClientInfo) (*TokenPair, error) {
+	ip := client.IP
+	if err := loginGuard.Check(ctx, email, ip); err != nil {
+		log.Println("Login refused (locked out)", email, ip)
+		return nil, err
+	}
+
 	user, err := FindUserByEmail(ctx, email)
 	if err != nil {
-		log.Println("Login failed (email)", email, err)
-		return "", errors.New("Email is incorrect. Error: " + err.Error())
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Println("Login failed (lookup)", email, err)
+			return nil, err
+		}
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		log.Println("Login failed (unknown email)", email)
+		loginGuard.RecordFailure(ctx, email, ip)
+		return nil, ErrInvalidCredentials
+	}
+
+	if user.Bot {
+		log.Println("Login failed (bot account)", email)
+		loginGuard.RecordFailure(ctx, email, ip)
+		return nil, ErrInvalidCredentials
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
 	if err != nil {
-		log.Println("Login failed (password)", email, err)
-		return "", errors.New("Password is incorrect. Error: " + err.Error())
+		log.Println("Login failed (password)", email)
+		loginGuard.RecordFailure(ctx, email, ip)
+		return nil, ErrInvalidCredentials
+	}
+	loginGuard.RecordSuccess(ctx, email)
+
+	if cfg.RequireEmailVerification && !user.EmailVerified {
+		log.Println("Login failed (email not verified)", email)
+		return nil, ErrEmailNotVerified
 	}
 
-	token, err := GenerateJWT(user.ID.Hex(), user.Email)
+	tokens, err := issueLoginTokens(ctx, cfg, user, client)
 	if err != nil {
 		log.Println("JWT error", email, err)
-		return "", errors.New("Failed to generate JWT: " + err.Error())
+		return nil, err
 	}
 
 	log.Println("Login success:", user.Email)
 
-	return token, nil
+	return tokens, nil
+}
+
+// issueLoginTokens starts a new session for an authenticated user, or only issues an
+// MFA token when the account has two-factor authentication.
+func issueLoginTokens(ctx context.Context, cfg Config, user *User, client ClientInfo) (*TokenPair, error) {
+	if user.TOTPEnabled {
+		mfaToken, err := GenerateMFAToken(cfg, user)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("Login accepted, 2FA required:", user.Email)
+		return &TokenPair{MFAToken: mfaToken}, nil
+	}
+	return StartSession(ctx, cfg, user, client)
+}
+
+// LogoutEverywhere revokes every access and refresh token of a user by bumping
+// the user's token version and revoking all refresh token families.
+func LogoutEverywhere(ctx context.Context, userID string) error {
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("Invalid user account ID.")
+	}
+
+	return revokeAllTokens(ctx, uid, RevokedLogoutAll)
+}
+
+// revokeAllTokens bumps the user's token version, which invalidates every access
+// token issued before, and revokes all of the user's refresh tokens.
+func revokeAllTokens(ctx context.Context, uid primitive.ObjectID, reason string) error {
+	version, err := BumpTokenVersion(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if err := tokenRevocations.SetTokenVersion(ctx, uid.Hex(), version); err != nil {
+		return err
+	}
+	return RevokeUserRefreshTokens(ctx, uid, reason)
 }
 
 // ChangePassword handles updating a user's password.
@@ -0,0 +1,243 @@
+package main
+
+// Login sessions: one per login and device, listed and revoked by the user
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxUserAgentLength caps the User-Agent stored on a session.
+const maxUserAgentLength = 512
+
+// ErrSessionNotFound is returned for unknown sessions and sessions of other users.
+var ErrSessionNotFound = errors.New("Session not found.")
+
+// ClientInfo describes the client a request comes from.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// Session is a login on one device. Its ID is the refresh token family of the login
+// and the sid claim of its access tokens.
+type Session struct {
+	ID        string             `bson:"_id" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"-"`
+	Device    string             `bson:"device" json:"device"`
+	UserAgent string             `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	IPAddress string             `bson:"ip,omitempty" json:"ip,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	// LastSeenAt is updated when the session logs in or refreshes its tokens.
+	LastSeenAt time.Time `bson:"last_seen_at" json:"last_seen_at"`
+	ExpiresAt  time.Time `bson:"expires_at" json:"expires_at"`
+	// Current marks the session of the request listing the sessions.
+	Current bool `bson:"-" json:"current"`
+}
+
+var sessionCollection *mongo.Collection
+
+// InitSessionCollection sets the session collection and its indexes.
+// Sessions whose refresh tokens expired are removed by a TTL index.
+func InitSessionCollection(db *mongo.Database) {
+	sessionCollection = db.Collection("sessions")
+
+	_, err := sessionCollection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_seen_at", Value: -1}}},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+	)
+	if err != nil {
+		panic("Failed to create indexes on sessions collection: " + err.Error())
+	}
+}
+
+// StartSession records a new login of the user from the client and issues its tokens.
+// Accounts being deleted cannot log in.
+func StartSession(ctx context.Context, cfg Config, user *User, client ClientInfo) (*TokenPair, error) {
+	if user.DeletionRequestedAt != nil {
+		return nil, ErrInvalidCredentials
+	}
+	id, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	userAgent := client.UserAgent
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	now := time.Now()
+	_, err = sessionCollection.InsertOne(ctx, Session{
+		ID:         id,
+		UserID:     user.ID,
+		Device:     describeDevice(userAgent),
+		UserAgent:  userAgent,
+		IPAddress:  client.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(cfg.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return IssueTokens(ctx, cfg, user, id)
+}
+
+// touchSession records that a session refreshed its tokens from the client.
+// Refresh families from before sessions existed have no record and are skipped.
+func touchSession(ctx context.Context, cfg Config, sessionID string, client ClientInfo) error {
+	now := time.Now()
+	set := bson.M{"last_seen_at": now, "expires_at": now.Add(cfg.RefreshTokenTTL)}
+	if client.IP != "" {
+		set["ip"] = client.IP
+	}
+	_, err := sessionCollection.UpdateOne(ctx, bson.M{"_id": sessionID}, bson.M{"$set": set})
+	return err
+}
+
+// ListSessions returns the user's active sessions, most recently seen first.
+func ListSessions(ctx context.Context, userID, currentSessionID string) ([]Session, error) {
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("Invalid user account ID.")
+	}
+	cursor, err := sessionCollection.Find(ctx,
+		bson.M{"user_id": uid, "expires_at": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+	return sessions, nil
+}
+
+// RevokeSession logs out one of the user's sessions.
+func RevokeSession(ctx context.Context, cfg Config, userID, sessionID string) error {
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("Invalid user account ID.")
+	}
+	var session Session
+	err = sessionCollection.FindOne(ctx, bson.M{"_id": sessionID, "user_id": uid}).Decode(&session)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return revokeSession(ctx, cfg, session.UserID.Hex(), session.ID, RevokedSession)
+}
+
+// RevokeOtherSessions logs out every session of the user except the current one.
+func RevokeOtherSessions(ctx context.Context, cfg Config, userID, currentSessionID string) error {
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("Invalid user account ID.")
+	}
+	cursor, err := sessionCollection.Find(ctx, bson.M{"user_id": uid, "_id": bson.M{"$ne": currentSessionID}})
+	if err != nil {
+		return err
+	}
+	var sessions []Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return err
+	}
+	for i := range sessions {
+		if err := revokeSession(ctx, cfg, userID, sessions[i].ID, RevokedSession); err != nil {
+			return err
+		}
+	}
+	// Refresh tokens from before sessions existed have no session record.
+	_, err = refreshTokenCollection.UpdateMany(ctx,
+		bson.M{"user_id": uid, "family_id": bson.M{"$ne": currentSessionID}, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now(), "revoked_reason": RevokedSession}},
+	)
+	return err
+}
+
+// revokeSession revokes the session's refresh tokens and its access tokens still in
+// circulation, which also closes its live connections in other services.
+func revokeSession(ctx context.Context, cfg Config, userID, sessionID, reason string) error {
+	if err := RevokeRefreshFamily(ctx, sessionID, reason); err != nil {
+		return err
+	}
+	if err := tokenRevocations.RevokeSession(ctx, sessionID, cfg.AccessTokenTTL+cfg.Verifier.Leeway); err != nil {
+		return err
+	}
+	logSecurityEvent("session_revoked", "user="+userID+" session="+sessionID+" reason="+reason)
+	return nil
+}
+
+// deleteSessions removes the session records matching filter once their tokens are revoked.
+func deleteSessions(ctx context.Context, filter bson.M) error {
+	_, err := sessionCollection.DeleteMany(ctx, filter)
+	return err
+}
+
+// describeDevice turns a User-Agent into a short label such as "Firefox on Windows".
+func describeDevice(userAgent string) string {
+	userAgent = strings.TrimSpace(userAgent)
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	var browser string
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"), strings.Contains(userAgent, "CriOS/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	}
+
+	var os string
+	switch {
+	case strings.Contains(userAgent, "Android"):
+		os = "Android"
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		os = "iOS"
+	case strings.Contains(userAgent, "Windows"):
+		os = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		os = "macOS"
+	case strings.Contains(userAgent, "CrOS"):
+		os = "ChromeOS"
+	case strings.Contains(userAgent, "Linux"):
+		os = "Linux"
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	}
+	// Non-browser clients such as "curl/8.5.0": keep the product name.
+	product := strings.SplitN(strings.Fields(userAgent)[0], "/", 2)[0]
+	if len(product) > 64 {
+		product = product[:64]
+	}
+	return product
+}
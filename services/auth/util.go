@@ -2,32 +2,58 @@
 package main
 
 import (
-	"os"
+	"context"
 	"time"
 
+	"github.com/celesteyang/ChatOrbit/shared/auth"
+	"github.com/go-redis/redis/v8"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// jwtSecret stores the secret key for signing JWT tokens.
-var jwtSecret []byte
+// tokenRevocations holds logged-out tokens and per-user token versions.
+var tokenRevocations *auth.Revocations
 
-// init loads the JWT secret from environment variable at startup.
-func init() {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		panic("JWT_SECRET environment variable not set")
+// InitTokenRevocations sets the Redis client used to record and check token revocations.
+func InitTokenRevocations(client *redis.Client) {
+	tokenRevocations = auth.NewRevocations(client)
+}
+
+// GenerateJWT creates a JWT token for the given user, signed with the active key.
+// AccountCreatedAt lets other services judge account age without a lookup,
+// and Roles carries the user's global and room-scoped roles.
+// The jti identifies the token for logout, ver ties it to the user's token version
+// and sid to the login session.
+// The token expires after cfg.AccessTokenTTL; clients renew it with a refresh token.
+func GenerateJWT(cfg Config, user *User, sessionID string) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := auth.Claims{
+		UserID:           user.ID.Hex(),
+		Email:            user.Email,
+		Roles:            auth.Roles(user.Roles),
+		AccountCreatedAt: user.CreateTime.Unix(),
+		Version:          user.TokenVersion,
+		SessionID:        sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    cfg.Issuer,
+			Subject:   user.ID.Hex(),
+			Audience:  jwt.ClaimStrings(cfg.Audiences),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.AccessTokenTTL)),
+		},
 	}
-	jwtSecret = []byte(secret)
+	return signingKeys.Sign(claims)
 }
 
-// GenerateJWT creates a JWT token for the given user ID and email.
-// The token expires in 24 hours.
-func GenerateJWT(userID, email string) (string, error) {
-	claims := jwt.MapClaims{
-		"user_id": userID,
-		"email":   email,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
+// RevokeJWT revokes a single access token until it expires.
+func RevokeJWT(ctx context.Context, claims *auth.Claims) error {
+	if claims.ExpiresAt == nil {
+		return nil
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return tokenRevocations.RevokeToken(ctx, claims.ID, claims.ExpiresAt.Time)
 }
@@ -0,0 +1,106 @@
+package main
+
+// Email verification: signed, time-limited links sent by the mailer
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/auth"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// audienceEmailVerification keeps verification tokens from being accepted as
+// access tokens and the other way round.
+const audienceEmailVerification = "chatorbit-email-verification"
+
+// ErrEmailNotVerified is returned when an unverified account tries to log in.
+var ErrEmailNotVerified = errors.New("Email address is not verified.")
+
+// ErrInvalidVerificationToken is returned for expired, tampered or outdated verification links.
+var ErrInvalidVerificationToken = errors.New("Invalid or expired verification link.")
+
+// verificationClaims bind a verification link to the user and the address it was sent to,
+// so a link stops working once the email changes.
+type verificationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// purposeParser parses the single-purpose tokens this service signs for itself,
+// such as email verification links, which carry their own audience.
+func purposeParser(cfg Config, audience string) *jwt.Parser {
+	algorithms := cfg.Verifier.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = auth.DefaultAlgorithms
+	}
+	return jwt.NewParser(
+		jwt.WithValidMethods(algorithms),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+	)
+}
+
+// GenerateVerificationToken signs a verification token for the user's current email.
+func GenerateVerificationToken(cfg Config, user *User) (string, error) {
+	now := time.Now()
+	claims := verificationClaims{
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    cfg.Issuer,
+			Subject:   user.ID.Hex(),
+			Audience:  jwt.ClaimStrings{audienceEmailVerification},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.EmailVerificationTTL)),
+		},
+	}
+	return signingKeys.Sign(claims)
+}
+
+// SendVerificationEmail mails the user a link to the verify endpoint.
+func SendVerificationEmail(ctx context.Context, cfg Config, user *User) error {
+	token, err := GenerateVerificationToken(cfg, user)
+	if err != nil {
+		return err
+	}
+	link := cfg.PublicURL + "/verify-email?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Hi %s,\n\nConfirm your ChatOrbit email address by opening this link:\n\n%s\n\nThe link expires in %s. If you did not sign up, ignore this email.\n",
+		user.Username, link, cfg.EmailVerificationTTL)
+	return mailer.Send(ctx, Mail{To: user.Email, Subject: "Verify your ChatOrbit email address", Body: body})
+}
+
+// VerifyEmail checks a verification token and marks the email it was issued for verified.
+func VerifyEmail(ctx context.Context, cfg Config, tokenString string) error {
+	claims := &verificationClaims{}
+	token, err := purposeParser(cfg, audienceEmailVerification).ParseWithClaims(tokenString, claims, signingKeys.Keyfunc)
+	if err != nil || !token.Valid {
+		return ErrInvalidVerificationToken
+	}
+
+	uid, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+	if err := MarkEmailVerified(ctx, uid, claims.Email); err != nil {
+		log.Println("[VerifyEmail] error for", claims.Subject, ":", err)
+		return ErrInvalidVerificationToken
+	}
+	return nil
+}
+
+// ResendVerificationEmail sends a new link when the address belongs to an unverified
+// account. It reports nothing about whether the account exists.
+func ResendVerificationEmail(ctx context.Context, cfg Config, email string) {
+	user, err := FindUserByEmail(ctx, email)
+	if err != nil || user.EmailVerified {
+		return
+	}
+	if err := SendVerificationEmail(ctx, cfg, user); err != nil {
+		log.Println("[ResendVerification] Failed to send email to", email, ":", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/auth"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// useTestKeys points the package signing keys at a fresh key ring for one test.
+func useTestKeys(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestKey(t, dir, "test")
+	previous := signingKeys
+	signingKeys = loadKeyRing(t, dir, time.Hour)
+	t.Cleanup(func() { signingKeys = previous })
+}
+
+func testConfig() Config {
+	return Config{
+		AccessTokenTTL:       time.Minute,
+		Issuer:               auth.DefaultIssuer,
+		Audiences:            []string{auth.AudienceChat, auth.AudienceUser, auth.AudienceAuth},
+		Verifier:             auth.VerifierConfig{Issuer: auth.DefaultIssuer, Audience: auth.AudienceAuth},
+		EmailVerificationTTL: time.Hour,
+	}
+}
+
+func TestVerificationTokenPurpose(t *testing.T) {
+	useTestKeys(t)
+	cfg := testConfig()
+	user := &User{ID: primitive.NewObjectID(), Email: "ada@example.com"}
+
+	link, err := GenerateVerificationToken(cfg, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := &verificationClaims{}
+	if _, err := purposeParser(cfg, audienceEmailVerification).ParseWithClaims(link, claims, signingKeys.Keyfunc); err != nil {
+		t.Fatalf("verification token rejected: %v", err)
+	}
+	if claims.Subject != user.ID.Hex() || claims.Email != user.Email {
+		t.Fatalf("claims = %+v, want the user's ID and email", claims)
+	}
+
+	// Verification links and access tokens are not interchangeable.
+	verifier := auth.NewVerifier(signingKeys.Keyfunc, cfg.Verifier, nil)
+	if _, err := verifier.Verify(context.Background(), link); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Fatalf("verification token accepted as access token: %v", err)
+	}
+	access, err := GenerateJWT(cfg, user, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := purposeParser(cfg, audienceEmailVerification).ParseWithClaims(access, &verificationClaims{}, signingKeys.Keyfunc); err == nil {
+		t.Fatal("access token accepted as verification token")
+	}
+
+	cfg.EmailVerificationTTL = -time.Minute
+	expired, err := GenerateVerificationToken(cfg, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := purposeParser(cfg, audienceEmailVerification).ParseWithClaims(expired, &verificationClaims{}, signingKeys.Keyfunc); err == nil {
+		t.Fatal("expired verification token accepted")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+// Append-only audit log of moderation actions
+import (
+	"context"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// Moderation actions recorded in the audit log.
+const (
+	ModActionFiltersUpdated     = "filters_updated"
+	ModActionHeldApproved       = "held_approved"
+	ModActionHeldRejected       = "held_rejected"
+	ModActionReportResolved     = "report_resolved"
+	ModActionMessageHidden      = "message_hidden"
+	ModActionMessageRestored    = "message_restored"
+	ModActionMessageDeleted     = "message_deleted"
+	ModActionUserWarned         = "user_warned"
+	ModActionUserMuted          = "user_muted"
+	ModActionUserUnmuted        = "user_unmuted"
+	ModActionUserShadowBanned   = "user_shadow_banned"
+	ModActionUserShadowUnbanned = "user_shadow_unbanned"
+	ModActionSlowModeEnabled    = "slow_mode_enabled"
+	ModActionSlowModeDisabled   = "slow_mode_disabled"
+	ModActionRoomLocked         = "room_locked"
+	ModActionRoomUnlocked       = "room_unlocked"
+	ModActionGuestAccessChanged = "guest_access_changed"
+)
+
+// Kinds of targets a moderation action applies to.
+const (
+	ModTargetRoom    = "room"
+	ModTargetUser    = "user"
+	ModTargetMessage = "message"
+	ModTargetHeld    = "held_message"
+	ModTargetReport  = "report"
+)
+
+// SystemActor is the actor recorded for actions taken automatically by the chat service.
+const SystemActor = "system"
+
+// ModAction is one immutable entry in the moderation audit log.
+type ModAction struct {
+	ID         primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Action     string                 `bson:"action" json:"action"`
+	ActorID    string                 `bson:"actor_id" json:"actor_id"`
+	TargetType string                 `bson:"target_type" json:"target_type"`
+	TargetID   string                 `bson:"target_id" json:"target_id"`
+	RoomID     string                 `bson:"room_id,omitempty" json:"room_id,omitempty"`
+	Reason     string                 `bson:"reason,omitempty" json:"reason,omitempty"`
+	Details    map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	CreatedAt  time.Time              `bson:"created_at" json:"created_at"`
+}
+
+// ModActionQuery filters the audit log. Empty fields are ignored.
+type ModActionQuery struct {
+	RoomID   string
+	ActorID  string
+	TargetID string
+	Action   string
+	Since    time.Time
+	Until    time.Time
+}
+
+// RecordModAction appends an entry to the audit log. The log is write-only:
+// there is deliberately no API to update or delete entries.
+// Failures are logged rather than returned so a moderation action is never undone
+// because auditing failed.
+func RecordModAction(ctx context.Context, action ModAction) {
+	action.ID = primitive.NilObjectID
+	action.CreatedAt = time.Now()
+	if _, err := modActionCollection.InsertOne(ctx, action); err != nil {
+		logger.Error("Failed to record moderation action",
+			zap.String("action", action.Action),
+			zap.String("actor", action.ActorID),
+			zap.String("target", action.TargetID),
+			zap.Error(err),
+		)
+	}
+}
+
+func (q ModActionQuery) filter() bson.M {
+	filter := bson.M{}
+	if q.RoomID != "" {
+		filter["room_id"] = q.RoomID
+	}
+	if q.ActorID != "" {
+		filter["actor_id"] = q.ActorID
+	}
+	if q.TargetID != "" {
+		filter["target_id"] = q.TargetID
+	}
+	if q.Action != "" {
+		filter["action"] = q.Action
+	}
+	createdAt := bson.M{}
+	if !q.Since.IsZero() {
+		createdAt["$gte"] = q.Since
+	}
+	if !q.Until.IsZero() {
+		createdAt["$lt"] = q.Until
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+	return filter
+}
+
+// ListModActions returns audit entries, newest first. Entries recorded in the same
+// millisecond keep their insertion order through the _id tiebreaker.
+func ListModActions(ctx context.Context, q ModActionQuery, limit int64) ([]ModAction, error) {
+	cursor, err := modActionCollection.Find(ctx, q.filter(),
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	actions := []ModAction{}
+	if err := cursor.All(ctx, &actions); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+// StreamModActions opens a cursor over matching audit entries, oldest first, for exports.
+func StreamModActions(ctx context.Context, q ModActionQuery) (*mongo.Cursor, error) {
+	return modActionCollection.Find(ctx, q.filter(),
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+}
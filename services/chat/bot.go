@@ -0,0 +1,66 @@
+package main
+
+// Bot connections: API key scopes, room restrictions and per-key rate limits
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/auth"
+)
+
+// ErrBotNotAllowed is returned when a bot's API key does not allow it into a room.
+var ErrBotNotAllowed = errors.New("api key does not allow this room")
+
+// BotConfig controls the rate limit of bot API keys, which replaces the flood
+// detection and slow mode applied to users.
+type BotConfig struct {
+	// RateLimit is how many messages a key may send per RateWindow unless the key sets its own.
+	RateLimit  int
+	RateWindow time.Duration
+}
+
+func botRateKey(keyID string) string {
+	return "bot:rate:key:" + keyID
+}
+
+// admitBot decides whether a bot may join a room: its key must allow the room and
+// let it read or post. Keys are granted by staff, so lockdowns do not keep bots out.
+func admitBot(roomID string, bot *auth.BotGrant) error {
+	if !bot.AllowsRoom(roomID) || !(bot.HasScope(auth.ScopeReadHistory) || bot.HasScope(auth.ScopePost)) {
+		return ErrBotNotAllowed
+	}
+	return nil
+}
+
+// takeBotSlot counts a message against the key's rate limit. It returns how many
+// seconds the bot has to wait, or 0 when the message may go through.
+func (h *Hub) takeBotSlot(ctx context.Context, bot *auth.BotGrant) (int64, error) {
+	limit := bot.RateLimit
+	if limit == 0 {
+		limit = h.bots.RateLimit
+	}
+	if limit <= 0 {
+		return 0, nil
+	}
+
+	key := botRateKey(bot.KeyID)
+	pipe := h.redis.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	// Start the window on the first message, or repair a counter that lost its expiry.
+	wait := ttl.Val()
+	if wait < 0 {
+		wait = h.bots.RateWindow
+		if err := h.redis.Expire(ctx, key, h.bots.RateWindow).Err(); err != nil {
+			return 0, err
+		}
+	}
+	if incr.Val() <= int64(limit) {
+		return 0, nil
+	}
+	return ceilSeconds(wait), nil
+}
@@ -1,3 +1,162 @@
 package main
 
 // Local config loading for chat service
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/auth"
+)
+
+// Config holds the tunable settings of the chat service, loaded from the environment.
+type Config struct {
+	Validation ValidationConfig
+	// ReportHideThreshold is the number of distinct reports that hides a message pending review; 0 disables it.
+	ReportHideThreshold int
+	// MaxMute is the longest timeout a moderator may hand out.
+	MaxMute  time.Duration
+	Flood    FloodConfig
+	Lockdown LockdownConfig
+	// Bots controls the default rate limit of bot API keys.
+	Bots BotConfig
+	// GuestsAllowed is whether rooms without their own setting admit guests.
+	GuestsAllowed bool
+	// PrivacyPollInterval is how often the privacy job queue is checked for new tasks.
+	PrivacyPollInterval time.Duration
+	// JWKSCache is how long the auth service's public keys are cached.
+	JWKSCache time.Duration
+	// Verifier lists the algorithms, issuer, audience and leeway required of access tokens.
+	Verifier auth.VerifierConfig
+}
+
+// ValidationConfig controls the content pipeline applied to every incoming message.
+type ValidationConfig struct {
+	// MaxRunes is the maximum message length in runes after trimming.
+	MaxRunes int
+	// MaxFrameBytes caps the size of a single WebSocket frame read from a client.
+	MaxFrameBytes int64
+	// NormalizeUnicode applies NFC normalization to message content.
+	NormalizeUnicode bool
+	// StripControl removes control and format characters except newlines and tabs.
+	StripControl bool
+	// StripHTML removes HTML tags from message content.
+	StripHTML bool
+}
+
+// FloodConfig controls duplicate and raid detection on the send path.
+type FloodConfig struct {
+	// DuplicateLimit is how many copies of a message one user may send within DuplicateWindow; 0 disables it.
+	DuplicateLimit  int
+	DuplicateWindow time.Duration
+	DuplicateAction FloodAction
+	// RaidAccounts is how many distinct accounts sending the same message within RaidWindow counts as a raid; 0 disables it.
+	RaidAccounts int
+	RaidWindow   time.Duration
+	RaidAction   FloodAction
+	// RaidMinLength skips raid detection for short messages such as "lol" or "gg".
+	RaidMinLength int
+	// MuteDuration is the timeout handed out by the mute action.
+	MuteDuration time.Duration
+	// SlowModeInterval and SlowModeDuration configure the automatic slow mode.
+	SlowModeInterval time.Duration
+	SlowModeDuration time.Duration
+}
+
+// LockdownConfig controls room lockdowns and the rate limits that trigger them automatically.
+type LockdownConfig struct {
+	// MinAccountAge is the default account age required to join a locked room.
+	MinAccountAge time.Duration
+	// JoinRateLimit and MessageRateLimit are the joins and messages per RateWindow
+	// that lock a room automatically; 0 disables the trigger.
+	JoinRateLimit    int
+	MessageRateLimit int
+	RateWindow       time.Duration
+	// AutoDuration is how long an automatic lockdown lasts.
+	AutoDuration time.Duration
+	// AutoMembersOnly and AutoSlowMode are applied by automatic lockdowns.
+	AutoMembersOnly bool
+	AutoSlowMode    time.Duration
+}
+
+// LoadConfig reads the chat service configuration from environment variables.
+func LoadConfig() Config {
+	return Config{
+		Validation: ValidationConfig{
+			MaxRunes:         getEnvInt("MESSAGE_MAX_RUNES", 500),
+			MaxFrameBytes:    int64(getEnvInt("WS_MAX_FRAME_BYTES", 8192)),
+			NormalizeUnicode: getEnvBool("MESSAGE_NORMALIZE_UNICODE", true),
+			StripControl:     getEnvBool("MESSAGE_STRIP_CONTROL", true),
+			StripHTML:        getEnvBool("MESSAGE_STRIP_HTML", true),
+		},
+		ReportHideThreshold: getEnvInt("REPORT_HIDE_THRESHOLD", 3),
+		MaxMute:             time.Duration(getEnvInt("MUTE_MAX_SECONDS", 7*24*3600)) * time.Second,
+		Flood: FloodConfig{
+			DuplicateLimit:   getEnvInt("FLOOD_DUPLICATE_LIMIT", 2),
+			DuplicateWindow:  getEnvSeconds("FLOOD_DUPLICATE_WINDOW_SECONDS", 30),
+			DuplicateAction:  getEnvFloodAction("FLOOD_DUPLICATE_ACTION", FloodReject),
+			RaidAccounts:     getEnvInt("FLOOD_RAID_ACCOUNTS", 5),
+			RaidWindow:       getEnvSeconds("FLOOD_RAID_WINDOW_SECONDS", 60),
+			RaidAction:       getEnvFloodAction("FLOOD_RAID_ACTION", FloodSlowMode),
+			RaidMinLength:    getEnvInt("FLOOD_RAID_MIN_LENGTH", 8),
+			MuteDuration:     getEnvSeconds("FLOOD_MUTE_SECONDS", 300),
+			SlowModeInterval: getEnvSeconds("FLOOD_SLOW_MODE_INTERVAL_SECONDS", 10),
+			SlowModeDuration: getEnvSeconds("FLOOD_SLOW_MODE_DURATION_SECONDS", 600),
+		},
+		Lockdown: LockdownConfig{
+			MinAccountAge:    getEnvSeconds("LOCKDOWN_MIN_ACCOUNT_AGE_SECONDS", 24*3600),
+			JoinRateLimit:    getEnvInt("LOCKDOWN_JOIN_RATE_LIMIT", 60),
+			MessageRateLimit: getEnvInt("LOCKDOWN_MESSAGE_RATE_LIMIT", 200),
+			RateWindow:       getEnvSeconds("LOCKDOWN_RATE_WINDOW_SECONDS", 10),
+			AutoDuration:     getEnvSeconds("LOCKDOWN_AUTO_DURATION_SECONDS", 900),
+			AutoMembersOnly:  getEnvBool("LOCKDOWN_AUTO_MEMBERS_ONLY", false),
+			AutoSlowMode:     getEnvSeconds("LOCKDOWN_AUTO_SLOW_MODE_SECONDS", 0),
+		},
+		Bots: BotConfig{
+			RateLimit:  getEnvInt("BOT_RATE_LIMIT", 60),
+			RateWindow: getEnvSeconds("BOT_RATE_WINDOW_SECONDS", 60),
+		},
+		GuestsAllowed:       getEnvBool("GUESTS_ALLOWED_DEFAULT", true),
+		PrivacyPollInterval: getEnvSeconds("PRIVACY_POLL_SECONDS", 5),
+		JWKSCache:           getEnvSeconds("JWKS_CACHE_SECONDS", 300),
+		Verifier:            auth.VerifierConfigFromEnv(auth.AudienceChat),
+	}
+}
+
+func getEnvSeconds(key string, defaultSeconds int) time.Duration {
+	return time.Duration(getEnvInt(key, defaultSeconds)) * time.Second
+}
+
+func getEnvFloodAction(key string, defaultValue FloodAction) FloodAction {
+	switch action := FloodAction(strings.TrimSpace(os.Getenv(key))); action {
+	case FloodReject, FloodMute, FloodSlowMode:
+		return action
+	default:
+		return defaultValue
+	}
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return n
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue
+	}
+	return b
+}
@@ -0,0 +1,558 @@
+package main
+
+// Pluggable moderation filters: per-room word lists and link policy
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/celesteyang/ChatOrbit/shared/logger"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// FilterAction is what a filter decides to do with a message.
+type FilterAction string
+
+const (
+	ActionAllow  FilterAction = "allow"
+	ActionMask   FilterAction = "mask"
+	ActionReject FilterAction = "reject"
+	ActionHold   FilterAction = "hold"
+)
+
+// Term match kinds supported by room word lists.
+const (
+	MatchExact    = "exact"
+	MatchWildcard = "wildcard"
+	MatchRegex    = "regex"
+)
+
+// Link policy modes.
+const (
+	LinkModeAllow     = "allow"
+	LinkModeDeny      = "deny"
+	LinkModeAllowlist = "allowlist"
+)
+
+// GlobalFilterRoom is the word list room ID whose rules apply to every room.
+const GlobalFilterRoom = "*"
+
+// filterReloadChannel carries room IDs whose word lists changed so every instance drops its cache.
+const filterReloadChannel = "moderation:filters:reload"
+
+const maxFilterPatternLength = 200
+
+// FilterTerm is a single blocklist entry.
+type FilterTerm struct {
+	Pattern string       `bson:"pattern" json:"pattern" binding:"required"`
+	Match   string       `bson:"match" json:"match"`
+	Action  FilterAction `bson:"action" json:"action"`
+}
+
+// LinkPolicy controls which links may be posted in a room.
+// In "allow" mode Domains is a denylist, in "allowlist" mode it lists the only permitted domains,
+// and in "deny" mode every link violates the policy.
+type LinkPolicy struct {
+	Mode    string       `bson:"mode" json:"mode"`
+	Domains []string     `bson:"domains" json:"domains"`
+	Action  FilterAction `bson:"action" json:"action"`
+}
+
+// RoomFilters is the moderation configuration of a room, stored in MongoDB.
+type RoomFilters struct {
+	RoomID     string       `bson:"room_id" json:"room_id"`
+	Terms      []FilterTerm `bson:"terms" json:"terms"`
+	LinkPolicy LinkPolicy   `bson:"link_policy" json:"link_policy"`
+	UpdatedAt  time.Time    `bson:"updated_at" json:"updated_at"`
+	UpdatedBy  string       `bson:"updated_by" json:"updated_by"`
+}
+
+// FilterResult is the outcome of running a message through one filter or the whole chain.
+type FilterResult struct {
+	Action  FilterAction
+	Content string
+	Filter  string
+	Reason  string
+}
+
+// MessageFilter inspects message content for a room and decides what to do with it.
+type MessageFilter interface {
+	Name() string
+	Apply(ctx context.Context, roomID, content string) (FilterResult, error)
+}
+
+// FilterChain runs filters in order. Masks are applied cumulatively; the first
+// reject or hold stops the chain.
+type FilterChain struct {
+	filters []MessageFilter
+}
+
+// NewFilterChain creates a chain from the given filters.
+func NewFilterChain(filters ...MessageFilter) *FilterChain {
+	return &FilterChain{filters: filters}
+}
+
+// Run applies every filter to the content and returns the combined verdict.
+func (fc *FilterChain) Run(ctx context.Context, roomID, content string) (FilterResult, error) {
+	verdict := FilterResult{Action: ActionAllow, Content: content}
+	if fc == nil {
+		return verdict, nil
+	}
+	for _, f := range fc.filters {
+		result, err := f.Apply(ctx, roomID, verdict.Content)
+		if err != nil {
+			return FilterResult{}, fmt.Errorf("filter %s: %w", f.Name(), err)
+		}
+		switch result.Action {
+		case ActionReject, ActionHold:
+			result.Filter = f.Name()
+			return result, nil
+		case ActionMask:
+			verdict.Action = ActionMask
+			verdict.Content = result.Content
+			verdict.Filter = f.Name()
+			verdict.Reason = result.Reason
+		}
+	}
+	return verdict, nil
+}
+
+// compiledTerm is a word list entry compiled to a regular expression.
+// wholeWord terms only match when not surrounded by other word characters.
+type compiledTerm struct {
+	re        *regexp.Regexp
+	wholeWord bool
+	action    FilterAction
+}
+
+// find returns the byte spans of every match of the term in content.
+func (t compiledTerm) find(content string) [][2]int {
+	var spans [][2]int
+	for _, m := range t.re.FindAllStringIndex(content, -1) {
+		if m[0] == m[1] {
+			continue
+		}
+		if t.wholeWord && (isWordRuneBefore(content, m[0]) || isWordRuneAt(content, m[1])) {
+			continue
+		}
+		spans = append(spans, [2]int{m[0], m[1]})
+	}
+	return spans
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+func isWordRuneBefore(s string, i int) bool {
+	if i == 0 {
+		return false
+	}
+	r, _ := utf8.DecodeLastRuneInString(s[:i])
+	return isWordRune(r)
+}
+
+func isWordRuneAt(s string, i int) bool {
+	if i >= len(s) {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return isWordRune(r)
+}
+
+// compiledRules are the ready-to-use filters of one room.
+type compiledRules struct {
+	terms []compiledTerm
+	links LinkPolicy
+}
+
+// wordChars are the characters considered part of a word for exact and wildcard terms.
+const wordChars = `\p{L}\p{N}_`
+
+// compileTerm validates a term and builds its matcher.
+func compileTerm(t FilterTerm) (compiledTerm, error) {
+	pattern := strings.TrimSpace(t.Pattern)
+	if pattern == "" {
+		return compiledTerm{}, errors.New("pattern is empty")
+	}
+	if len(pattern) > maxFilterPatternLength {
+		return compiledTerm{}, fmt.Errorf("pattern %q is too long", pattern)
+	}
+	action := t.Action
+	if action == "" {
+		action = ActionMask
+	}
+	if !validFilterAction(action) {
+		return compiledTerm{}, fmt.Errorf("unknown action %q", action)
+	}
+
+	var expr string
+	wholeWord := true
+	switch t.Match {
+	case "", MatchExact:
+		expr = regexp.QuoteMeta(pattern)
+	case MatchWildcard:
+		var b strings.Builder
+		for _, r := range pattern {
+			switch r {
+			case '*':
+				b.WriteString(`[` + wordChars + `]*`)
+			case '?':
+				b.WriteString(`[` + wordChars + `]`)
+			default:
+				b.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		expr = b.String()
+	case MatchRegex:
+		expr = pattern
+		wholeWord = false
+	default:
+		return compiledTerm{}, fmt.Errorf("unknown match type %q", t.Match)
+	}
+
+	re, err := regexp.Compile("(?i)" + expr)
+	if err != nil {
+		return compiledTerm{}, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return compiledTerm{re: re, wholeWord: wholeWord, action: action}, nil
+}
+
+func validFilterAction(a FilterAction) bool {
+	switch a {
+	case ActionMask, ActionReject, ActionHold:
+		return true
+	}
+	return false
+}
+
+// normalizeLinkPolicy fills defaults and validates a link policy.
+func normalizeLinkPolicy(p LinkPolicy) (LinkPolicy, error) {
+	if p.Mode == "" {
+		p.Mode = LinkModeAllow
+	}
+	switch p.Mode {
+	case LinkModeAllow, LinkModeDeny, LinkModeAllowlist:
+	default:
+		return p, fmt.Errorf("unknown link policy mode %q", p.Mode)
+	}
+	if p.Action == "" {
+		p.Action = ActionReject
+	}
+	if !validFilterAction(p.Action) {
+		return p, fmt.Errorf("unknown link policy action %q", p.Action)
+	}
+	domains := make([]string, 0, len(p.Domains))
+	for _, d := range p.Domains {
+		d = strings.Trim(strings.ToLower(strings.TrimSpace(d)), ".")
+		if d != "" {
+			domains = append(domains, d)
+		}
+	}
+	p.Domains = domains
+	return p, nil
+}
+
+// compileRoomFilters validates a room's configuration and compiles it.
+func compileRoomFilters(f *RoomFilters) (*compiledRules, error) {
+	rules := &compiledRules{}
+	for _, t := range f.Terms {
+		ct, err := compileTerm(t)
+		if err != nil {
+			return nil, err
+		}
+		rules.terms = append(rules.terms, ct)
+	}
+	links, err := normalizeLinkPolicy(f.LinkPolicy)
+	if err != nil {
+		return nil, err
+	}
+	rules.links = links
+	return rules, nil
+}
+
+// FilterStore loads room filter configuration from MongoDB and caches the compiled rules.
+// Caches are dropped on every instance when a reload notice arrives over Redis.
+type FilterStore struct {
+	redis *redis.Client
+
+	mu    sync.RWMutex
+	cache map[string]*compiledRules
+}
+
+// NewFilterStore creates a store that uses Redis to broadcast reload notices.
+func NewFilterStore(redisClient *redis.Client) *FilterStore {
+	return &FilterStore{
+		redis: redisClient,
+		cache: make(map[string]*compiledRules),
+	}
+}
+
+// rules returns the compiled rules for a room, loading them on first use.
+// A room without configuration is cached as an empty rule set.
+func (s *FilterStore) rules(ctx context.Context, roomID string) (*compiledRules, error) {
+	s.mu.RLock()
+	rules, ok := s.cache[roomID]
+	s.mu.RUnlock()
+	if ok {
+		return rules, nil
+	}
+
+	f, err := FindRoomFilters(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	rules = &compiledRules{links: LinkPolicy{Mode: LinkModeAllow}}
+	if f != nil {
+		if rules, err = compileRoomFilters(f); err != nil {
+			// Stored configuration is validated on write, so this only happens after manual edits.
+			logger.Error("Invalid stored room filters", zap.String("roomID", roomID), zap.Error(err))
+			rules = &compiledRules{links: LinkPolicy{Mode: LinkModeAllow}}
+		}
+	}
+
+	s.mu.Lock()
+	s.cache[roomID] = rules
+	s.mu.Unlock()
+	return rules, nil
+}
+
+// Get returns the stored configuration of a room, or an empty configuration.
+func (s *FilterStore) Get(ctx context.Context, roomID string) (*RoomFilters, error) {
+	f, err := FindRoomFilters(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		f = &RoomFilters{RoomID: roomID, Terms: []FilterTerm{}, LinkPolicy: LinkPolicy{Mode: LinkModeAllow, Domains: []string{}}}
+	}
+	return f, nil
+}
+
+// Save validates and stores the configuration of a room, then tells every instance to reload it.
+func (s *FilterStore) Save(ctx context.Context, f *RoomFilters) error {
+	rules, err := compileRoomFilters(f)
+	if err != nil {
+		return &ValidationError{Code: "invalid_filters", Message: err.Error()}
+	}
+	f.LinkPolicy = rules.links
+	for i := range f.Terms {
+		f.Terms[i].Pattern = strings.TrimSpace(f.Terms[i].Pattern)
+		if f.Terms[i].Match == "" {
+			f.Terms[i].Match = MatchExact
+		}
+		if f.Terms[i].Action == "" {
+			f.Terms[i].Action = ActionMask
+		}
+	}
+	f.UpdatedAt = time.Now()
+	if err := UpsertRoomFilters(ctx, f); err != nil {
+		return err
+	}
+	RecordModAction(ctx, ModAction{
+		Action:     ModActionFiltersUpdated,
+		ActorID:    f.UpdatedBy,
+		TargetType: ModTargetRoom,
+		TargetID:   f.RoomID,
+		RoomID:     f.RoomID,
+		Details: map[string]interface{}{
+			"terms":        len(f.Terms),
+			"link_mode":    f.LinkPolicy.Mode,
+			"link_domains": f.LinkPolicy.Domains,
+		},
+	})
+	if err := s.redis.Publish(ctx, filterReloadChannel, f.RoomID).Err(); err != nil {
+		// Still drop our own cache so at least this instance picks up the change.
+		s.invalidate(f.RoomID)
+		return fmt.Errorf("publish filter reload: %w", err)
+	}
+	return nil
+}
+
+// invalidate drops cached rules for a room, or all rooms when the global list changes.
+func (s *FilterStore) invalidate(roomID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if roomID == GlobalFilterRoom {
+		s.cache = make(map[string]*compiledRules)
+		return
+	}
+	delete(s.cache, roomID)
+}
+
+// WatchReloads listens for reload notices published by any chat instance.
+func (s *FilterStore) WatchReloads(ctx context.Context) {
+	pubsub := s.redis.Subscribe(ctx, filterReloadChannel)
+	defer pubsub.Close()
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error("Error receiving filter reload notice", zap.Error(err))
+			time.Sleep(time.Second)
+			continue
+		}
+		s.invalidate(msg.Payload)
+		logger.Info("Reloaded room filters", zap.String("roomID", msg.Payload))
+	}
+}
+
+// WordFilter applies the global and per-room blocklists.
+type WordFilter struct {
+	store *FilterStore
+}
+
+// NewWordFilter creates a blocklist filter backed by the store.
+func NewWordFilter(store *FilterStore) *WordFilter {
+	return &WordFilter{store: store}
+}
+
+func (f *WordFilter) Name() string { return "word_filter" }
+
+func (f *WordFilter) Apply(ctx context.Context, roomID, content string) (FilterResult, error) {
+	result := FilterResult{Action: ActionAllow, Content: content}
+	for _, id := range []string{GlobalFilterRoom, roomID} {
+		rules, err := f.store.rules(ctx, id)
+		if err != nil {
+			return FilterResult{}, err
+		}
+		for _, t := range rules.terms {
+			spans := t.find(result.Content)
+			if len(spans) == 0 {
+				continue
+			}
+			reason := "matched blocked term"
+			if t.action != ActionMask {
+				return FilterResult{Action: t.action, Content: result.Content, Reason: reason}, nil
+			}
+			result.Content = maskSpans(result.Content, spans)
+			result.Action = ActionMask
+			result.Reason = reason
+		}
+	}
+	return result, nil
+}
+
+// linkPattern finds bare domains and URLs in message content.
+var linkPattern = regexp.MustCompile(`(?i)(?:https?://)?((?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,})(?::\d+)?(?:[/?#][^\s]*)?`)
+
+// LinkFilter enforces the room link policy.
+type LinkFilter struct {
+	store *FilterStore
+}
+
+// NewLinkFilter creates a link policy filter backed by the store.
+func NewLinkFilter(store *FilterStore) *LinkFilter {
+	return &LinkFilter{store: store}
+}
+
+func (f *LinkFilter) Name() string { return "link_policy" }
+
+func (f *LinkFilter) Apply(ctx context.Context, roomID, content string) (FilterResult, error) {
+	result := FilterResult{Action: ActionAllow, Content: content}
+
+	// A room policy overrides the global one unless the room leaves it at the default.
+	rules, err := f.store.rules(ctx, roomID)
+	if err != nil {
+		return FilterResult{}, err
+	}
+	policy := rules.links
+	if policy.Mode == LinkModeAllow && len(policy.Domains) == 0 {
+		global, err := f.store.rules(ctx, GlobalFilterRoom)
+		if err != nil {
+			return FilterResult{}, err
+		}
+		policy = global.links
+	}
+	if policy.Mode == LinkModeAllow && len(policy.Domains) == 0 {
+		return result, nil
+	}
+
+	var spans [][2]int
+	for _, m := range linkPattern.FindAllStringSubmatchIndex(content, -1) {
+		domain := strings.ToLower(content[m[2]:m[3]])
+		if linkAllowed(policy, domain) {
+			continue
+		}
+		if policy.Action != ActionMask {
+			return FilterResult{Action: policy.Action, Content: content, Reason: "link to " + domain + " is not allowed"}, nil
+		}
+		spans = append(spans, [2]int{m[0], m[1]})
+	}
+	if len(spans) > 0 {
+		result.Action = ActionMask
+		result.Content = maskSpans(content, spans)
+		result.Reason = "link removed"
+	}
+	return result, nil
+}
+
+func linkAllowed(p LinkPolicy, domain string) bool {
+	listed := false
+	for _, d := range p.Domains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			listed = true
+			break
+		}
+	}
+	switch p.Mode {
+	case LinkModeDeny:
+		return false
+	case LinkModeAllowlist:
+		return listed
+	default:
+		return !listed
+	}
+}
+
+// maskSpans replaces every rune inside the given byte spans with '*'.
+func maskSpans(content string, spans [][2]int) string {
+	var b strings.Builder
+	last := 0
+	for _, s := range spans {
+		if s[0] < last {
+			continue
+		}
+		b.WriteString(content[last:s[0]])
+		b.WriteString(strings.Repeat("*", utf8.RuneCountInString(content[s[0]:s[1]])))
+		last = s[1]
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+// FindRoomFilters returns the stored configuration of a room, or nil if there is none.
+func FindRoomFilters(ctx context.Context, roomID string) (*RoomFilters, error) {
+	var f RoomFilters
+	err := roomFilterCollection.FindOne(ctx, bson.M{"room_id": roomID}).Decode(&f)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// UpsertRoomFilters replaces the configuration of a room.
+func UpsertRoomFilters(ctx context.Context, f *RoomFilters) error {
+	_, err := roomFilterCollection.ReplaceOne(
+		ctx,
+		bson.M{"room_id": f.RoomID},
+		f,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
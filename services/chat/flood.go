@@ -0,0 +1,156 @@
+package main
+
+// Duplicate and flood detection based on recent-message fingerprints kept in Redis
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// FloodAction is what happens when a flood is detected.
+type FloodAction string
+
+const (
+	// FloodReject drops the offending message.
+	FloodReject FloodAction = "reject"
+	// FloodMute drops the message and times the sender out in the room.
+	FloodMute FloodAction = "mute"
+	// FloodSlowMode drops the message and switches the room into slow mode.
+	FloodSlowMode FloodAction = "slow_mode"
+)
+
+// Kinds of floods the detector recognizes.
+const (
+	FloodKindDuplicate = "duplicate"
+	FloodKindRaid      = "raid"
+)
+
+// FloodDetection describes a detected flood.
+type FloodDetection struct {
+	Kind   string
+	Action FloodAction
+	// Count is the number of repeats for duplicates, or of distinct accounts for raids.
+	Count int64
+}
+
+// FloodDetector fingerprints recent messages in Redis to spot copy-paste spam.
+type FloodDetector struct {
+	redis *redis.Client
+	cfg   FloodConfig
+}
+
+// NewFloodDetector returns a detector using the given settings.
+func NewFloodDetector(redisClient *redis.Client, cfg FloodConfig) *FloodDetector {
+	return &FloodDetector{redis: redisClient, cfg: cfg}
+}
+
+// fingerprint reduces content to a hash that is equal for near-identical messages:
+// case, punctuation, whitespace and repeated characters are ignored.
+func fingerprint(content string) (string, int) {
+	var b strings.Builder
+	var last rune
+	for _, r := range strings.ToLower(content) {
+		if !unicode.IsLetter(r) && !unicode.IsNumber(r) {
+			continue
+		}
+		if r == last {
+			continue
+		}
+		b.WriteRune(r)
+		last = r
+	}
+	sum := sha1.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:10]), b.Len()
+}
+
+func floodUserKey(userID, fp string) string {
+	return "flood:user:" + userID + ":" + fp
+}
+
+func floodRoomKey(roomID, fp string) string {
+	return "flood:room:" + roomID + ":" + fp
+}
+
+// Check records a message's fingerprint and reports a flood, or nil.
+// Duplicates are checked first since they single out one sender.
+func (d *FloodDetector) Check(ctx context.Context, roomID, userID, content string) (*FloodDetection, error) {
+	if d.cfg.DuplicateLimit <= 0 && d.cfg.RaidAccounts <= 0 {
+		return nil, nil
+	}
+	fp, length := fingerprint(content)
+	if length == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	checkRaid := d.cfg.RaidAccounts > 0 && length >= d.cfg.RaidMinLength
+
+	pipe := d.redis.TxPipeline()
+	var repeats *redis.IntCmd
+	if d.cfg.DuplicateLimit > 0 {
+		key := floodUserKey(userID, fp)
+		repeats = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, d.cfg.DuplicateWindow)
+	}
+	var accounts *redis.IntCmd
+	if checkRaid {
+		key := floodRoomKey(roomID, fp)
+		pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.UnixMilli()), Member: userID})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(now.Add(-d.cfg.RaidWindow).UnixMilli(), 10))
+		accounts = pipe.ZCard(ctx, key)
+		pipe.Expire(ctx, key, d.cfg.RaidWindow)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("record fingerprint: %w", err)
+	}
+
+	if repeats != nil && repeats.Val() > int64(d.cfg.DuplicateLimit) {
+		return &FloodDetection{Kind: FloodKindDuplicate, Action: d.cfg.DuplicateAction, Count: repeats.Val()}, nil
+	}
+	if accounts != nil && accounts.Val() >= int64(d.cfg.RaidAccounts) {
+		return &FloodDetection{Kind: FloodKindRaid, Action: d.cfg.RaidAction, Count: accounts.Val()}, nil
+	}
+	return nil, nil
+}
+
+// applyFloodAction carries out the configured action for a detected flood and
+// returns the reason code and message for the rejected sender.
+func (h *Hub) applyFloodAction(ctx context.Context, roomID, userID string, detection *FloodDetection) (string, string, error) {
+	code, message := ReasonDuplicateMessage, "you are repeating the same message"
+	reason := "repeated message"
+	if detection.Kind == FloodKindRaid {
+		code, message = ReasonFloodDetected, "this message is being flooded into the room"
+		reason = "message flood from many accounts"
+	}
+
+	switch detection.Action {
+	case FloodMute:
+		duration := h.flood.cfg.MuteDuration
+		if duration > h.maxMute {
+			duration = h.maxMute
+		}
+		if _, err := h.MuteUser(ctx, userID, roomID, duration, SystemActor, reason); err != nil {
+			return "", "", err
+		}
+		return ReasonMuted, "you have been timed out for flooding", nil
+	case FloodSlowMode:
+		mode, err := h.RoomSlowMode(ctx, roomID)
+		if err != nil {
+			return "", "", err
+		}
+		// Leave a stricter slow mode (manual or automatic) in place.
+		if mode == nil || mode.IntervalSeconds < int64(h.flood.cfg.SlowModeInterval/time.Second) {
+			if _, err := h.SetSlowMode(ctx, roomID, h.flood.cfg.SlowModeInterval, h.flood.cfg.SlowModeDuration, SystemActor, reason); err != nil {
+				return "", "", err
+			}
+		}
+	}
+	return code, message, nil
+}
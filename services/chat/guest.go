@@ -0,0 +1,84 @@
+package main
+
+// Guest access: anonymous viewers who receive messages but cannot send
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrGuestsNotAllowed is returned when a guest tries to join a room that does not allow guests.
+var ErrGuestsNotAllowed = errors.New("room does not allow guests")
+
+// GuestAccess describes whether a room admits guests. Default is true when the room
+// has no setting of its own and follows GUESTS_ALLOWED_DEFAULT.
+type GuestAccess struct {
+	RoomID      string `json:"room_id"`
+	AllowGuests bool   `json:"allow_guests"`
+	Default     bool   `json:"default"`
+}
+
+// isGuest reports whether a connection belongs to an anonymous guest.
+func isGuest(user *UserClaims) bool {
+	return user.Roles.IsGuest()
+}
+
+// RoomGuestAccess returns whether guests may join a room.
+func (h *Hub) RoomGuestAccess(ctx context.Context, roomID string) (*GuestAccess, error) {
+	var room struct {
+		AllowGuests *bool `bson:"allow_guests"`
+	}
+	err := roomCollection.FindOne(ctx, bson.M{"room_id": roomID},
+		options.FindOne().SetProjection(bson.M{"allow_guests": 1}),
+	).Decode(&room)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	if room.AllowGuests == nil {
+		return &GuestAccess{RoomID: roomID, AllowGuests: h.guestsAllowedByDefault, Default: true}, nil
+	}
+	return &GuestAccess{RoomID: roomID, AllowGuests: *room.AllowGuests}, nil
+}
+
+// SetRoomGuestAccess allows or disallows guests in a room. Guests already connected
+// keep watching until they disconnect.
+func (h *Hub) SetRoomGuestAccess(ctx context.Context, roomID string, allow bool, actorID string) (*GuestAccess, error) {
+	_, err := roomCollection.UpdateOne(ctx,
+		bson.M{"room_id": roomID},
+		bson.M{
+			"$set":         bson.M{"allow_guests": allow},
+			"$setOnInsert": bson.M{"room_id": roomID, "created_at": time.Now()},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	RecordModAction(ctx, ModAction{
+		Action:     ModActionGuestAccessChanged,
+		ActorID:    actorID,
+		TargetType: ModTargetRoom,
+		TargetID:   roomID,
+		RoomID:     roomID,
+		Details:    map[string]interface{}{"allow_guests": allow},
+	})
+	return &GuestAccess{RoomID: roomID, AllowGuests: allow}, nil
+}
+
+// admitGuest decides whether a guest may watch a room. Guests cannot send, so
+// lockdowns do not keep them out and their joins do not count towards one.
+func (h *Hub) admitGuest(ctx context.Context, roomID string) error {
+	access, err := h.RoomGuestAccess(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if !access.AllowGuests {
+		return ErrGuestsNotAllowed
+	}
+	return nil
+}
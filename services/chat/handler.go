@@ -3,13 +3,18 @@ package main
 // HTTP/WebSocket handlers for chat service
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/celesteyang/ChatOrbit/shared/auth"
 	"github.com/celesteyang/ChatOrbit/shared/logger"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
 )
 
@@ -22,24 +27,13 @@ var upgrader = websocket.Upgrader{
 }
 
 // Reads messages from the WebSocket connection and broadcasts them to the Hub.
-// Verify JWT token from query parameter or Authorization header.
+// The token is verified by the authentication middleware, which accepts it from the
+// query parameter, the Authorization header or the cookie.
 // On success, register the client and start read/write goroutines.
 // Pass the Hub instance to manage the client connection.
-// Usage: r.GET("/ws/chat", ChatWebSocketHandler(hub))
+// Usage: r.GET("/ws/chat", verifier.Middleware(), ChatWebSocketHandler(hub))
 func ChatWebSocketHandler(hub *Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString := c.Query("token")
-		if tokenString == "" {
-			authHeader := c.GetHeader("Authorization")
-			if strings.HasPrefix(authHeader, "Bearer ") {
-				tokenString = strings.TrimPrefix(authHeader, "Bearer ")
-			}
-		}
-		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
-			return
-		}
-
 		// Accept both snake_case and camelCase room query parameters so clients that
 		// send either format can join the intended room instead of falling back to the
 		// default room.
@@ -51,21 +45,35 @@ func ChatWebSocketHandler(hub *Hub) gin.HandlerFunc {
 			roomID = "general"
 		}
 
-		if err := EnsureRoomExists(c.Request.Context(), roomID); err != nil {
-			logger.Error("Failed to ensure room exists", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create room"})
+		claims := auth.ClaimsFrom(c)
+		if claims == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			return
 		}
 
-		claims, err := ValidateJWT(tokenString)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		user := newUserClaims(claims)
+
+		if err := hub.admitToRoom(c.Request.Context(), roomID, user); err != nil {
+			if errors.Is(err, ErrRoomLocked) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "room is locked down", "code": ReasonRoomLocked})
+				return
+			}
+			if errors.Is(err, ErrGuestsNotAllowed) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "room does not allow guests", "code": ReasonGuestsNotAllowed})
+				return
+			}
+			if errors.Is(err, ErrBotNotAllowed) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "this api key does not allow that room", "code": ReasonBotNotAllowed})
+				return
+			}
+			logger.Error("Failed to check room lockdown", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check room lockdown"})
 			return
 		}
-
-		userID, ok := claims["user_id"].(string)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user ID in token claims"})
+		// Only admitted users create the room, so rejected joins leave no room behind.
+		if err := EnsureRoomExists(c.Request.Context(), roomID); err != nil {
+			logger.Error("Failed to ensure room exists", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create room"})
 			return
 		}
 
@@ -76,15 +84,14 @@ func ChatWebSocketHandler(hub *Hub) gin.HandlerFunc {
 		}
 
 		client := &client{
-			hub:  hub,
-			conn: conn,
-			send: make(chan []byte, 256),
-			user: &UserClaims{
-				UserID: userID,
-				Email:  claims["email"].(string),
-			},
+			hub:    hub,
+			conn:   conn,
+			send:   make(chan []byte, 256),
+			user:   user,
 			roomID: roomID,
+			kick:   make(chan *websocket.CloseError, 1),
 		}
+		client.session.set(sessionFromClaims(claims))
 
 		// Refresh presence and read deadlines when pong responses arrive.
 		conn.SetPongHandler(func(string) error {
@@ -132,6 +139,7 @@ func GetRoomPresenceHandler(hub *Hub) gin.HandlerFunc {
 
 // @Summary Get chat history
 // @Description Retrieves chat messages from a specific room.
+// @Description Hidden messages are only included for moderators.
 // @Tags Chat
 // @Accept json
 // @Produce json
@@ -153,11 +161,20 @@ func GetChatHistoryHandler(c *gin.Context) {
 
 	// Call the model function to get messages.
 	// You might want to add pagination (e.g., limit, offset) here.
-	messages, err := GetMessagesByRoom(c.Request.Context(), roomID, 50)
+	viewer := HistoryViewer{UserID: c.GetString("user_id")}
+	viewer.Moderator = auth.CanModerate(c, roomID)
+	messages, err := GetMessagesByRoom(c.Request.Context(), roomID, 50, viewer)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve messages"})
 		return
 	}
+	if !viewer.Moderator {
+		// Never reveal to an author that their messages are hidden.
+		for i := range messages {
+			messages[i].Hidden = false
+			messages[i].HiddenReason = ""
+		}
+	}
 
 	c.JSON(http.StatusOK, messages)
 }
@@ -198,3 +215,892 @@ func CreateRoomHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"room_id": req.RoomID})
 }
+
+type updateRoomFiltersRequest struct {
+	Terms      []FilterTerm `json:"terms"`
+	LinkPolicy LinkPolicy   `json:"link_policy"`
+}
+
+// @Summary Get room filters
+// @Description Returns the word list and link policy of a room. Use "*" as the room ID for the global list.
+// @Tags Moderation
+// @Produce json
+// @Param roomID path string true "Chat Room ID"
+// @Success 200 {object} RoomFilters
+// @Failure 403 {object} map[string]string
+// @Router /chat/moderation/rooms/{roomID}/filters [get]
+func GetRoomFiltersHandler(store *FilterStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roomID := c.Param("roomID")
+		filters, err := store.Get(c.Request.Context(), roomID)
+		if err != nil {
+			logger.Error("Failed to load room filters", zap.String("room_id", roomID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load filters"})
+			return
+		}
+		c.JSON(http.StatusOK, filters)
+	}
+}
+
+// @Summary Update room filters
+// @Description Replaces the word list and link policy of a room and reloads it on every chat instance.
+// @Description Terms match "exact" words, "wildcard" patterns (* and ?) or "regex"; actions are "mask", "reject" or "hold".
+// @Description Link policy modes are "allow" (domains are denied), "deny" (no links) or "allowlist" (only listed domains).
+// @Tags Moderation
+// @Accept json
+// @Produce json
+// @Param roomID path string true "Chat Room ID"
+// @Param filters body updateRoomFiltersRequest true "Room filters"
+// @Success 200 {object} RoomFilters
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /chat/moderation/rooms/{roomID}/filters [put]
+func UpdateRoomFiltersHandler(store *FilterStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roomID := c.Param("roomID")
+
+		var req updateRoomFiltersRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filters"})
+			return
+		}
+		if req.Terms == nil {
+			req.Terms = []FilterTerm{}
+		}
+
+		filters := &RoomFilters{
+			RoomID:     roomID,
+			Terms:      req.Terms,
+			LinkPolicy: req.LinkPolicy,
+			UpdatedBy:  c.GetString("user_id"),
+		}
+		if err := store.Save(c.Request.Context(), filters); err != nil {
+			var verr *ValidationError
+			if errors.As(err, &verr) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": verr.Message})
+				return
+			}
+			logger.Error("Failed to save room filters", zap.String("room_id", roomID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save filters"})
+			return
+		}
+
+		logger.Info("Room filters updated",
+			zap.String("room_id", roomID),
+			zap.String("updated_by", filters.UpdatedBy),
+			zap.Int("terms", len(filters.Terms)),
+		)
+		c.JSON(http.StatusOK, filters)
+	}
+}
+
+type rejectHeldRequest struct {
+	Note string `json:"note"`
+}
+
+// @Summary List held messages
+// @Description Lists messages waiting in the moderation review queue, oldest first.
+// @Tags Moderation
+// @Produce json
+// @Param room_id query string false "Only entries for this room"
+// @Param status query string false "pending (default), approved or rejected"
+// @Param limit query int false "Maximum number of entries (default 50, max 200)"
+// @Success 200 {array} HeldMessage
+// @Failure 403 {object} map[string]string
+// @Router /chat/moderation/held [get]
+func ListHeldMessagesHandler(c *gin.Context) {
+	status := c.DefaultQuery("status", HeldPending)
+	limit := parseLimit(c.Query("limit"), 50, 200)
+
+	held, err := ListHeldMessages(c.Request.Context(), c.Query("room_id"), status, limit)
+	if err != nil {
+		logger.Error("Failed to list held messages", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list held messages"})
+		return
+	}
+	c.JSON(http.StatusOK, held)
+}
+
+// @Summary Approve held message
+// @Description Approves a held message and publishes it to its room.
+// @Tags Moderation
+// @Produce json
+// @Param id path string true "Held message ID"
+// @Success 200 {object} HeldMessage
+// @Failure 404 {object} map[string]string
+// @Router /chat/moderation/held/{id}/approve [post]
+func ApproveHeldMessageHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		if !authorizeHeld(c, id) {
+			return
+		}
+		held, err := hub.ApproveHeldMessage(c.Request.Context(), id, c.GetString("user_id"))
+		if err != nil {
+			respondHeldError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, held)
+	}
+}
+
+// @Summary Reject held message
+// @Description Rejects a held message and notifies its author.
+// @Tags Moderation
+// @Accept json
+// @Produce json
+// @Param id path string true "Held message ID"
+// @Param request body rejectHeldRequest false "Optional note for the author"
+// @Success 200 {object} HeldMessage
+// @Failure 404 {object} map[string]string
+// @Router /chat/moderation/held/{id}/reject [post]
+func RejectHeldMessageHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		var req rejectHeldRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+				return
+			}
+		}
+
+		if !authorizeHeld(c, id) {
+			return
+		}
+		held, err := hub.RejectHeldMessage(c.Request.Context(), id, c.GetString("user_id"), strings.TrimSpace(req.Note))
+		if err != nil {
+			respondHeldError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, held)
+	}
+}
+
+// authorizeHeld checks that the caller moderates the room of a held message,
+// writing the error response when not.
+func authorizeHeld(c *gin.Context, id primitive.ObjectID) bool {
+	held, err := FindHeldMessageByID(c.Request.Context(), id)
+	if err != nil {
+		respondHeldError(c, err)
+		return false
+	}
+	if !auth.CanModerate(c, held.RoomID) {
+		auth.Forbid(c)
+		return false
+	}
+	return true
+}
+
+func respondHeldError(c *gin.Context, err error) {
+	if errors.Is(err, ErrHeldNotPending) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	logger.Error("Failed to review held message", zap.Error(err))
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to review held message"})
+}
+
+// ModerationFeedHandler upgrades a moderator connection to a live feed of review queue events.
+// Usage: r.GET("/ws/moderation", verifier.Middleware(), auth.RequireRoomModerator(auth.RoomFromQuery("room_id")), ModerationFeedHandler(hub))
+func ModerationFeedHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roomID := strings.TrimSpace(c.Query("room_id"))
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			logger.Error("Failed to upgrade moderation feed connection", zap.Error(err))
+			return
+		}
+		logger.Info("Moderation feed connected", zap.String("userID", c.GetString("user_id")), zap.String("roomID", roomID))
+		go hub.serveModerationFeed(conn, roomID)
+	}
+}
+
+// parseLimit reads a positive page size, falling back to def and capping at max.
+func parseLimit(value string, def, max int64) int64 {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return def
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+type resolveReportRequest struct {
+	Action string `json:"action" binding:"required"`
+	Note   string `json:"note"`
+}
+
+// @Summary Report a message or user
+// @Description Reports a message or a user. Repeated reports by the same user on the same target are deduplicated.
+// @Description Categories: spam, harassment, hate, sexual, violence, self_harm, other.
+// @Tags Reports
+// @Accept json
+// @Produce json
+// @Param request body ReportRequest true "Report"
+// @Success 201 {object} map[string]interface{}
+// @Success 200 {object} map[string]interface{} "Already reported"
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /chat/reports [post]
+func CreateReportHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ReportRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "target_type, target_id and category are required"})
+			return
+		}
+
+		reporterID := c.GetString("user_id")
+		report, duplicate, err := hub.SubmitReport(c.Request.Context(), reporterID, req)
+		switch {
+		case errors.Is(err, ErrInvalidReport), errors.Is(err, ErrReportOwnContent):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, ErrReportTargetGone):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			logger.Error("Failed to submit report", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to submit report"})
+			return
+		}
+
+		// Reporters only learn that their report was recorded, not who else reported.
+		if duplicate {
+			c.JSON(http.StatusOK, gin.H{"report_id": report.ID.Hex(), "status": "already_reported"})
+			return
+		}
+		logger.Info("Report submitted",
+			zap.String("reporter", reporterID),
+			zap.String("target_type", report.TargetType),
+			zap.String("target_id", report.TargetID),
+			zap.Int("count", report.Count),
+		)
+		c.JSON(http.StatusCreated, gin.H{"report_id": report.ID.Hex(), "status": "received"})
+	}
+}
+
+// @Summary List reports
+// @Description Lists reports, most recently updated first.
+// @Tags Moderation
+// @Produce json
+// @Param room_id query string false "Only reports for this room"
+// @Param status query string false "open (default) or resolved"
+// @Param target_type query string false "message or user"
+// @Param limit query int false "Maximum number of reports (default 50, max 200)"
+// @Success 200 {array} Report
+// @Failure 403 {object} map[string]string
+// @Router /chat/moderation/reports [get]
+func ListReportsHandler(c *gin.Context) {
+	status := c.DefaultQuery("status", ReportOpen)
+	limit := parseLimit(c.Query("limit"), 50, 200)
+
+	reports, err := ListReports(c.Request.Context(), c.Query("room_id"), status, c.Query("target_type"), limit)
+	if err != nil {
+		logger.Error("Failed to list reports", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list reports"})
+		return
+	}
+	c.JSON(http.StatusOK, reports)
+}
+
+// @Summary Resolve report
+// @Description Resolves an open report and applies the action: dismiss, hide_message, delete_message or warn_user.
+// @Tags Moderation
+// @Accept json
+// @Produce json
+// @Param id path string true "Report ID"
+// @Param request body resolveReportRequest true "Resolution"
+// @Success 200 {object} Report
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /chat/moderation/reports/{id}/resolve [post]
+func ResolveReportHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		var req resolveReportRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action is required"})
+			return
+		}
+
+		existing, err := FindReportByID(c.Request.Context(), id)
+		if errors.Is(err, ErrReportNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to load report", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve report"})
+			return
+		}
+		if !auth.CanModerate(c, existing.RoomID) {
+			auth.Forbid(c)
+			return
+		}
+
+		report, err := hub.ResolveReport(c.Request.Context(), id, c.GetString("user_id"), req.Action, strings.TrimSpace(req.Note))
+		switch {
+		case errors.Is(err, ErrInvalidResolution):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, ErrReportNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			logger.Error("Failed to resolve report", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve report"})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// bindModActionQuery reads audit log filters from the query string.
+func bindModActionQuery(c *gin.Context) (ModActionQuery, error) {
+	q := ModActionQuery{
+		RoomID:   c.Query("room_id"),
+		ActorID:  c.Query("actor_id"),
+		TargetID: c.Query("target_id"),
+		Action:   c.Query("action"),
+	}
+	var err error
+	if since := c.Query("since"); since != "" {
+		if q.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return q, errors.New("since must be an RFC 3339 timestamp")
+		}
+	}
+	if until := c.Query("until"); until != "" {
+		if q.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return q, errors.New("until must be an RFC 3339 timestamp")
+		}
+	}
+	return q, nil
+}
+
+// @Summary List moderation actions
+// @Description Queries the moderation audit log, newest first.
+// @Tags Moderation
+// @Produce json
+// @Param room_id query string false "Room ID"
+// @Param actor_id query string false "User ID of the moderator (or \"system\")"
+// @Param target_id query string false "Target ID (user, message, report, ...)"
+// @Param action query string false "Action name"
+// @Param since query string false "RFC 3339 lower bound (inclusive)"
+// @Param until query string false "RFC 3339 upper bound (exclusive)"
+// @Param limit query int false "Maximum number of entries (default 100, max 1000)"
+// @Success 200 {array} ModAction
+// @Failure 400 {object} map[string]string
+// @Router /chat/moderation/actions [get]
+func ListModActionsHandler(c *gin.Context) {
+	q, err := bindModActionQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	actions, err := ListModActions(c.Request.Context(), q, parseLimit(c.Query("limit"), 100, 1000))
+	if err != nil {
+		logger.Error("Failed to list moderation actions", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list moderation actions"})
+		return
+	}
+	c.JSON(http.StatusOK, actions)
+}
+
+// @Summary Export moderation actions
+// @Description Streams every matching audit log entry as newline-delimited JSON, oldest first.
+// @Tags Moderation
+// @Produce application/x-ndjson
+// @Param room_id query string false "Room ID"
+// @Param actor_id query string false "User ID of the moderator (or \"system\")"
+// @Param target_id query string false "Target ID"
+// @Param action query string false "Action name"
+// @Param since query string false "RFC 3339 lower bound (inclusive)"
+// @Param until query string false "RFC 3339 upper bound (exclusive)"
+// @Success 200 {string} string "NDJSON stream"
+// @Failure 400 {object} map[string]string
+// @Router /chat/moderation/actions/export [get]
+func ExportModActionsHandler(c *gin.Context) {
+	q, err := bindModActionQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	cursor, err := StreamModActions(ctx, q)
+	if err != nil {
+		logger.Error("Failed to export moderation actions", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export moderation actions"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	filename := "mod_actions_" + time.Now().UTC().Format("20060102T150405Z") + ".ndjson"
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+	exported := 0
+	for cursor.Next(ctx) {
+		var action ModAction
+		if err := cursor.Decode(&action); err != nil {
+			logger.Error("Failed to decode moderation action", zap.Error(err))
+			return
+		}
+		if err := enc.Encode(action); err != nil {
+			return
+		}
+		exported++
+	}
+	if err := cursor.Err(); err != nil {
+		logger.Error("Moderation action export cursor failed", zap.Error(err))
+	}
+	logger.Info("Exported moderation actions", zap.String("by", c.GetString("user_id")), zap.Int("count", exported))
+}
+
+type muteRequest struct {
+	UserID          string `json:"user_id" binding:"required"`
+	RoomID          string `json:"room_id"`
+	DurationSeconds int64  `json:"duration_seconds" binding:"required"`
+	Reason          string `json:"reason"`
+}
+
+// @Summary Time out a user
+// @Description Mutes a user in one room, or in every room when room_id is empty. The user stays connected and keeps
+// @Description receiving messages, but every send is rejected with a "muted" error frame until the timeout expires.
+// @Tags Moderation
+// @Accept json
+// @Produce json
+// @Param request body muteRequest true "Mute"
+// @Success 200 {object} Mute
+// @Failure 400 {object} map[string]string
+// @Router /chat/moderation/mutes [post]
+func MuteUserHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req muteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "user_id and duration_seconds are required"})
+			return
+		}
+
+		// A global mute (no room_id) needs a global moderation role.
+		if !auth.CanModerate(c, strings.TrimSpace(req.RoomID)) {
+			auth.Forbid(c)
+			return
+		}
+
+		duration := time.Duration(req.DurationSeconds) * time.Second
+		mute, err := hub.MuteUser(c.Request.Context(), req.UserID, strings.TrimSpace(req.RoomID), duration,
+			c.GetString("user_id"), strings.TrimSpace(req.Reason))
+		if errors.Is(err, ErrInvalidMute) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "duration_seconds is out of range"})
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to mute user", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to mute user"})
+			return
+		}
+
+		logger.Info("User muted",
+			zap.String("user_id", mute.UserID),
+			zap.String("room_id", mute.RoomID),
+			zap.Int64("seconds", mute.RemainingSeconds),
+			zap.String("by", c.GetString("user_id")),
+		)
+		c.JSON(http.StatusOK, mute)
+	}
+}
+
+// @Summary Lift a timeout
+// @Description Removes a user's mute in one room, or the global mute when room_id is empty.
+// @Tags Moderation
+// @Produce json
+// @Param user_id query string true "User ID"
+// @Param room_id query string false "Room ID (empty for the global mute)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Router /chat/moderation/mutes [delete]
+func UnmuteUserHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Query("user_id")
+		if userID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+			return
+		}
+
+		lifted, err := hub.UnmuteUser(c.Request.Context(), userID, c.Query("room_id"), c.GetString("user_id"))
+		if err != nil {
+			logger.Error("Failed to unmute user", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unmute user"})
+			return
+		}
+		if !lifted {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user is not muted"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"user_id": userID, "room_id": c.Query("room_id"), "muted": false})
+	}
+}
+
+// @Summary Get mute state
+// @Description Returns the active timeout for a user in a room, if any.
+// @Tags Moderation
+// @Produce json
+// @Param userID path string true "User ID"
+// @Param room_id query string false "Room ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /chat/moderation/mutes/{userID} [get]
+func GetMuteHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mute, err := hub.ActiveMute(c.Request.Context(), c.Param("userID"), c.Query("room_id"))
+		if err != nil {
+			logger.Error("Failed to get mute state", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get mute state"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"muted": mute != nil, "mute": mute})
+	}
+}
+
+type shadowBanRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+	Reason string `json:"reason"`
+}
+
+// @Summary Shadow-ban a user
+// @Description The user's messages are stored as hidden and only echoed back to the user's own connections.
+// @Tags Moderation
+// @Accept json
+// @Produce json
+// @Param request body shadowBanRequest true "Shadow ban"
+// @Success 200 {object} ShadowBan
+// @Failure 400 {object} map[string]string
+// @Router /chat/moderation/shadowbans [post]
+func ShadowBanHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req shadowBanRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+			return
+		}
+
+		ban, err := hub.ShadowBanUser(c.Request.Context(), req.UserID, c.GetString("user_id"), strings.TrimSpace(req.Reason))
+		if err != nil {
+			logger.Error("Failed to shadow-ban user", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to shadow-ban user"})
+			return
+		}
+		logger.Info("User shadow-banned", zap.String("user_id", req.UserID), zap.String("by", ban.BannedBy))
+		c.JSON(http.StatusOK, ban)
+	}
+}
+
+// @Summary Lift a shadow ban
+// @Tags Moderation
+// @Produce json
+// @Param userID path string true "User ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Router /chat/moderation/shadowbans/{userID} [delete]
+func LiftShadowBanHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Param("userID")
+		lifted, err := hub.LiftShadowBan(c.Request.Context(), userID, c.GetString("user_id"))
+		if err != nil {
+			logger.Error("Failed to lift shadow ban", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lift shadow ban"})
+			return
+		}
+		if !lifted {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user is not shadow-banned"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"user_id": userID, "shadow_banned": false})
+	}
+}
+
+// @Summary List shadow bans
+// @Tags Moderation
+// @Produce json
+// @Success 200 {array} ShadowBan
+// @Router /chat/moderation/shadowbans [get]
+func ListShadowBansHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bans, err := hub.ListShadowBans(c.Request.Context())
+		if err != nil {
+			logger.Error("Failed to list shadow bans", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list shadow bans"})
+			return
+		}
+		c.JSON(http.StatusOK, bans)
+	}
+}
+
+type slowModeRequest struct {
+	IntervalSeconds int64 `json:"interval_seconds" binding:"required"`
+	// DurationSeconds of 0 keeps slow mode on until it is lifted.
+	DurationSeconds int64  `json:"duration_seconds"`
+	Reason          string `json:"reason"`
+}
+
+// @Summary Get a room's slow mode
+// @Tags Moderation
+// @Produce json
+// @Param roomID path string true "Room ID"
+// @Success 200 {object} SlowMode
+// @Failure 404 {object} map[string]string
+// @Router /chat/moderation/rooms/{roomID}/slowmode [get]
+func GetSlowModeHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mode, err := hub.RoomSlowMode(c.Request.Context(), c.Param("roomID"))
+		if err != nil {
+			logger.Error("Failed to load slow mode", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load slow mode"})
+			return
+		}
+		if mode == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room is not in slow mode"})
+			return
+		}
+		c.JSON(http.StatusOK, mode)
+	}
+}
+
+// @Summary Put a room into slow mode
+// @Description Limits every user in the room to one message per interval.
+// @Tags Moderation
+// @Accept json
+// @Produce json
+// @Param roomID path string true "Room ID"
+// @Param request body slowModeRequest true "Slow mode"
+// @Success 200 {object} SlowMode
+// @Failure 400 {object} map[string]string
+// @Router /chat/moderation/rooms/{roomID}/slowmode [put]
+func SetSlowModeHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req slowModeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "interval_seconds is required"})
+			return
+		}
+
+		mode, err := hub.SetSlowMode(c.Request.Context(), c.Param("roomID"),
+			time.Duration(req.IntervalSeconds)*time.Second, time.Duration(req.DurationSeconds)*time.Second,
+			c.GetString("user_id"), strings.TrimSpace(req.Reason))
+		if errors.Is(err, ErrInvalidSlowMode) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "interval_seconds or duration_seconds is out of range"})
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to set slow mode", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set slow mode"})
+			return
+		}
+		c.JSON(http.StatusOK, mode)
+	}
+}
+
+// @Summary Lift a room's slow mode
+// @Tags Moderation
+// @Produce json
+// @Param roomID path string true "Room ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Router /chat/moderation/rooms/{roomID}/slowmode [delete]
+func ClearSlowModeHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roomID := c.Param("roomID")
+		cleared, err := hub.ClearSlowMode(c.Request.Context(), roomID, c.GetString("user_id"))
+		if err != nil {
+			logger.Error("Failed to clear slow mode", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to clear slow mode"})
+			return
+		}
+		if !cleared {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room is not in slow mode"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"room_id": roomID, "slow_mode": false})
+	}
+}
+
+type lockdownRequest struct {
+	// MinAccountAgeSeconds defaults to LOCKDOWN_MIN_ACCOUNT_AGE_SECONDS when omitted.
+	MinAccountAgeSeconds *int64 `json:"min_account_age_seconds"`
+	MembersOnly          bool   `json:"members_only"`
+	SlowModeSeconds      int64  `json:"slow_mode_seconds"`
+	// DurationSeconds of 0 keeps the lockdown on until it is lifted.
+	DurationSeconds int64  `json:"duration_seconds"`
+	Reason          string `json:"reason"`
+}
+
+// @Summary Get a room's lockdown
+// @Tags Moderation
+// @Produce json
+// @Param roomID path string true "Room ID"
+// @Success 200 {object} Lockdown
+// @Failure 404 {object} map[string]string
+// @Router /chat/moderation/rooms/{roomID}/lockdown [get]
+func GetLockdownHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lockdown, err := hub.RoomLockdown(c.Request.Context(), c.Param("roomID"))
+		if err != nil {
+			logger.Error("Failed to load lockdown", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load lockdown"})
+			return
+		}
+		if lockdown == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room is not locked down"})
+			return
+		}
+		c.JSON(http.StatusOK, lockdown)
+	}
+}
+
+// @Summary Lock a room down
+// @Description Refuses new connections from young accounts and accounts that never chatted in the room.
+// @Description members_only also stops such accounts that are already connected from sending, and
+// @Description slow_mode_seconds puts the room into slow mode for the lockdown.
+// @Tags Moderation
+// @Accept json
+// @Produce json
+// @Param roomID path string true "Room ID"
+// @Param request body lockdownRequest true "Lockdown"
+// @Success 200 {object} Lockdown
+// @Failure 400 {object} map[string]string
+// @Router /chat/moderation/rooms/{roomID}/lockdown [put]
+func LockRoomHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req lockdownRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid lockdown"})
+			return
+		}
+
+		minAge := int64(hub.lockdown.MinAccountAge / time.Second)
+		if req.MinAccountAgeSeconds != nil {
+			minAge = *req.MinAccountAgeSeconds
+		}
+		lockdown, err := hub.LockRoom(c.Request.Context(), Lockdown{
+			RoomID:               c.Param("roomID"),
+			MinAccountAgeSeconds: minAge,
+			MembersOnly:          req.MembersOnly,
+			SlowModeSeconds:      req.SlowModeSeconds,
+			Trigger:              LockdownManual,
+			EnabledBy:            c.GetString("user_id"),
+			Reason:               strings.TrimSpace(req.Reason),
+		}, time.Duration(req.DurationSeconds)*time.Second)
+		if errors.Is(err, ErrInvalidLockdown) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "lockdown settings are out of range"})
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to lock room", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lock room"})
+			return
+		}
+
+		logger.Info("Room locked down", zap.String("room_id", lockdown.RoomID), zap.String("by", lockdown.EnabledBy))
+		c.JSON(http.StatusOK, lockdown)
+	}
+}
+
+// @Summary Lift a room's lockdown
+// @Tags Moderation
+// @Produce json
+// @Param roomID path string true "Room ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Router /chat/moderation/rooms/{roomID}/lockdown [delete]
+func UnlockRoomHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roomID := c.Param("roomID")
+		unlocked, err := hub.UnlockRoom(c.Request.Context(), roomID, c.GetString("user_id"))
+		if err != nil {
+			logger.Error("Failed to unlock room", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock room"})
+			return
+		}
+		if !unlocked {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room is not locked down"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"room_id": roomID, "locked": false})
+	}
+}
+
+type guestAccessRequest struct {
+	AllowGuests *bool `json:"allow_guests" binding:"required"`
+}
+
+// @Summary Get a room's guest access
+// @Description default is true when the room follows GUESTS_ALLOWED_DEFAULT.
+// @Tags Moderation
+// @Produce json
+// @Param roomID path string true "Room ID"
+// @Success 200 {object} GuestAccess
+// @Router /chat/moderation/rooms/{roomID}/guests [get]
+func GetGuestAccessHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		access, err := hub.RoomGuestAccess(c.Request.Context(), c.Param("roomID"))
+		if err != nil {
+			logger.Error("Failed to load guest access", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load guest access"})
+			return
+		}
+		c.JSON(http.StatusOK, access)
+	}
+}
+
+// @Summary Allow or disallow guests in a room
+// @Description Applies to new connections; guests already watching stay until they disconnect.
+// @Tags Moderation
+// @Accept json
+// @Produce json
+// @Param roomID path string true "Room ID"
+// @Param request body guestAccessRequest true "Guest access"
+// @Success 200 {object} GuestAccess
+// @Failure 400 {object} map[string]string
+// @Router /chat/moderation/rooms/{roomID}/guests [put]
+func SetGuestAccessHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req guestAccessRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "allow_guests is required"})
+			return
+		}
+
+		access, err := hub.SetRoomGuestAccess(c.Request.Context(), c.Param("roomID"), *req.AllowGuests, c.GetString("user_id"))
+		if err != nil {
+			logger.Error("Failed to set guest access", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set guest access"})
+			return
+		}
+
+		logger.Info("Room guest access changed", zap.String("room_id", access.RoomID), zap.Bool("allow_guests", access.AllowGuests))
+		c.JSON(http.StatusOK, access)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+// Raid protection: room lockdown, set by moderators or triggered by join and message rate spikes
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/logger"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// ErrRoomLocked is returned when a lockdown keeps a user out of a room.
+var ErrRoomLocked = errors.New("room is locked down")
+
+// ErrInvalidLockdown is returned for lockdown settings outside the allowed range.
+var ErrInvalidLockdown = errors.New("invalid lockdown")
+
+// Lockdown triggers.
+const (
+	LockdownManual      = "manual"
+	LockdownJoinRate    = "join_rate"
+	LockdownMessageRate = "message_rate"
+)
+
+// Lockdown describes an active room lockdown. While it is on, new connections are
+// refused for accounts younger than MinAccountAgeSeconds and for accounts that have
+// never chatted in the room. MembersOnly also stops such accounts that are already
+// connected from sending.
+type Lockdown struct {
+	RoomID               string    `json:"room_id"`
+	MinAccountAgeSeconds int64     `json:"min_account_age_seconds"`
+	MembersOnly          bool      `json:"members_only"`
+	SlowModeSeconds      int64     `json:"slow_mode_seconds,omitempty"`
+	Trigger              string    `json:"trigger"`
+	EnabledBy            string    `json:"enabled_by"`
+	Reason               string    `json:"reason,omitempty"`
+	CreatedAt            time.Time `json:"created_at"`
+	// SlowModeApplied reports whether the lockdown switched slow mode on. A slow mode
+	// that was already on is left alone, and stays when the lockdown is lifted.
+	SlowModeApplied bool `json:"slow_mode_applied,omitempty"`
+	// RemainingSeconds is 0 when the lockdown has no expiry.
+	RemainingSeconds int64 `json:"remaining_seconds,omitempty"`
+}
+
+func lockdownKey(roomID string) string {
+	return "lockdown:room:" + roomID
+}
+
+func roomRateKey(roomID, kind string) string {
+	return "lockdown:rate:" + kind + ":room:" + roomID
+}
+
+// LockRoom puts a room into lockdown for the given duration (0 keeps it on until lifted),
+// replacing any lockdown already in place.
+func (h *Hub) LockRoom(ctx context.Context, lockdown Lockdown, duration time.Duration) (*Lockdown, error) {
+	if lockdown.RoomID == "" || lockdown.MinAccountAgeSeconds < 0 || lockdown.SlowModeSeconds < 0 || duration < 0 {
+		return nil, ErrInvalidLockdown
+	}
+	previous, err := h.RoomLockdown(ctx, lockdown.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	// A slow mode the replaced lockdown switched on belongs to the lockdown, not to a moderator.
+	if previous != nil && previous.SlowModeApplied {
+		if lockdown.SlowModeSeconds > 0 {
+			lockdown.SlowModeApplied = true
+		} else if _, err := h.ClearSlowMode(ctx, lockdown.RoomID, lockdown.EnabledBy); err != nil {
+			logger.Error("Failed to clear lockdown slow mode", zap.String("roomID", lockdown.RoomID), zap.Error(err))
+		}
+	}
+	if _, err := h.storeLockdown(ctx, &lockdown, duration, false); err != nil {
+		return nil, err
+	}
+	return &lockdown, nil
+}
+
+// storeLockdown saves a lockdown and applies its slow mode, unless the room is already
+// in slow mode. With onlyIfUnlocked it leaves an existing lockdown alone and reports
+// false, so concurrent automatic triggers on several instances only lock the room once.
+func (h *Hub) storeLockdown(ctx context.Context, lockdown *Lockdown, duration time.Duration, onlyIfUnlocked bool) (bool, error) {
+	if lockdown.SlowModeSeconds > 0 && !lockdown.SlowModeApplied {
+		slowMode, err := h.RoomSlowMode(ctx, lockdown.RoomID)
+		if err != nil {
+			return false, err
+		}
+		lockdown.SlowModeApplied = slowMode == nil
+	}
+	lockdown.CreatedAt = time.Now()
+	data, err := json.Marshal(lockdown)
+	if err != nil {
+		return false, err
+	}
+	key := lockdownKey(lockdown.RoomID)
+	if onlyIfUnlocked {
+		ok, err := h.redis.SetNX(ctx, key, data, duration).Result()
+		if err != nil || !ok {
+			return false, err
+		}
+	} else if err := h.redis.Set(ctx, key, data, duration).Err(); err != nil {
+		return false, err
+	}
+	lockdown.RemainingSeconds = int64(duration / time.Second)
+
+	if lockdown.SlowModeApplied {
+		_, err := h.SetSlowMode(ctx, lockdown.RoomID, time.Duration(lockdown.SlowModeSeconds)*time.Second, duration,
+			lockdown.EnabledBy, "room lockdown")
+		if err != nil {
+			// The lockdown itself is in place; slow mode can be set by hand.
+			logger.Error("Failed to set slow mode for lockdown", zap.String("roomID", lockdown.RoomID), zap.Error(err))
+		}
+	}
+
+	RecordModAction(ctx, ModAction{
+		Action:     ModActionRoomLocked,
+		ActorID:    lockdown.EnabledBy,
+		TargetType: ModTargetRoom,
+		TargetID:   lockdown.RoomID,
+		RoomID:     lockdown.RoomID,
+		Reason:     lockdown.Reason,
+		Details: map[string]interface{}{
+			"trigger":                 lockdown.Trigger,
+			"min_account_age_seconds": lockdown.MinAccountAgeSeconds,
+			"members_only":            lockdown.MembersOnly,
+			"slow_mode_seconds":       lockdown.SlowModeSeconds,
+			"duration_seconds":        int64(duration / time.Second),
+		},
+	})
+	return true, nil
+}
+
+// UnlockRoom lifts a lockdown, along with the slow mode it switched on. A slow mode
+// that was on before the lockdown stays. It reports whether the room was locked.
+func (h *Hub) UnlockRoom(ctx context.Context, roomID, actorID string) (bool, error) {
+	lockdown, err := h.RoomLockdown(ctx, roomID)
+	if err != nil || lockdown == nil {
+		return false, err
+	}
+	n, err := h.redis.Del(ctx, lockdownKey(roomID)).Result()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if lockdown.SlowModeApplied {
+		if _, err := h.ClearSlowMode(ctx, roomID, actorID); err != nil {
+			logger.Error("Failed to clear lockdown slow mode", zap.String("roomID", roomID), zap.Error(err))
+		}
+	}
+
+	RecordModAction(ctx, ModAction{
+		Action:     ModActionRoomUnlocked,
+		ActorID:    actorID,
+		TargetType: ModTargetRoom,
+		TargetID:   roomID,
+		RoomID:     roomID,
+	})
+	return true, nil
+}
+
+// RoomLockdown returns the room's active lockdown, or nil.
+func (h *Hub) RoomLockdown(ctx context.Context, roomID string) (*Lockdown, error) {
+	pipe := h.redis.Pipeline()
+	value := pipe.Get(ctx, lockdownKey(roomID))
+	ttl := pipe.PTTL(ctx, lockdownKey(roomID))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	if errors.Is(value.Err(), redis.Nil) {
+		return nil, nil
+	}
+
+	var lockdown Lockdown
+	if err := json.Unmarshal([]byte(value.Val()), &lockdown); err != nil {
+		return nil, fmt.Errorf("decode lockdown: %w", err)
+	}
+	if remaining := ttl.Val(); remaining > 0 {
+		lockdown.RemainingSeconds = ceilSeconds(remaining)
+	}
+	return &lockdown, nil
+}
+
+// isEstablished reports whether a user may get past a lockdown: moderators always
+// can, everyone else needs an old enough account (when minAge is set) and a
+// message history in the room.
+func (h *Hub) isEstablished(ctx context.Context, roomID string, user *UserClaims, minAge time.Duration) (bool, error) {
+	if user.Roles.CanModerate(roomID) {
+		return true, nil
+	}
+	// Tokens without an account age are treated as brand-new accounts.
+	if minAge > 0 && (user.AccountCreatedAt.IsZero() || time.Since(user.AccountCreatedAt) < minAge) {
+		return false, nil
+	}
+	return HasChattedInRoom(ctx, roomID, user.UserID)
+}
+
+// admitToRoom decides whether a user may join a room and counts the join towards
+// the automatic lockdown. It returns ErrRoomLocked when a lockdown keeps the user out.
+func (h *Hub) admitToRoom(ctx context.Context, roomID string, user *UserClaims) error {
+	return h.admit(ctx, roomID, user, true)
+}
+
+// readmitToRoom applies the rules of admitToRoom to a connection that stays in its
+// room under a new token, without counting it as another join.
+func (h *Hub) readmitToRoom(ctx context.Context, roomID string, user *UserClaims) error {
+	return h.admit(ctx, roomID, user, false)
+}
+
+func (h *Hub) admit(ctx context.Context, roomID string, user *UserClaims, countJoin bool) error {
+	if isGuest(user) {
+		return h.admitGuest(ctx, roomID)
+	}
+	if user.Bot != nil {
+		return admitBot(roomID, user.Bot)
+	}
+	lockdown, err := h.RoomLockdown(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if lockdown != nil {
+		ok, err := h.isEstablished(ctx, roomID, user, time.Duration(lockdown.MinAccountAgeSeconds)*time.Second)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrRoomLocked
+		}
+		return nil
+	}
+
+	if countJoin {
+		h.countRoomActivity(ctx, roomID, LockdownJoinRate, h.lockdown.JoinRateLimit)
+	}
+	return nil
+}
+
+// checkMembersOnly returns ErrRoomLocked when a members-only lockdown stops the user from sending.
+// Outside a lockdown it counts the message towards the automatic lockdown. Bots are not held back.
+func (h *Hub) checkMembersOnly(ctx context.Context, roomID string, user *UserClaims) error {
+	if user.Bot != nil {
+		return nil
+	}
+	lockdown, err := h.RoomLockdown(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if lockdown == nil {
+		h.countRoomActivity(ctx, roomID, LockdownMessageRate, h.lockdown.MessageRateLimit)
+		return nil
+	}
+	if !lockdown.MembersOnly {
+		return nil
+	}
+	ok, err := h.isEstablished(ctx, roomID, user, time.Duration(lockdown.MinAccountAgeSeconds)*time.Second)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrRoomLocked
+	}
+	return nil
+}
+
+// countRoomActivity counts a join or message in a fixed window and locks the room
+// automatically once the count passes limit. A limit of 0 disables the trigger.
+// Errors are only logged: rate tracking must never block chatting.
+func (h *Hub) countRoomActivity(ctx context.Context, roomID, trigger string, limit int) {
+	if limit <= 0 {
+		return
+	}
+	key := roomRateKey(roomID, trigger)
+	pipe := h.redis.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error("Failed to count room activity", zap.String("roomID", roomID), zap.Error(err))
+		return
+	}
+	// Start the window on the first count, or repair a counter that lost its expiry.
+	if ttl.Val() < 0 {
+		if err := h.redis.Expire(ctx, key, h.lockdown.RateWindow).Err(); err != nil {
+			logger.Error("Failed to expire room activity counter", zap.String("roomID", roomID), zap.Error(err))
+		}
+	}
+	count := incr.Val()
+	if count <= int64(limit) {
+		return
+	}
+
+	lockdown := &Lockdown{
+		RoomID:               roomID,
+		MinAccountAgeSeconds: int64(h.lockdown.MinAccountAge / time.Second),
+		MembersOnly:          h.lockdown.AutoMembersOnly,
+		SlowModeSeconds:      int64(h.lockdown.AutoSlowMode / time.Second),
+		Trigger:              trigger,
+		EnabledBy:            SystemActor,
+		Reason:               fmt.Sprintf("%s exceeded %d per %s", trigger, limit, h.lockdown.RateWindow),
+	}
+	locked, err := h.storeLockdown(ctx, lockdown, h.lockdown.AutoDuration, true)
+	if err != nil {
+		logger.Error("Failed to lock room automatically", zap.String("roomID", roomID), zap.Error(err))
+		return
+	}
+	if locked {
+		logger.Warn("Room locked down automatically",
+			zap.String("roomID", roomID),
+			zap.String("trigger", trigger),
+			zap.Int64("count", count),
+		)
+	}
+}
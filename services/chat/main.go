@@ -13,6 +13,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/celesteyang/ChatOrbit/shared/auth"
 	"github.com/celesteyang/ChatOrbit/shared/logger"
 	"github.com/celesteyang/ChatOrbit/shared/swagger"
 	"github.com/gin-contrib/cors"
@@ -74,23 +75,67 @@ func main() {
 	}
 
 	logger.Info("Starting chat service")
+	cfg := LoadConfig()
+	jwksURL := getEnvOrDefault("JWKS_URL", "")
+	if jwksURL == "" {
+		logger.Fatal("JWKS_URL environment variable is not set")
+	}
+	revocations := auth.NewRevocations(redisClient)
+	jwks := auth.NewJWKSClient(jwksURL, cfg.JWKSCache)
+	verifier := auth.NewVerifier(jwks.Keyfunc, cfg.Verifier, revocations)
 
 	r := gin.Default()
 	r.Use(cors.Default())
 	swagger.InitSwagger(r, "Chat Service")
-	hub := NewHub(redisClient)
+	filterStore := NewFilterStore(redisClient)
+	go filterStore.WatchReloads(context.Background())
+	hub := NewHub(redisClient, cfg, NewFilterChain(NewWordFilter(filterStore), NewLinkFilter(filterStore)), verifier, revocations)
 	// hub instance run in a separate goroutine
 	go hub.Run()
+	go NewPrivacyWorker().Run(context.Background(), cfg.PrivacyPollInterval)
 
 	// Define routes and pass Hub instance to handlers
-	r.GET("/ws/chat", ChatWebSocketHandler(hub))
+	r.GET("/ws/chat", verifier.Middleware(), ChatWebSocketHandler(hub))
 	r.GET("/test", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "Hello World!"})
 	})
 	// RESTful API for chat history
-	r.GET("/chat/history/:roomID", GetChatHistoryHandler)
+	r.GET("/chat/history/:roomID", verifier.OptionalMiddleware(),
+		auth.RequireBotScope(auth.ScopeReadHistory, auth.RoomFromParam("roomID")), GetChatHistoryHandler)
 	r.POST("/chat/rooms", CreateRoomHandler)
+	r.POST("/chat/reports", verifier.Middleware(), auth.DenyGuests(), CreateReportHandler(hub))
 	r.GET("/chat/rooms/:roomID/presence", GetRoomPresenceHandler(hub))
+
+	// Moderation API. Global roles (admin, staff, moderator) moderate every room;
+	// room-scoped moderators only the rooms they were granted. Bots need the moderate scope.
+	mod := r.Group("/chat/moderation", verifier.Middleware(), auth.RequireBotScope(auth.ScopeModerate, nil), auth.RequireAnyModerator())
+	pathRoom := auth.RequireRoomModerator(auth.RoomFromParam("roomID"))
+	queryRoom := auth.RequireRoomModerator(auth.RoomFromQuery("room_id"))
+	globalOnly := auth.RequireRole(auth.RoleAdmin, auth.RoleStaff, auth.RoleModerator)
+	mod.GET("/rooms/:roomID/filters", pathRoom, GetRoomFiltersHandler(filterStore))
+	mod.PUT("/rooms/:roomID/filters", pathRoom, UpdateRoomFiltersHandler(filterStore))
+	mod.GET("/rooms/:roomID/slowmode", pathRoom, GetSlowModeHandler(hub))
+	mod.PUT("/rooms/:roomID/slowmode", pathRoom, SetSlowModeHandler(hub))
+	mod.DELETE("/rooms/:roomID/slowmode", pathRoom, ClearSlowModeHandler(hub))
+	mod.GET("/rooms/:roomID/lockdown", pathRoom, GetLockdownHandler(hub))
+	mod.PUT("/rooms/:roomID/lockdown", pathRoom, LockRoomHandler(hub))
+	mod.DELETE("/rooms/:roomID/lockdown", pathRoom, UnlockRoomHandler(hub))
+	mod.GET("/rooms/:roomID/guests", pathRoom, GetGuestAccessHandler(hub))
+	mod.PUT("/rooms/:roomID/guests", pathRoom, SetGuestAccessHandler(hub))
+	mod.GET("/held", queryRoom, ListHeldMessagesHandler)
+	mod.POST("/held/:id/approve", ApproveHeldMessageHandler(hub))
+	mod.POST("/held/:id/reject", RejectHeldMessageHandler(hub))
+	mod.GET("/reports", queryRoom, ListReportsHandler)
+	mod.POST("/reports/:id/resolve", ResolveReportHandler(hub))
+	mod.GET("/actions", queryRoom, ListModActionsHandler)
+	mod.GET("/actions/export", queryRoom, ExportModActionsHandler)
+	mod.POST("/mutes", MuteUserHandler(hub))
+	mod.DELETE("/mutes", queryRoom, UnmuteUserHandler(hub))
+	mod.GET("/mutes/:userID", queryRoom, GetMuteHandler(hub))
+	mod.GET("/shadowbans", globalOnly, ListShadowBansHandler(hub))
+	mod.POST("/shadowbans", globalOnly, ShadowBanHandler(hub))
+	mod.DELETE("/shadowbans/:userID", globalOnly, LiftShadowBanHandler(hub))
+	r.GET("/ws/moderation", verifier.Middleware(), auth.RequireRoomModerator(auth.RoomFromQuery("room_id")), ModerationFeedHandler(hub))
 	// Run the server
 	if err := r.Run(":" + servicePort); err != nil {
 		logger.Fatal("Failed to run server", zap.Error(err))
@@ -5,6 +5,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/celesteyang/ChatOrbit/shared/privacy"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -12,8 +13,12 @@ import (
 )
 
 var (
-	messageCollection *mongo.Collection
-	roomCollection    *mongo.Collection
+	messageCollection     *mongo.Collection
+	roomCollection        *mongo.Collection
+	roomFilterCollection  *mongo.Collection
+	heldMessageCollection *mongo.Collection
+	reportCollection      *mongo.Collection
+	modActionCollection   *mongo.Collection
 )
 
 // Message represents a chat message stored in MongoDB.
@@ -23,19 +28,51 @@ type Message struct {
 	UserID    string             `bson:"user_id" json:"user_id"`
 	Content   string             `bson:"content" json:"content"`
 	Timestamp time.Time          `bson:"timestamp" json:"timestamp"`
+	// Bot marks messages sent with a bot's API key.
+	Bot bool `bson:"bot,omitempty" json:"bot,omitempty"`
+	// Hidden messages are kept for moderators but left out of room history.
+	Hidden       bool   `bson:"hidden,omitempty" json:"hidden,omitempty"`
+	HiddenReason string `bson:"hidden_reason,omitempty" json:"hidden_reason,omitempty"`
+}
+
+// Reasons a stored message can be hidden.
+const (
+	HiddenByReports   = "reports"
+	HiddenByModerator = "moderator"
+	HiddenByShadowBan = "shadow_ban"
+)
+
+// HistoryViewer identifies who is reading room history, which decides what hidden messages they see.
+type HistoryViewer struct {
+	UserID    string
+	Moderator bool
 }
 
 // InitCollections sets up the MongoDB collections and creates necessary indexes.
 func InitCollections(db *mongo.Database) {
 	messageCollection = db.Collection("messages")
 	roomCollection = db.Collection("rooms")
+	roomFilterCollection = db.Collection("room_filters")
+	heldMessageCollection = db.Collection("held_messages")
+	reportCollection = db.Collection("reports")
+	modActionCollection = db.Collection("mod_actions")
+	privacyStore = privacy.NewStore(db)
 
 	// Create room_id index to optimize queries.
-	_, err := messageCollection.Indexes().CreateOne(
+	_, err := messageCollection.Indexes().CreateMany(
 		context.Background(),
-		mongo.IndexModel{
-			Keys:    bson.D{{Key: "room_id", Value: 1}},
-			Options: options.Index().SetUnique(false),
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "room_id", Value: 1}},
+				Options: options.Index().SetUnique(false),
+			},
+			{
+				Keys: bson.D{{Key: "room_id", Value: 1}, {Key: "user_id", Value: 1}},
+			},
+			// Exports and deletions look up all messages of a user.
+			{
+				Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "timestamp", Value: 1}},
+			},
 		},
 	)
 	if err != nil {
@@ -52,12 +89,77 @@ func InitCollections(db *mongo.Database) {
 	if err != nil {
 		panic("Failed to create index on rooms collection: " + err.Error())
 	}
+
+	_, err = roomFilterCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "room_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	)
+	if err != nil {
+		panic("Failed to create index on room_filters collection: " + err.Error())
+	}
+
+	_, err = heldMessageCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "room_id", Value: 1}, {Key: "created_at", Value: 1}},
+		},
+	)
+	if err != nil {
+		panic("Failed to create index on held_messages collection: " + err.Error())
+	}
+
+	// At most one open report per target; further reports on it are merged into that document.
+	_, err = reportCollection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{
+				Keys: bson.D{{Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}},
+				Options: options.Index().
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"status": ReportOpen}),
+			},
+			{
+				Keys: bson.D{{Key: "status", Value: 1}, {Key: "room_id", Value: 1}, {Key: "updated_at", Value: -1}},
+			},
+		},
+	)
+	if err != nil {
+		panic("Failed to create indexes on reports collection: " + err.Error())
+	}
+
+	_, err = modActionCollection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{Keys: bson.D{{Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "room_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+	)
+	if err != nil {
+		panic("Failed to create indexes on mod_actions collection: " + err.Error())
+	}
 }
 
-// Insert the message to the database.
+// Insert the message to the database and fill in its generated ID, so published frames carry it.
 func InsertMessage(ctx context.Context, msg *Message) error {
-	_, err := messageCollection.InsertOne(ctx, msg)
-	return err
+	res, err := messageCollection.InsertOne(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		msg.ID = id
+	}
+	return nil
+}
+
+// HasChattedInRoom reports whether the user has any stored message in the room.
+func HasChattedInRoom(ctx context.Context, roomID, userID string) (bool, error) {
+	count, err := messageCollection.CountDocuments(ctx, bson.M{"room_id": roomID, "user_id": userID}, options.Count().SetLimit(1))
+	return count > 0, err
 }
 
 // EnsureRoomExists creates the room document if it does not exist.
@@ -100,22 +202,61 @@ func EnsureRoomExists(ctx context.Context, roomID string) error {
 
 // GetMessagesByRoom retrieves chat messages for a specific room with pagination.
 // It sorts messages by timestamp in descending order (newest first).
-func GetMessagesByRoom(ctx context.Context, roomID string, limit int64) ([]Message, error) {
+// Hidden messages are only returned to moderators; a shadow-banned author also
+// sees their own messages so the ban is not noticeable.
+func GetMessagesByRoom(ctx context.Context, roomID string, limit int64, viewer HistoryViewer) ([]Message, error) {
 	// Find options: sort by timestamp descending, and limit the results.
 	findOptions := options.Find().
 		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
 		SetLimit(limit)
 
-	cursor, err := messageCollection.Find(ctx, bson.M{"room_id": roomID}, findOptions)
+	filter := bson.M{"room_id": roomID}
+	switch {
+	case viewer.Moderator:
+	case viewer.UserID != "":
+		filter["$or"] = bson.A{
+			bson.M{"hidden": bson.M{"$ne": true}},
+			bson.M{"user_id": viewer.UserID, "hidden_reason": HiddenByShadowBan},
+		}
+	default:
+		filter["hidden"] = bson.M{"$ne": true}
+	}
+
+	cursor, err := messageCollection.Find(ctx, filter, findOptions)
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var messages []Message
+	messages := []Message{}
 	if err = cursor.All(ctx, &messages); err != nil {
 		return nil, err
 	}
 
 	return messages, nil
 }
+
+// FindMessageByID fetches a stored message.
+func FindMessageByID(ctx context.Context, id primitive.ObjectID) (*Message, error) {
+	var msg Message
+	if err := messageCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// SetMessageHidden hides or restores a stored message.
+func SetMessageHidden(ctx context.Context, id primitive.ObjectID, hidden bool, reason string) error {
+	update := bson.M{"$set": bson.M{"hidden": true, "hidden_reason": reason}}
+	if !hidden {
+		update = bson.M{"$unset": bson.M{"hidden": "", "hidden_reason": ""}}
+	}
+	_, err := messageCollection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// DeleteMessage removes a stored message.
+func DeleteMessage(ctx context.Context, id primitive.ObjectID) error {
+	_, err := messageCollection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
@@ -0,0 +1,116 @@
+package main
+
+// The chat service's part of data exports and account deletions, queued by the auth service
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/celesteyang/ChatOrbit/shared/logger"
+	"github.com/celesteyang/ChatOrbit/shared/privacy"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// exportChunkSize is how many messages go into one file of an export archive.
+const exportChunkSize = 1000
+
+// deletedUserPrefix starts the pseudonym anonymized messages are attributed to.
+const deletedUserPrefix = "deleted:"
+
+var privacyStore *privacy.Store
+
+// NewPrivacyWorker returns the worker carrying out this service's tasks of privacy jobs.
+func NewPrivacyWorker() *privacy.Worker {
+	return privacy.NewWorker(privacyStore, privacy.ServiceChat, exportMessages, deleteMessages, reportPrivacyTask)
+}
+
+func reportPrivacyTask(job *privacy.Job, err error) {
+	switch {
+	case job == nil:
+		logger.Error("Failed to claim privacy task", zap.Error(err))
+	case err != nil:
+		logger.Error("Privacy task failed", zap.String("job", job.ID), zap.String("type", job.Type), zap.Error(err))
+	default:
+		logger.Info("Privacy task completed", zap.String("job", job.ID), zap.String("type", job.Type))
+	}
+}
+
+// exportMessages writes every message of the user, oldest first and exportChunkSize
+// per file, and their messages held for review to the export archive.
+func exportMessages(ctx context.Context, job *privacy.Job) error {
+	cursor, err := messageCollection.Find(ctx, bson.M{"user_id": job.UserID},
+		options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	chunk := make([]Message, 0, exportChunkSize)
+	files := 0
+	flush := func() error {
+		files++
+		err := privacyStore.WriteFile(ctx, job, privacy.ServiceChat, fmt.Sprintf("messages-%04d.json", files), chunk)
+		chunk = chunk[:0]
+		return err
+	}
+	for cursor.Next(ctx) {
+		var msg Message
+		if err := cursor.Decode(&msg); err != nil {
+			return err
+		}
+		chunk = append(chunk, msg)
+		if len(chunk) == exportChunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if len(chunk) > 0 || files == 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	heldCursor, err := heldMessageCollection.Find(ctx, bson.M{"user_id": job.UserID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}),
+	)
+	if err != nil {
+		return err
+	}
+	held := []HeldMessage{}
+	if err := heldCursor.All(ctx, &held); err != nil {
+		return err
+	}
+	return privacyStore.WriteFile(ctx, job, privacy.ServiceChat, "held_messages.json", held)
+}
+
+// deleteMessages removes the user's messages, including those held for review, or
+// with the anonymize policy attributes them to a random pseudonym that cannot be
+// traced back to the user.
+func deleteMessages(ctx context.Context, job *privacy.Job) error {
+	filter := bson.M{"user_id": job.UserID}
+	if job.Policy == privacy.PolicyDelete {
+		if _, err := messageCollection.DeleteMany(ctx, filter); err != nil {
+			return err
+		}
+		_, err := heldMessageCollection.DeleteMany(ctx, filter)
+		return err
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	update := bson.M{"$set": bson.M{"user_id": deletedUserPrefix + hex.EncodeToString(b)}}
+	if _, err := messageCollection.UpdateMany(ctx, filter, update); err != nil {
+		return err
+	}
+	_, err := heldMessageCollection.UpdateMany(ctx, filter, update)
+	return err
+}
@@ -0,0 +1,417 @@
+package main
+
+// User reports on messages and users
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// Report target types.
+const (
+	ReportTargetMessage = "message"
+	ReportTargetUser    = "user"
+)
+
+// Report states.
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+// Actions a moderator can take when resolving a report.
+const (
+	ReportActionDismiss       = "dismiss"
+	ReportActionHideMessage   = "hide_message"
+	ReportActionDeleteMessage = "delete_message"
+	ReportActionWarnUser      = "warn_user"
+)
+
+// reportCategories are the accepted reason categories.
+var reportCategories = map[string]bool{
+	"spam":       true,
+	"harassment": true,
+	"hate":       true,
+	"sexual":     true,
+	"violence":   true,
+	"self_harm":  true,
+	"other":      true,
+}
+
+const maxReportNoteRunes = 500
+
+// Errors returned when a report cannot be filed or resolved.
+var (
+	ErrInvalidReport     = errors.New("invalid report")
+	ErrReportNotFound    = errors.New("report not found or already resolved")
+	ErrReportTargetGone  = errors.New("reported message not found")
+	ErrReportOwnContent  = errors.New("you cannot report yourself")
+	ErrInvalidResolution = errors.New("invalid resolution action")
+)
+
+// ReportEntry is one user's report on a target.
+type ReportEntry struct {
+	ReporterID string    `bson:"reporter_id" json:"reporter_id"`
+	Category   string    `bson:"category" json:"category"`
+	Note       string    `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+}
+
+// Report groups every open report on one message or user.
+type Report struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	TargetType     string              `bson:"target_type" json:"target_type"`
+	TargetID       string              `bson:"target_id" json:"target_id"`
+	TargetUserID   string              `bson:"target_user_id" json:"target_user_id"`
+	RoomID         string              `bson:"room_id,omitempty" json:"room_id,omitempty"`
+	Entries        []ReportEntry       `bson:"entries" json:"entries"`
+	Count          int                 `bson:"count" json:"count"`
+	Status         string              `bson:"status" json:"status"`
+	AutoHidden     bool                `bson:"auto_hidden,omitempty" json:"auto_hidden,omitempty"`
+	HeldID         *primitive.ObjectID `bson:"held_id,omitempty" json:"held_id,omitempty"`
+	CreatedAt      time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time           `bson:"updated_at" json:"updated_at"`
+	ResolvedBy     string              `bson:"resolved_by,omitempty" json:"resolved_by,omitempty"`
+	ResolvedAt     *time.Time          `bson:"resolved_at,omitempty" json:"resolved_at,omitempty"`
+	Action         string              `bson:"action,omitempty" json:"action,omitempty"`
+	ResolutionNote string              `bson:"resolution_note,omitempty" json:"resolution_note,omitempty"`
+}
+
+// ReportRequest is what a viewer submits to report a message or a user.
+type ReportRequest struct {
+	TargetType string `json:"target_type" binding:"required"`
+	TargetID   string `json:"target_id" binding:"required"`
+	RoomID     string `json:"room_id"`
+	Category   string `json:"category" binding:"required"`
+	Note       string `json:"note"`
+}
+
+// SubmitReport records a report and hides the message once it reaches the configured threshold.
+// Repeated reports by the same user on the same open target are ignored and reported as duplicates.
+func (h *Hub) SubmitReport(ctx context.Context, reporterID string, req ReportRequest) (*Report, bool, error) {
+	category := strings.ToLower(strings.TrimSpace(req.Category))
+	if !reportCategories[category] {
+		return nil, false, fmt.Errorf("%w: unknown category %q", ErrInvalidReport, req.Category)
+	}
+	note := strings.TrimSpace(req.Note)
+	if len([]rune(note)) > maxReportNoteRunes {
+		return nil, false, fmt.Errorf("%w: note is too long", ErrInvalidReport)
+	}
+
+	targetUserID, roomID := "", strings.TrimSpace(req.RoomID)
+	switch req.TargetType {
+	case ReportTargetMessage:
+		msgID, err := primitive.ObjectIDFromHex(req.TargetID)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: invalid message id", ErrInvalidReport)
+		}
+		msg, err := FindMessageByID(ctx, msgID)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, ErrReportTargetGone
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		targetUserID, roomID = msg.UserID, msg.RoomID
+	case ReportTargetUser:
+		targetUserID = req.TargetID
+	default:
+		return nil, false, fmt.Errorf("%w: unknown target type %q", ErrInvalidReport, req.TargetType)
+	}
+	if targetUserID == reporterID {
+		return nil, false, ErrReportOwnContent
+	}
+
+	now := time.Now()
+	entry := ReportEntry{ReporterID: reporterID, Category: category, Note: note, CreatedAt: now}
+	setOnInsert := bson.M{"target_user_id": targetUserID, "created_at": now}
+	if roomID != "" {
+		setOnInsert["room_id"] = roomID
+	}
+
+	var report Report
+	err := reportCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{
+			"target_type":         req.TargetType,
+			"target_id":           req.TargetID,
+			"status":              ReportOpen,
+			"entries.reporter_id": bson.M{"$ne": reporterID},
+		},
+		bson.M{
+			"$push":        bson.M{"entries": entry},
+			"$inc":         bson.M{"count": 1},
+			"$set":         bson.M{"updated_at": now},
+			"$setOnInsert": setOnInsert,
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&report)
+	if mongo.IsDuplicateKeyError(err) {
+		// The open report exists and already contains this reporter.
+		existing, findErr := FindOpenReport(ctx, req.TargetType, req.TargetID)
+		if findErr != nil {
+			return nil, false, findErr
+		}
+		return existing, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if report.TargetType == ReportTargetMessage && h.reportHideThreshold > 0 && report.Count >= h.reportHideThreshold {
+		if err := h.autoHideReportedMessage(ctx, &report); err != nil {
+			logger.Error("Failed to auto-hide reported message", zap.String("reportID", report.ID.Hex()), zap.Error(err))
+		}
+	}
+	return &report, false, nil
+}
+
+// autoHideReportedMessage hides a message that crossed the report threshold and queues it for review.
+// Only the first caller to flag the report does the work.
+func (h *Hub) autoHideReportedMessage(ctx context.Context, report *Report) error {
+	res, err := reportCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": report.ID, "auto_hidden": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"auto_hidden": true}},
+	)
+	if err != nil || res.ModifiedCount == 0 {
+		return err
+	}
+	report.AutoHidden = true
+
+	msgID, err := primitive.ObjectIDFromHex(report.TargetID)
+	if err != nil {
+		return err
+	}
+	msg, err := FindMessageByID(ctx, msgID)
+	if err != nil {
+		return err
+	}
+	if err := SetMessageHidden(ctx, msgID, true, HiddenByReports); err != nil {
+		return err
+	}
+	h.publishRoomEvent(ctx, RoomEvent{Type: EventMessageHidden, RoomID: msg.RoomID, MessageID: msg.ID.Hex()})
+	RecordModAction(ctx, ModAction{
+		Action:     ModActionMessageHidden,
+		ActorID:    SystemActor,
+		TargetType: ModTargetMessage,
+		TargetID:   msg.ID.Hex(),
+		RoomID:     msg.RoomID,
+		Reason:     fmt.Sprintf("reached %d reports", report.Count),
+		Details:    map[string]interface{}{"author_id": msg.UserID, "report_id": report.ID.Hex()},
+	})
+
+	held := &HeldMessage{
+		RoomID:    msg.RoomID,
+		UserID:    msg.UserID,
+		Content:   msg.Content,
+		MessageID: &msgID,
+		Source:    HeldSourceReport,
+		Reason:    fmt.Sprintf("reported by %d users", report.Count),
+	}
+	if err := h.HoldMessage(ctx, held); err != nil {
+		return err
+	}
+	report.HeldID = &held.ID
+	_, err = reportCollection.UpdateOne(ctx, bson.M{"_id": report.ID}, bson.M{"$set": bson.M{"held_id": held.ID}})
+	return err
+}
+
+// ResolveReport closes an open report, records the moderator's decision and applies it.
+func (h *Hub) ResolveReport(ctx context.Context, id primitive.ObjectID, moderatorID, action, note string) (*Report, error) {
+	switch action {
+	case ReportActionDismiss, ReportActionWarnUser:
+	case ReportActionHideMessage, ReportActionDeleteMessage:
+	default:
+		return nil, ErrInvalidResolution
+	}
+
+	existing, err := FindReportByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if existing.TargetType != ReportTargetMessage && (action == ReportActionHideMessage || action == ReportActionDeleteMessage) {
+		return nil, ErrInvalidResolution
+	}
+
+	report, err := CloseReport(ctx, bson.M{"_id": id}, moderatorID, action, note)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.applyReportAction(ctx, report, moderatorID, action, note); err != nil {
+		return nil, err
+	}
+	RecordModAction(ctx, ModAction{
+		Action:     ModActionReportResolved,
+		ActorID:    moderatorID,
+		TargetType: ModTargetReport,
+		TargetID:   report.ID.Hex(),
+		RoomID:     report.RoomID,
+		Reason:     note,
+		Details: map[string]interface{}{
+			"resolution":     action,
+			"target_type":    report.TargetType,
+			"target_id":      report.TargetID,
+			"target_user_id": report.TargetUserID,
+			"report_count":   report.Count,
+		},
+	})
+
+	// A message hidden by reports also sits in the review queue; close that entry too.
+	if report.HeldID != nil {
+		status := HeldRejected
+		if action == ReportActionDismiss {
+			status = HeldApproved
+		}
+		held, err := ResolveHeldMessage(ctx, *report.HeldID, status, moderatorID, note)
+		if err == nil {
+			h.publishModerationEvent(ctx, EventHeldResolved, held)
+		} else if !errors.Is(err, ErrHeldNotPending) {
+			logger.Error("Failed to resolve held message for report", zap.Error(err))
+		}
+	}
+	return report, nil
+}
+
+func (h *Hub) applyReportAction(ctx context.Context, report *Report, moderatorID, action, note string) error {
+	var msgID primitive.ObjectID
+	if report.TargetType == ReportTargetMessage {
+		var err error
+		if msgID, err = primitive.ObjectIDFromHex(report.TargetID); err != nil {
+			return err
+		}
+	}
+
+	audit := ModAction{
+		ActorID:    moderatorID,
+		TargetType: ModTargetMessage,
+		TargetID:   report.TargetID,
+		RoomID:     report.RoomID,
+		Reason:     note,
+		Details:    map[string]interface{}{"author_id": report.TargetUserID, "report_id": report.ID.Hex()},
+	}
+
+	switch action {
+	case ReportActionDismiss:
+		if !report.AutoHidden {
+			return nil
+		}
+		if err := SetMessageHidden(ctx, msgID, false, ""); err != nil {
+			return err
+		}
+		h.publishRoomEvent(ctx, RoomEvent{Type: EventMessageRestored, RoomID: report.RoomID, MessageID: report.TargetID})
+		audit.Action = ModActionMessageRestored
+	case ReportActionHideMessage:
+		if err := SetMessageHidden(ctx, msgID, true, HiddenByModerator); err != nil {
+			return err
+		}
+		h.publishRoomEvent(ctx, RoomEvent{Type: EventMessageHidden, RoomID: report.RoomID, MessageID: report.TargetID})
+		audit.Action = ModActionMessageHidden
+	case ReportActionDeleteMessage:
+		if err := DeleteMessage(ctx, msgID); err != nil {
+			return err
+		}
+		h.publishRoomEvent(ctx, RoomEvent{Type: EventMessageDeleted, RoomID: report.RoomID, MessageID: report.TargetID})
+		audit.Action = ModActionMessageDeleted
+	case ReportActionWarnUser:
+		message := "a moderator has warned you about your behaviour"
+		if note != "" {
+			message += ": " + note
+		}
+		if err := h.NotifyUser(ctx, report.TargetUserID, ErrorFrame{Type: FrameWarning, Code: ReasonModeratorWarning, Message: message}); err != nil {
+			logger.Error("Failed to deliver warning", zap.Error(err))
+		}
+		audit.Action = ModActionUserWarned
+		audit.TargetType = ModTargetUser
+		audit.TargetID = report.TargetUserID
+	}
+	RecordModAction(ctx, audit)
+	return nil
+}
+
+// CloseReport atomically resolves the open report matching filter.
+func CloseReport(ctx context.Context, filter bson.M, moderatorID, action, note string) (*Report, error) {
+	now := time.Now()
+	set := bson.M{
+		"status":      ReportResolved,
+		"resolved_by": moderatorID,
+		"resolved_at": now,
+		"action":      action,
+		"updated_at":  now,
+	}
+	if note != "" {
+		set["resolution_note"] = note
+	}
+	filter["status"] = ReportOpen
+
+	var report Report
+	err := reportCollection.FindOneAndUpdate(ctx, filter, bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&report)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// FindReportByID returns a report, or ErrReportNotFound.
+func FindReportByID(ctx context.Context, id primitive.ObjectID) (*Report, error) {
+	var report Report
+	err := reportCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&report)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// FindOpenReport returns the open report on a target.
+func FindOpenReport(ctx context.Context, targetType, targetID string) (*Report, error) {
+	var report Report
+	err := reportCollection.FindOne(ctx, bson.M{"target_type": targetType, "target_id": targetID, "status": ReportOpen}).Decode(&report)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// ListReports returns reports, most recently updated first.
+func ListReports(ctx context.Context, roomID, status, targetType string, limit int64) ([]Report, error) {
+	filter := bson.M{}
+	if roomID != "" {
+		filter["room_id"] = roomID
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+	if targetType != "" {
+		filter["target_type"] = targetType
+	}
+
+	cursor, err := reportCollection.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	reports := []Report{}
+	if err := cursor.All(ctx, &reports); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
@@ -0,0 +1,319 @@
+package main
+
+// Moderation review queue for messages held by filters or reports
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/logger"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// Held message review states.
+const (
+	HeldPending  = "pending"
+	HeldApproved = "approved"
+	HeldRejected = "rejected"
+)
+
+// Sources that can put a message into the review queue.
+const (
+	HeldSourceFilter = "filter"
+	HeldSourceReport = "report"
+)
+
+// moderationFeedChannel carries review queue events to every moderator feed.
+const moderationFeedChannel = "moderation:feed"
+
+// ErrHeldNotPending is returned when a held message was already reviewed or does not exist.
+var ErrHeldNotPending = errors.New("held message not found or already reviewed")
+
+// HeldMessage is a message waiting for a moderator decision.
+// MessageID is set when the message was already stored (e.g. hidden after reports);
+// otherwise the message is only stored and published once approved.
+type HeldMessage struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	RoomID     string              `bson:"room_id" json:"room_id"`
+	UserID     string              `bson:"user_id" json:"user_id"`
+	Content    string              `bson:"content" json:"content"`
+	Bot        bool                `bson:"bot,omitempty" json:"bot,omitempty"`
+	MessageID  *primitive.ObjectID `bson:"message_id,omitempty" json:"message_id,omitempty"`
+	Source     string              `bson:"source" json:"source"`
+	Filter     string              `bson:"filter,omitempty" json:"filter,omitempty"`
+	Reason     string              `bson:"reason" json:"reason"`
+	Status     string              `bson:"status" json:"status"`
+	CreatedAt  time.Time           `bson:"created_at" json:"created_at"`
+	ReviewedBy string              `bson:"reviewed_by,omitempty" json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time          `bson:"reviewed_at,omitempty" json:"reviewed_at,omitempty"`
+	Note       string              `bson:"note,omitempty" json:"note,omitempty"`
+}
+
+// ModerationEvent is pushed to moderator feeds when the review queue changes.
+type ModerationEvent struct {
+	Type string       `json:"type"`
+	Item *HeldMessage `json:"item"`
+}
+
+// Moderation feed event types.
+const (
+	EventHeldCreated  = "held_created"
+	EventHeldResolved = "held_resolved"
+)
+
+// HoldMessage queues a message for review and announces it to moderators.
+func (h *Hub) HoldMessage(ctx context.Context, held *HeldMessage) error {
+	held.Status = HeldPending
+	held.CreatedAt = time.Now()
+	if err := InsertHeldMessage(ctx, held); err != nil {
+		return err
+	}
+	h.publishModerationEvent(ctx, EventHeldCreated, held)
+	return nil
+}
+
+// ApproveHeldMessage releases a held message into its room through the normal publish path.
+func (h *Hub) ApproveHeldMessage(ctx context.Context, id primitive.ObjectID, moderatorID string) (*HeldMessage, error) {
+	held, err := ResolveHeldMessage(ctx, id, HeldApproved, moderatorID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if held.MessageID != nil {
+		// The message was already stored and hidden; approving restores it.
+		if err := SetMessageHidden(ctx, *held.MessageID, false, ""); err != nil {
+			return nil, err
+		}
+		h.publishRoomEvent(ctx, RoomEvent{Type: EventMessageRestored, RoomID: held.RoomID, MessageID: held.MessageID.Hex()})
+		h.closeReportsForHeld(ctx, held, ReportActionDismiss)
+	} else {
+		msg := &Message{
+			RoomID:    held.RoomID,
+			UserID:    held.UserID,
+			Content:   held.Content,
+			Bot:       held.Bot,
+			Timestamp: time.Now(),
+		}
+		if err := h.publishMessage(ctx, msg); err != nil {
+			// Put the entry back so another moderator can retry.
+			if reopenErr := ReopenHeldMessage(ctx, held.ID); reopenErr != nil {
+				logger.Error("Failed to reopen held message", zap.Error(reopenErr))
+			}
+			return nil, err
+		}
+	}
+
+	RecordModAction(ctx, ModAction{
+		Action:     ModActionHeldApproved,
+		ActorID:    moderatorID,
+		TargetType: ModTargetHeld,
+		TargetID:   held.ID.Hex(),
+		RoomID:     held.RoomID,
+		Details:    map[string]interface{}{"author_id": held.UserID, "source": held.Source},
+	})
+	h.publishModerationEvent(ctx, EventHeldResolved, held)
+	return held, nil
+}
+
+// RejectHeldMessage discards a held message and tells its author.
+func (h *Hub) RejectHeldMessage(ctx context.Context, id primitive.ObjectID, moderatorID, note string) (*HeldMessage, error) {
+	held, err := ResolveHeldMessage(ctx, id, HeldRejected, moderatorID, note)
+	if err != nil {
+		return nil, err
+	}
+
+	if held.MessageID != nil {
+		h.closeReportsForHeld(ctx, held, ReportActionHideMessage)
+	}
+
+	message := "your message was rejected by a moderator"
+	if note != "" {
+		message += ": " + note
+	}
+	frame := ErrorFrame{Type: FrameRejected, Code: ReasonRejectedByModerator, Message: message, ID: held.ID.Hex()}
+	if err := h.NotifyUser(ctx, held.UserID, frame); err != nil {
+		logger.Error("Failed to notify author of rejected message", zap.Error(err))
+	}
+
+	RecordModAction(ctx, ModAction{
+		Action:     ModActionHeldRejected,
+		ActorID:    moderatorID,
+		TargetType: ModTargetHeld,
+		TargetID:   held.ID.Hex(),
+		RoomID:     held.RoomID,
+		Reason:     note,
+		Details:    map[string]interface{}{"author_id": held.UserID, "source": held.Source},
+	})
+	h.publishModerationEvent(ctx, EventHeldResolved, held)
+	return held, nil
+}
+
+// closeReportsForHeld resolves the open report that put a message into the queue.
+func (h *Hub) closeReportsForHeld(ctx context.Context, held *HeldMessage, action string) {
+	_, err := CloseReport(ctx, bson.M{"held_id": held.ID}, held.ReviewedBy, action, held.Note)
+	if err != nil && !errors.Is(err, ErrReportNotFound) {
+		logger.Error("Failed to close report for held message", zap.Error(err))
+	}
+}
+
+func (h *Hub) publishModerationEvent(ctx context.Context, eventType string, held *HeldMessage) {
+	payload, err := json.Marshal(ModerationEvent{Type: eventType, Item: held})
+	if err != nil {
+		logger.Error("Failed to encode moderation event", zap.Error(err))
+		return
+	}
+	if err := h.redis.Publish(ctx, moderationFeedChannel, payload).Err(); err != nil {
+		logger.Error("Failed to publish moderation event", zap.Error(err))
+	}
+}
+
+// InsertHeldMessage stores a new review queue entry.
+func InsertHeldMessage(ctx context.Context, held *HeldMessage) error {
+	res, err := heldMessageCollection.InsertOne(ctx, held)
+	if err != nil {
+		return err
+	}
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		held.ID = id
+	}
+	return nil
+}
+
+// ResolveHeldMessage atomically moves a pending entry to its final status.
+func ResolveHeldMessage(ctx context.Context, id primitive.ObjectID, status, moderatorID, note string) (*HeldMessage, error) {
+	now := time.Now()
+	set := bson.M{
+		"status":      status,
+		"reviewed_by": moderatorID,
+		"reviewed_at": now,
+	}
+	if note != "" {
+		set["note"] = note
+	}
+
+	var held HeldMessage
+	err := heldMessageCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": id, "status": HeldPending},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&held)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrHeldNotPending
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &held, nil
+}
+
+// FindHeldMessageByID returns a queue entry, or ErrHeldNotPending when it does not exist.
+func FindHeldMessageByID(ctx context.Context, id primitive.ObjectID) (*HeldMessage, error) {
+	var held HeldMessage
+	err := heldMessageCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&held)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrHeldNotPending
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &held, nil
+}
+
+// ReopenHeldMessage returns a reviewed entry to the pending state.
+func ReopenHeldMessage(ctx context.Context, id primitive.ObjectID) error {
+	_, err := heldMessageCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$set":   bson.M{"status": HeldPending},
+			"$unset": bson.M{"reviewed_by": "", "reviewed_at": ""},
+		},
+	)
+	return err
+}
+
+// ListHeldMessages returns queue entries, oldest first, optionally filtered by room and status.
+func ListHeldMessages(ctx context.Context, roomID, status string, limit int64) ([]HeldMessage, error) {
+	filter := bson.M{}
+	if roomID != "" {
+		filter["room_id"] = roomID
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	cursor, err := heldMessageCollection.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	held := []HeldMessage{}
+	if err := cursor.All(ctx, &held); err != nil {
+		return nil, err
+	}
+	return held, nil
+}
+
+// serveModerationFeed streams review queue events to a moderator's WebSocket until it closes.
+// When roomID is set only events for that room are forwarded.
+func (h *Hub) serveModerationFeed(conn *websocket.Conn, roomID string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pubsub := h.redis.Subscribe(ctx, moderationFeedChannel)
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		pubsub.Close()
+		cancel()
+		conn.Close()
+	}()
+
+	// The feed is read-only; the reader only keeps deadlines fresh and notices disconnects.
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	events := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-events:
+			if !ok {
+				return
+			}
+			if roomID != "" {
+				var event ModerationEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil || event.Item == nil || event.Item.RoomID != roomID {
+					continue
+				}
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(msg.Payload)); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -4,10 +4,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
+	"github.com/celesteyang/ChatOrbit/shared/auth"
 	"github.com/celesteyang/ChatOrbit/shared/logger"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/websocket"
@@ -29,6 +31,10 @@ type client struct {
 	send   chan []byte
 	user   *UserClaims
 	roomID string
+	// session is the token the connection authenticated with.
+	session connSession
+	// kick carries a close reason from the Hub or the read loop to the write loop.
+	kick chan *websocket.CloseError
 }
 
 // Coordinates all client connections and handles message broadcasting.
@@ -37,8 +43,36 @@ type Hub struct {
 	broadcast  chan BroadcastMessage
 	register   chan *client
 	unregister chan *client
-	redis      *redis.Client
-	rooms      map[string]bool
+	direct     chan directMessage
+	notices    chan userNotice
+	// revoked carries revoked tokens announced by the auth service.
+	revoked chan auth.RevocationEvent
+	redis   *redis.Client
+
+	// verifier validates the tokens connections authenticate and reauthenticate with.
+	verifier *auth.Verifier
+	// revocations announces revoked tokens; nil disables closing revoked connections.
+	revocations *auth.Revocations
+	rooms       map[string]bool
+
+	// content validates and sanitizes message content before it is stored.
+	content *ContentPipeline
+	// maxFrameBytes is the read limit applied to every client connection.
+	maxFrameBytes int64
+	// filters moderates message content before it is stored.
+	filters *FilterChain
+	// reportHideThreshold is the number of reports that hides a message pending review.
+	reportHideThreshold int
+	// maxMute is the longest timeout a moderator may hand out.
+	maxMute time.Duration
+	// flood detects duplicate and raid spam on the send path.
+	flood *FloodDetector
+	// lockdown holds the lockdown defaults and automatic trigger limits.
+	lockdown LockdownConfig
+	// bots holds the default rate limit of bot API keys.
+	bots BotConfig
+	// guestsAllowedByDefault applies to rooms without their own guest access setting.
+	guestsAllowedByDefault bool
 
 	// roomsMu protects concurrent access to the rooms map when clients join
 	// new rooms outside of the Hub event loop (e.g., when switching rooms).
@@ -50,26 +84,117 @@ type BroadcastMessage struct {
 	Payload []byte
 }
 
+// directMessage is a payload addressed to a single local connection.
+type directMessage struct {
+	client  *client
+	payload []byte
+}
+
+// Types of frames sent to a single client.
+const (
+	FrameError    = "error"
+	FrameHeld     = "held"
+	FrameRejected = "rejected"
+	FrameWarning  = "warning"
+	FrameMuted    = "muted"
+
+	FrameReauthenticated = "reauthenticated"
+)
+
+// Types of events published to every client in a room about an existing message.
+const (
+	EventMessageHidden   = "message_hidden"
+	EventMessageRestored = "message_restored"
+	EventMessageDeleted  = "message_deleted"
+)
+
+// RoomEvent tells clients in a room that a message they may be showing changed.
+type RoomEvent struct {
+	Type      string `json:"type"`
+	RoomID    string `json:"room_id"`
+	MessageID string `json:"message_id"`
+}
+
+// ErrorFrame is sent to a single client when one of its frames is rejected or held.
+// ID refers to the held message, when there is one.
+type ErrorFrame struct {
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	ID      string `json:"id,omitempty"`
+	// RemainingSeconds tells a muted client how long the timeout still lasts.
+	RemainingSeconds int64 `json:"remaining_seconds,omitempty"`
+}
+
+// userNoticeChannel carries frames addressed to one user, wherever that user is connected.
+const userNoticeChannel = "chat_user_notices"
+
+// userNotice is a frame addressed to every connection of a user, or only to
+// the user's connections in one room when RoomID is set.
+type userNotice struct {
+	UserID  string          `json:"user_id"`
+	RoomID  string          `json:"room_id,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
 // Stores user information extracted from JWT.
 type UserClaims struct {
 	UserID string
 	Email  string
+	// AccountCreatedAt is zero when the token does not carry the account age.
+	AccountCreatedAt time.Time
+	Roles            auth.Roles
+	// DisplayName is set for guests, who have no profile to look up.
+	DisplayName string
+	// Bot is the API key grant of bot connections; nil for users.
+	Bot *auth.BotGrant
+}
+
+// newUserClaims copies the user information of validated token claims.
+func newUserClaims(claims *auth.Claims) *UserClaims {
+	return &UserClaims{
+		UserID:           claims.UserID,
+		Email:            claims.Email,
+		AccountCreatedAt: claims.AccountCreated(),
+		Roles:            claims.Roles,
+		DisplayName:      claims.Name,
+		Bot:              claims.Bot,
+	}
 }
 
 // Creates and returns a new Hub instance.
-func NewHub(redisClient *redis.Client) *Hub {
+func NewHub(redisClient *redis.Client, cfg Config, filters *FilterChain, verifier *auth.Verifier, revocations *auth.Revocations) *Hub {
 	return &Hub{
-		clients:    make(map[*client]bool),
-		broadcast:  make(chan BroadcastMessage),
-		register:   make(chan *client),
-		unregister: make(chan *client),
-		redis:      redisClient,
-		rooms:      make(map[string]bool),
+		clients:       make(map[*client]bool),
+		broadcast:     make(chan BroadcastMessage),
+		register:      make(chan *client),
+		unregister:    make(chan *client),
+		direct:        make(chan directMessage),
+		notices:       make(chan userNotice),
+		revoked:       make(chan auth.RevocationEvent),
+		redis:         redisClient,
+		rooms:         make(map[string]bool),
+		content:       NewContentPipeline(cfg.Validation),
+		maxFrameBytes: cfg.Validation.MaxFrameBytes,
+		filters:       filters,
+
+		reportHideThreshold:    cfg.ReportHideThreshold,
+		maxMute:                cfg.MaxMute,
+		flood:                  NewFloodDetector(redisClient, cfg.Flood),
+		lockdown:               cfg.Lockdown,
+		bots:                   cfg.Bots,
+		guestsAllowedByDefault: cfg.GuestsAllowed,
+		verifier:               verifier,
+		revocations:            revocations,
 	}
 }
 
 // Starts the main event loop for the Hub, listens for register, unregister, and broadcast events and handles them accordingly.
 func (h *Hub) Run() {
+	go h.subscribeToUserNotices()
+	if h.revocations != nil {
+		go h.subscribeToRevocations()
+	}
 	for {
 		select {
 		case client := <-h.register:
@@ -105,8 +230,98 @@ func (h *Hub) Run() {
 					delete(h.clients, client)
 				}
 			}
+		case message := <-h.direct:
+			// Only deliver to connections that are still registered; the send channel
+			// of an unregistered client has already been closed.
+			if _, ok := h.clients[message.client]; !ok {
+				continue
+			}
+			select {
+			case message.client.send <- message.payload:
+			default:
+				logger.Warn("Dropped direct message for slow client", zap.String("userID", message.client.user.UserID))
+			}
+		case notice := <-h.notices:
+			for client := range h.clients {
+				if client.user.UserID != notice.UserID {
+					continue
+				}
+				if notice.RoomID != "" && client.roomID != notice.RoomID {
+					continue
+				}
+				select {
+				case client.send <- notice.Payload:
+				default:
+					logger.Warn("Dropped user notice for slow client", zap.String("userID", notice.UserID))
+				}
+			}
+		case event := <-h.revoked:
+			h.disconnectRevoked(event)
+		}
+	}
+}
+
+// NotifyUser sends a frame to every connection of a user across all chat instances.
+func (h *Hub) NotifyUser(ctx context.Context, userID string, frame interface{}) error {
+	return h.notifyUserInRoom(ctx, userID, "", frame)
+}
+
+// notifyUserInRoom sends a frame to a user's connections in one room (all rooms when roomID is empty).
+func (h *Hub) notifyUserInRoom(ctx context.Context, userID, roomID string, frame interface{}) error {
+	payload, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	notice, err := json.Marshal(userNotice{UserID: userID, RoomID: roomID, Payload: payload})
+	if err != nil {
+		return err
+	}
+	return h.redis.Publish(ctx, userNoticeChannel, notice).Err()
+}
+
+// subscribeToUserNotices forwards user notices published by any instance to the Hub loop.
+func (h *Hub) subscribeToUserNotices() {
+	pubsub := h.redis.Subscribe(context.Background(), userNoticeChannel)
+	defer pubsub.Close()
+	for {
+		msg, err := pubsub.ReceiveMessage(context.Background())
+		if err != nil {
+			logger.Error("Error receiving user notice from Redis PubSub", zap.Error(err))
+			time.Sleep(time.Second)
+			continue
 		}
+		var notice userNotice
+		if err := json.Unmarshal([]byte(msg.Payload), &notice); err != nil {
+			logger.Error("Failed to decode user notice", zap.Error(err))
+			continue
+		}
+		h.notices <- notice
+	}
+}
+
+// sendError delivers a typed error frame to this connection only.
+func (c *client) sendError(code, message string) {
+	c.sendFrame(FrameError, code, message)
+}
+
+// sendFrame delivers a typed status frame to this connection only.
+func (c *client) sendFrame(frameType, code, message string) {
+	c.sendFrameWithID(frameType, code, message, "")
+}
+
+// sendFrameWithID delivers a typed status frame that refers to a held message.
+func (c *client) sendFrameWithID(frameType, code, message, id string) {
+	c.sendErrorFrame(ErrorFrame{Type: frameType, Code: code, Message: message, ID: id})
+}
+
+// sendErrorFrame delivers a fully populated status frame to this connection only.
+func (c *client) sendErrorFrame(frame ErrorFrame) {
+	payload, err := json.Marshal(frame)
+	if err != nil {
+		logger.Error("Failed to encode frame", zap.Error(err))
+		return
 	}
+	c.hub.direct <- directMessage{client: c, payload: payload}
 }
 
 // Subscribes to a Redis Pub/Sub channel for a specific chat room, when there are new messages, forward them to Hub's broadcast channel.
@@ -148,6 +363,10 @@ func HandleClientMessages(c *client) {
 
 	// Set initial read deadline so connections that stop responding to heartbeats are cleaned up.
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	// Oversized frames close the connection with a "message too big" close code.
+	if c.hub.maxFrameBytes > 0 {
+		c.conn.SetReadLimit(c.hub.maxFrameBytes)
+	}
 
 	for {
 		_, message, err := c.conn.ReadMessage()
@@ -158,41 +377,260 @@ func HandleClientMessages(c *client) {
 			break
 		}
 
-		var incomingMessage Message
-		if err := json.Unmarshal(message, &incomingMessage); err != nil {
-			logger.Error("Failed to parse incoming message", zap.Error(err))
-			continue
-		}
+		c.handleIncoming(message)
+	}
+}
 
-		incomingMessage.UserID = c.user.UserID
-		targetRoom := incomingMessage.RoomID
-		if targetRoom == "" {
-			targetRoom = c.roomID
+// handleIncoming runs a single client frame through validation and moderation,
+// then stores and publishes it.
+func (c *client) handleIncoming(raw []byte) {
+	ctx := context.Background()
+
+	if verr := validFrameEncoding(raw); verr != nil {
+		c.sendError(verr.Code, verr.Message)
+		return
+	}
+
+	var reauth reauthFrame
+	if err := json.Unmarshal(raw, &reauth); err == nil && reauth.Type == FrameReauth {
+		c.reauthenticate(ctx, reauth.Token)
+		return
+	}
+
+	// Guests only watch; they can reauthenticate but not send or switch rooms.
+	if isGuest(c.user) {
+		c.sendError(ReasonGuestReadOnly, "guests cannot send messages, log in to chat")
+		return
+	}
+	if c.user.Bot != nil && !c.user.Bot.HasScope(auth.ScopePost) {
+		c.sendError(ReasonBotNotAllowed, "this api key cannot post messages")
+		return
+	}
+
+	var incomingMessage Message
+	if err := json.Unmarshal(raw, &incomingMessage); err != nil {
+		logger.Error("Failed to parse incoming message", zap.Error(err))
+		c.sendError(ReasonInvalidFrame, "message must be a JSON object")
+		return
+	}
+
+	content, err := c.hub.content.Process(incomingMessage.Content)
+	if err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			c.sendError(verr.Code, verr.Message)
+		} else {
+			logger.Error("Failed to validate message content", zap.Error(err))
 		}
-		if targetRoom != c.roomID {
-			if err := c.hub.switchClientRoom(context.Background(), c, targetRoom); err != nil {
-				logger.Error("Failed to switch client room", zap.Error(err))
-				targetRoom = c.roomID
+		return
+	}
+	incomingMessage.Content = content
+
+	incomingMessage.UserID = c.user.UserID
+	incomingMessage.Bot = c.user.Bot != nil
+	incomingMessage.Hidden = false
+	incomingMessage.HiddenReason = ""
+	targetRoom := incomingMessage.RoomID
+	if targetRoom == "" {
+		targetRoom = c.roomID
+	}
+	if targetRoom != c.roomID {
+		if err := c.hub.switchClientRoom(ctx, c, targetRoom); err != nil {
+			if errors.Is(err, ErrRoomLocked) {
+				c.sendError(ReasonRoomLocked, "the room is locked down")
+				return
+			}
+			if errors.Is(err, ErrBotNotAllowed) {
+				c.sendError(ReasonBotNotAllowed, "this api key does not allow that room")
+				return
 			}
+			logger.Error("Failed to switch client room", zap.Error(err))
+			targetRoom = c.roomID
 		}
-		incomingMessage.RoomID = targetRoom
-		incomingMessage.Timestamp = time.Now()
+	}
+	incomingMessage.RoomID = targetRoom
+	incomingMessage.Timestamp = time.Now()
 
-		// Save the message to the database by calling the model layer function.
-		if err := InsertMessage(context.Background(), &incomingMessage); err != nil {
-			logger.Error("Failed to insert message into DB", zap.Error(err))
-			continue
+	if err := c.hub.checkMembersOnly(ctx, incomingMessage.RoomID, c.user); err != nil {
+		if errors.Is(err, ErrRoomLocked) {
+			c.sendError(ReasonRoomLocked, "the room is locked down to established members")
+			return
+		}
+		logger.Error("Failed to check room lockdown", zap.Error(err))
+		c.sendError(ReasonModerationUnavailable, "message could not be checked, try again")
+		return
+	}
+
+	// Muted users stay connected and keep receiving messages, but cannot send.
+	mute, err := c.hub.ActiveMute(ctx, c.user.UserID, incomingMessage.RoomID)
+	if err != nil {
+		logger.Error("Failed to check mute state", zap.Error(err))
+		c.sendError(ReasonModerationUnavailable, "message could not be checked, try again")
+		return
+	}
+	if mute != nil {
+		c.sendErrorFrame(ErrorFrame{
+			Type:             FrameError,
+			Code:             ReasonMuted,
+			Message:          "you are timed out",
+			RemainingSeconds: mute.RemainingSeconds,
+		})
+		return
+	}
+
+	// Bots are held to their API key's rate limit instead of slow mode and flood detection.
+	if c.user.Bot != nil {
+		wait, err := c.hub.takeBotSlot(ctx, c.user.Bot)
+		if err != nil {
+			logger.Error("Failed to check bot rate limit", zap.Error(err))
+			c.sendError(ReasonModerationUnavailable, "message could not be checked, try again")
+			return
 		}
+		if wait > 0 {
+			c.sendErrorFrame(ErrorFrame{
+				Type:             FrameError,
+				Code:             ReasonBotRateLimited,
+				Message:          "the api key's rate limit is reached",
+				RemainingSeconds: wait,
+			})
+			return
+		}
+	} else if !c.checkUserLimits(ctx, &incomingMessage) {
+		return
+	}
 
-		// Publish the message to Redis.
-		msgJSON, _ := json.Marshal(incomingMessage)
-		if err := c.hub.redis.Publish(context.Background(), "chat_room:"+incomingMessage.RoomID, msgJSON).Err(); err != nil {
-			logger.Error("Failed to publish message to Redis", zap.Error(err))
+	verdict, err := c.hub.filters.Run(ctx, incomingMessage.RoomID, incomingMessage.Content)
+	if err != nil {
+		// Fail closed: a message that could not be moderated is not delivered.
+		logger.Error("Failed to run moderation filters", zap.Error(err))
+		c.sendError(ReasonModerationUnavailable, "message could not be checked, try again")
+		return
+	}
+	switch verdict.Action {
+	case ActionReject:
+		logger.Info("Message rejected by filter",
+			zap.String("userID", c.user.UserID),
+			zap.String("roomID", incomingMessage.RoomID),
+			zap.String("filter", verdict.Filter),
+		)
+		c.sendError(ReasonMessageRejected, verdict.Reason)
+		return
+	case ActionHold:
+		logger.Info("Message held by filter",
+			zap.String("userID", c.user.UserID),
+			zap.String("roomID", incomingMessage.RoomID),
+			zap.String("filter", verdict.Filter),
+		)
+		held := &HeldMessage{
+			RoomID:  incomingMessage.RoomID,
+			UserID:  incomingMessage.UserID,
+			Content: verdict.Content,
+			Bot:     incomingMessage.Bot,
+			Source:  HeldSourceFilter,
+			Filter:  verdict.Filter,
+			Reason:  verdict.Reason,
 		}
+		if err := c.hub.HoldMessage(ctx, held); err != nil {
+			logger.Error("Failed to queue held message", zap.Error(err))
+			c.sendError(ReasonModerationUnavailable, "message could not be checked, try again")
+			return
+		}
+		c.sendFrameWithID(FrameHeld, ReasonHeldForReview, "message is held for moderator review", held.ID.Hex())
+		return
+	}
+	incomingMessage.Content = verdict.Content
+
+	shadowBanned, err := c.hub.IsShadowBanned(ctx, c.user.UserID)
+	if err != nil {
+		logger.Error("Failed to check shadow ban", zap.Error(err))
+		c.sendError(ReasonModerationUnavailable, "message could not be checked, try again")
+		return
+	}
+	if shadowBanned {
+		if err := c.hub.publishShadowMessage(ctx, &incomingMessage); err != nil {
+			logger.Error("Failed to publish shadow-banned message", zap.Error(err))
+		}
+		return
+	}
+
+	if err := c.hub.publishMessage(ctx, &incomingMessage); err != nil {
+		logger.Error("Failed to publish message", zap.Error(err))
 	}
 }
 
+// checkUserLimits applies slow mode and flood detection to a user's message and
+// reports whether it may go through.
+func (c *client) checkUserLimits(ctx context.Context, msg *Message) bool {
+	wait, err := c.hub.takeSlowModeSlot(ctx, msg.RoomID, c.user.UserID)
+	if err != nil {
+		logger.Error("Failed to check slow mode", zap.Error(err))
+		c.sendError(ReasonModerationUnavailable, "message could not be checked, try again")
+		return false
+	}
+	if wait > 0 {
+		c.sendErrorFrame(ErrorFrame{
+			Type:             FrameError,
+			Code:             ReasonSlowMode,
+			Message:          "the room is in slow mode",
+			RemainingSeconds: wait,
+		})
+		return false
+	}
+
+	flood, err := c.hub.flood.Check(ctx, msg.RoomID, c.user.UserID, msg.Content)
+	if err != nil {
+		logger.Error("Failed to check for flooding", zap.Error(err))
+		c.sendError(ReasonModerationUnavailable, "message could not be checked, try again")
+		return false
+	}
+	if flood != nil {
+		logger.Info("Message flood detected",
+			zap.String("userID", c.user.UserID),
+			zap.String("roomID", msg.RoomID),
+			zap.String("kind", flood.Kind),
+			zap.String("action", string(flood.Action)),
+			zap.Int64("count", flood.Count),
+		)
+		code, message, err := c.hub.applyFloodAction(ctx, msg.RoomID, c.user.UserID, flood)
+		if err != nil {
+			logger.Error("Failed to apply flood action", zap.Error(err))
+			code, message = ReasonModerationUnavailable, "message could not be checked, try again"
+		}
+		c.sendError(code, message)
+		return false
+	}
+	return true
+}
+
+// publishRoomEvent notifies every client in a room, on all instances, about a changed message.
+func (h *Hub) publishRoomEvent(ctx context.Context, event RoomEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("Failed to encode room event", zap.Error(err))
+		return
+	}
+	if err := h.redis.Publish(ctx, "chat_room:"+event.RoomID, payload).Err(); err != nil {
+		logger.Error("Failed to publish room event", zap.Error(err))
+	}
+}
+
+// publishMessage stores a message and publishes it to its room on every instance.
+func (h *Hub) publishMessage(ctx context.Context, msg *Message) error {
+	// Save the message to the database by calling the model layer function.
+	if err := InsertMessage(ctx, msg); err != nil {
+		return fmt.Errorf("insert message: %w", err)
+	}
+
+	// Publish the message to Redis.
+	msgJSON, _ := json.Marshal(msg)
+	if err := h.redis.Publish(ctx, "chat_room:"+msg.RoomID, msgJSON).Err(); err != nil {
+		return fmt.Errorf("publish message: %w", err)
+	}
+	return nil
+}
+
 // Write messages from the Hub to the WebSocket connection.
+// The connection is closed with a token close code once its token expires or is revoked.
 func HandleClientWrites(c *client) {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
@@ -213,7 +651,14 @@ func HandleClientWrites(c *client) {
 			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
+		case reason := <-c.kick:
+			c.writeClose(reason)
+			return
 		case <-ticker.C:
+			if reason := c.checkSession(time.Now()); reason != nil {
+				c.writeClose(reason)
+				return
+			}
 			if err := c.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
 				return
 			}
@@ -224,6 +669,12 @@ func HandleClientWrites(c *client) {
 	}
 }
 
+// writeClose sends a close frame with the given code before the connection is closed.
+func (c *client) writeClose(reason *websocket.CloseError) {
+	deadline := time.Now().Add(writeWait)
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(reason.Code, reason.Text), deadline)
+}
+
 // Reverse the order to get oldest first (for UI display)
 func ReverseMessages(messages []Message) {
 	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
@@ -237,6 +688,10 @@ func (h *Hub) switchClientRoom(ctx context.Context, c *client, newRoomID string)
 		return nil
 	}
 
+	if err := h.admitToRoom(ctx, newRoomID, c.user); err != nil {
+		return err
+	}
+
 	if err := EnsureRoomExists(ctx, newRoomID); err != nil {
 		return err
 	}
@@ -0,0 +1,160 @@
+package main
+
+// Token lifetime of live WebSocket connections: expiry, revocation and reauth
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/auth"
+	"github.com/celesteyang/ChatOrbit/shared/logger"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// Close codes sent when the server ends a connection because of its token.
+const (
+	CloseTokenExpired = 4001
+	CloseTokenRevoked = 4002
+)
+
+// FrameReauth is the type of the client frame that replaces the connection's token.
+const FrameReauth = "reauth"
+
+// reauthWarning is how long before expiry a connection is warned to reauthenticate.
+const reauthWarning = time.Minute
+
+// reauthFrame is sent by a client to keep its connection open with a fresh token.
+type reauthFrame struct {
+	Type  string `json:"type"`
+	Token string `json:"token"`
+}
+
+// tokenSession is the token a connection is currently authenticated with.
+type tokenSession struct {
+	ID string
+	// SessionID is the login session the token belongs to, revoked as a whole from the auth service.
+	SessionID string
+	Version   int64
+	ExpiresAt time.Time
+	warned    bool
+}
+
+// connSession guards the token session of one connection; the read loop replaces
+// it on reauth while the write loop and the Hub check it.
+type connSession struct {
+	mu      sync.Mutex
+	current tokenSession
+}
+
+func (s *connSession) get() tokenSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+func (s *connSession) set(session tokenSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current = session
+}
+
+// sessionFromClaims reads the token ID, session, version and expiry from validated claims.
+func sessionFromClaims(claims *auth.Claims) tokenSession {
+	session := tokenSession{ID: claims.ID, SessionID: claims.SessionID, Version: claims.Version}
+	if claims.ExpiresAt != nil {
+		session.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return session
+}
+
+// disconnect asks the write loop to close the connection with a close code.
+func (c *client) disconnect(code int, text string) {
+	select {
+	case c.kick <- &websocket.CloseError{Code: code, Text: text}:
+	default:
+	}
+}
+
+// checkSession returns the close reason once the connection's token has expired,
+// and warns the client shortly before that so it can reauthenticate.
+func (c *client) checkSession(now time.Time) *websocket.CloseError {
+	c.session.mu.Lock()
+	session := &c.session.current
+	if session.ExpiresAt.IsZero() {
+		c.session.mu.Unlock()
+		return nil
+	}
+	remaining := session.ExpiresAt.Sub(now)
+	if remaining <= 0 {
+		c.session.mu.Unlock()
+		return &websocket.CloseError{Code: CloseTokenExpired, Text: ReasonTokenExpired}
+	}
+	warn := remaining <= reauthWarning && !session.warned
+	session.warned = session.warned || warn
+	c.session.mu.Unlock()
+
+	if warn {
+		c.sendErrorFrame(ErrorFrame{
+			Type:             FrameWarning,
+			Code:             ReasonTokenExpiring,
+			Message:          "your session is about to expire, send a reauth frame with a fresh token",
+			RemainingSeconds: int64(remaining.Round(time.Second) / time.Second),
+		})
+	}
+	return nil
+}
+
+// reauthenticate replaces the connection's token with a fresh one for the same user.
+// The new token must be admitted to the connection's room like a new connection,
+// since its roles, guest status or API key may differ from the old one.
+// A rejected token leaves the current session in place.
+func (c *client) reauthenticate(ctx context.Context, tokenString string) {
+	claims, err := c.hub.verifier.Verify(ctx, tokenString)
+	if err != nil {
+		c.sendError(ReasonReauthFailed, "token is invalid, expired or revoked")
+		return
+	}
+	if claims.UserID != c.user.UserID {
+		c.sendError(ReasonReauthFailed, "token belongs to a different user")
+		return
+	}
+	user := newUserClaims(claims)
+	if err := c.hub.readmitToRoom(ctx, c.roomID, user); err != nil {
+		if !errors.Is(err, ErrRoomLocked) && !errors.Is(err, ErrGuestsNotAllowed) && !errors.Is(err, ErrBotNotAllowed) {
+			logger.Error("Failed to check room admission on reauth", zap.Error(err))
+		}
+		c.sendError(ReasonReauthFailed, "token is not allowed in this room")
+		return
+	}
+
+	session := sessionFromClaims(claims)
+	c.session.set(session)
+	*c.user = *user
+
+	frame := ErrorFrame{Type: FrameReauthenticated, Code: ReasonTokenRefreshed, Message: "session extended"}
+	if !session.ExpiresAt.IsZero() {
+		frame.RemainingSeconds = int64(time.Until(session.ExpiresAt).Round(time.Second) / time.Second)
+	}
+	c.sendErrorFrame(frame)
+}
+
+// subscribeToRevocations forwards revocations announced by the auth service to the Hub loop.
+func (h *Hub) subscribeToRevocations() {
+	for event := range h.revocations.Subscribe(context.Background()) {
+		h.revoked <- event
+	}
+	logger.Error("Revocation subscription ended")
+}
+
+// disconnectRevoked closes every local connection whose token the event revokes.
+func (h *Hub) disconnectRevoked(event auth.RevocationEvent) {
+	for client := range h.clients {
+		session := client.session.get()
+		if event.Revokes(session.ID, session.SessionID, client.user.UserID, session.Version) {
+			logger.Info("Closing connection with revoked token", zap.String("userID", client.user.UserID))
+			client.disconnect(CloseTokenRevoked, ReasonTokenRevoked)
+		}
+	}
+}
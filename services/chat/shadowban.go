@@ -0,0 +1,95 @@
+package main
+
+// Shadow bans: a banned user's messages are only echoed back to that user
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// shadowBanKey is a Redis hash of shadow-banned user IDs to ban details.
+const shadowBanKey = "moderation:shadowbans"
+
+// ShadowBan records who shadow-banned a user and why.
+type ShadowBan struct {
+	UserID    string    `json:"user_id"`
+	BannedBy  string    `json:"banned_by"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ShadowBanUser flags a user so their messages never reach anyone else.
+func (h *Hub) ShadowBanUser(ctx context.Context, userID, moderatorID, reason string) (*ShadowBan, error) {
+	ban := &ShadowBan{UserID: userID, BannedBy: moderatorID, Reason: reason, CreatedAt: time.Now()}
+	data, err := json.Marshal(ban)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.redis.HSet(ctx, shadowBanKey, userID, data).Err(); err != nil {
+		return nil, err
+	}
+
+	RecordModAction(ctx, ModAction{
+		Action:     ModActionUserShadowBanned,
+		ActorID:    moderatorID,
+		TargetType: ModTargetUser,
+		TargetID:   userID,
+		Reason:     reason,
+	})
+	return ban, nil
+}
+
+// LiftShadowBan clears the flag. It reports whether the user was shadow-banned.
+func (h *Hub) LiftShadowBan(ctx context.Context, userID, moderatorID string) (bool, error) {
+	n, err := h.redis.HDel(ctx, shadowBanKey, userID).Result()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	RecordModAction(ctx, ModAction{
+		Action:     ModActionUserShadowUnbanned,
+		ActorID:    moderatorID,
+		TargetType: ModTargetUser,
+		TargetID:   userID,
+	})
+	return true, nil
+}
+
+// IsShadowBanned reports whether a user is shadow-banned.
+func (h *Hub) IsShadowBanned(ctx context.Context, userID string) (bool, error) {
+	return h.redis.HExists(ctx, shadowBanKey, userID).Result()
+}
+
+// ListShadowBans returns every shadow-banned user.
+func (h *Hub) ListShadowBans(ctx context.Context) ([]ShadowBan, error) {
+	entries, err := h.redis.HGetAll(ctx, shadowBanKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	bans := make([]ShadowBan, 0, len(entries))
+	for userID, data := range entries {
+		var ban ShadowBan
+		if err := json.Unmarshal([]byte(data), &ban); err != nil {
+			ban = ShadowBan{UserID: userID}
+		}
+		bans = append(bans, ban)
+	}
+	return bans, nil
+}
+
+// publishShadowMessage stores a shadow-banned user's message as hidden and echoes it
+// only to that user's own connections in the room, on every instance.
+func (h *Hub) publishShadowMessage(ctx context.Context, msg *Message) error {
+	msg.Hidden = true
+	msg.HiddenReason = HiddenByShadowBan
+	if err := InsertMessage(ctx, msg); err != nil {
+		return fmt.Errorf("insert message: %w", err)
+	}
+
+	// The echo must look exactly like a normal message so the ban stays invisible.
+	echo := *msg
+	echo.Hidden = false
+	echo.HiddenReason = ""
+	return h.notifyUserInRoom(ctx, msg.UserID, msg.RoomID, echo)
+}
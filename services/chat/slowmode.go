@@ -0,0 +1,119 @@
+package main
+
+// Slow mode: a per-room minimum interval between messages from the same user
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrInvalidSlowMode is returned for slow mode settings outside the allowed range.
+var ErrInvalidSlowMode = errors.New("invalid slow mode")
+
+// maxSlowModeInterval caps the interval between messages in slow mode.
+const maxSlowModeInterval = time.Hour
+
+// SlowMode describes a room's active slow mode. RemainingSeconds is 0 when it has no expiry.
+type SlowMode struct {
+	RoomID           string `json:"room_id"`
+	IntervalSeconds  int64  `json:"interval_seconds"`
+	RemainingSeconds int64  `json:"remaining_seconds,omitempty"`
+}
+
+func slowModeKey(roomID string) string {
+	return "slowmode:room:" + roomID
+}
+
+func slowModeUserKey(roomID, userID string) string {
+	return "slowmode:room:" + roomID + ":user:" + userID
+}
+
+// SetSlowMode limits every user in a room to one message per interval, for the given
+// duration (0 keeps it on until lifted). actorID and reason go to the audit log.
+func (h *Hub) SetSlowMode(ctx context.Context, roomID string, interval, duration time.Duration, actorID, reason string) (*SlowMode, error) {
+	if roomID == "" || interval < time.Second || interval > maxSlowModeInterval || duration < 0 {
+		return nil, ErrInvalidSlowMode
+	}
+	seconds := int64(interval / time.Second)
+	if err := h.redis.Set(ctx, slowModeKey(roomID), seconds, duration).Err(); err != nil {
+		return nil, err
+	}
+
+	RecordModAction(ctx, ModAction{
+		Action:     ModActionSlowModeEnabled,
+		ActorID:    actorID,
+		TargetType: ModTargetRoom,
+		TargetID:   roomID,
+		RoomID:     roomID,
+		Reason:     reason,
+		Details:    map[string]interface{}{"interval_seconds": seconds, "duration_seconds": int64(duration / time.Second)},
+	})
+	return &SlowMode{RoomID: roomID, IntervalSeconds: seconds, RemainingSeconds: int64(duration / time.Second)}, nil
+}
+
+// ClearSlowMode turns slow mode off. It reports whether it was on.
+func (h *Hub) ClearSlowMode(ctx context.Context, roomID, actorID string) (bool, error) {
+	n, err := h.redis.Del(ctx, slowModeKey(roomID)).Result()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	RecordModAction(ctx, ModAction{
+		Action:     ModActionSlowModeDisabled,
+		ActorID:    actorID,
+		TargetType: ModTargetRoom,
+		TargetID:   roomID,
+		RoomID:     roomID,
+	})
+	return true, nil
+}
+
+// RoomSlowMode returns the room's active slow mode, or nil.
+func (h *Hub) RoomSlowMode(ctx context.Context, roomID string) (*SlowMode, error) {
+	pipe := h.redis.Pipeline()
+	value := pipe.Get(ctx, slowModeKey(roomID))
+	ttl := pipe.PTTL(ctx, slowModeKey(roomID))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	if errors.Is(value.Err(), redis.Nil) {
+		return nil, nil
+	}
+
+	seconds, err := strconv.ParseInt(value.Val(), 10, 64)
+	if err != nil || seconds <= 0 {
+		return nil, nil
+	}
+	mode := &SlowMode{RoomID: roomID, IntervalSeconds: seconds}
+	if remaining := ttl.Val(); remaining > 0 {
+		mode.RemainingSeconds = ceilSeconds(remaining)
+	}
+	return mode, nil
+}
+
+// takeSlowModeSlot claims the user's next send in a slow-mode room. It returns how many
+// seconds the user still has to wait, or 0 when the message may go through.
+func (h *Hub) takeSlowModeSlot(ctx context.Context, roomID, userID string) (int64, error) {
+	mode, err := h.RoomSlowMode(ctx, roomID)
+	if err != nil || mode == nil {
+		return 0, err
+	}
+
+	key := slowModeUserKey(roomID, userID)
+	ok, err := h.redis.SetNX(ctx, key, 1, time.Duration(mode.IntervalSeconds)*time.Second).Result()
+	if err != nil || ok {
+		return 0, err
+	}
+	wait, err := h.redis.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if wait <= 0 {
+		// The previous slot expired between the two calls.
+		return 0, nil
+	}
+	return ceilSeconds(wait), nil
+}
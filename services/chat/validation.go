@@ -0,0 +1,142 @@
+package main
+
+// Content validation and sanitization pipeline for incoming chat messages
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Reason codes reported to clients when a message is rejected.
+const (
+	ReasonInvalidFrame    = "invalid_frame"
+	ReasonInvalidEncoding = "invalid_encoding"
+	ReasonEmptyMessage    = "empty_message"
+	ReasonMessageTooLong  = "message_too_long"
+
+	ReasonMessageRejected       = "message_rejected"
+	ReasonHeldForReview         = "held_for_review"
+	ReasonRejectedByModerator   = "rejected_by_moderator"
+	ReasonModeratorWarning      = "moderator_warning"
+	ReasonMuted                 = "muted"
+	ReasonModerationUnavailable = "moderation_unavailable"
+	ReasonDuplicateMessage      = "duplicate_message"
+	ReasonFloodDetected         = "flood_detected"
+	ReasonSlowMode              = "slow_mode"
+	ReasonRoomLocked            = "room_locked"
+	ReasonGuestsNotAllowed      = "guests_not_allowed"
+	ReasonGuestReadOnly         = "guest_read_only"
+	ReasonBotNotAllowed         = "bot_not_allowed"
+	ReasonBotRateLimited        = "bot_rate_limited"
+
+	ReasonTokenExpiring  = "token_expiring"
+	ReasonTokenExpired   = "token_expired"
+	ReasonTokenRevoked   = "token_revoked"
+	ReasonTokenRefreshed = "token_refreshed"
+	ReasonReauthFailed   = "reauth_failed"
+)
+
+// ValidationError describes why a message was rejected by the content pipeline.
+type ValidationError struct {
+	Code    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// contentStep transforms message content or rejects it with a ValidationError.
+type contentStep func(content string) (string, error)
+
+// ContentPipeline runs the configured validation and sanitization steps in order.
+type ContentPipeline struct {
+	steps []contentStep
+}
+
+var htmlTagPattern = regexp.MustCompile(`</?[a-zA-Z][^<>]*>`)
+
+// NewContentPipeline builds the pipeline from the validation configuration.
+// Trimming, emptiness and length checks always run last so they see the content that
+// will actually be stored. Encoding is checked on the raw frame, see validFrameEncoding.
+func NewContentPipeline(cfg ValidationConfig) *ContentPipeline {
+	p := &ContentPipeline{}
+	if cfg.NormalizeUnicode {
+		p.steps = append(p.steps, normalizeUnicode)
+	}
+	if cfg.StripControl {
+		p.steps = append(p.steps, stripControlChars)
+	}
+	if cfg.StripHTML {
+		p.steps = append(p.steps, stripHTMLTags)
+	}
+	p.steps = append(p.steps, trimSpace, rejectEmpty)
+	if cfg.MaxRunes > 0 {
+		p.steps = append(p.steps, limitRunes(cfg.MaxRunes))
+	}
+	return p
+}
+
+// Process runs content through every step and returns the sanitized result.
+func (p *ContentPipeline) Process(content string) (string, error) {
+	var err error
+	for _, step := range p.steps {
+		if content, err = step(content); err != nil {
+			return "", err
+		}
+	}
+	return content, nil
+}
+
+// validFrameEncoding rejects frames that are not valid UTF-8. It must run on the raw
+// frame: json.Unmarshal silently replaces invalid bytes with U+FFFD.
+func validFrameEncoding(raw []byte) *ValidationError {
+	if !utf8.Valid(raw) {
+		return &ValidationError{Code: ReasonInvalidEncoding, Message: "message is not valid UTF-8"}
+	}
+	return nil
+}
+
+func normalizeUnicode(content string) (string, error) {
+	return norm.NFC.String(content), nil
+}
+
+// stripControlChars drops control and invisible format characters but keeps newlines and tabs.
+func stripControlChars(content string) (string, error) {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, content), nil
+}
+
+func stripHTMLTags(content string) (string, error) {
+	return htmlTagPattern.ReplaceAllString(content, ""), nil
+}
+
+func trimSpace(content string) (string, error) {
+	return strings.TrimSpace(content), nil
+}
+
+func rejectEmpty(content string) (string, error) {
+	if content == "" {
+		return "", &ValidationError{Code: ReasonEmptyMessage, Message: "message content is empty"}
+	}
+	return content, nil
+}
+
+func limitRunes(maxRunes int) contentStep {
+	return func(content string) (string, error) {
+		if utf8.RuneCountInString(content) > maxRunes {
+			return "", &ValidationError{Code: ReasonMessageTooLong, Message: "message exceeds the maximum length"}
+		}
+		return content, nil
+	}
+}
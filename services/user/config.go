@@ -1,3 +1,42 @@
 package main
 
 // Local config loading for user service
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/auth"
+)
+
+// Config holds the tunable settings of the user service, loaded from the environment.
+type Config struct {
+	// JWKSCache is how long the auth service's public keys are cached.
+	JWKSCache time.Duration
+	// Verifier lists the algorithms, issuer, audience and leeway required of access tokens.
+	Verifier auth.VerifierConfig
+	// PrivacyPollInterval is how often the privacy job queue is checked for new tasks.
+	PrivacyPollInterval time.Duration
+}
+
+// LoadConfig reads the user service configuration from environment variables.
+func LoadConfig() Config {
+	return Config{
+		JWKSCache:           time.Duration(getEnvInt("JWKS_CACHE_SECONDS", 300)) * time.Second,
+		Verifier:            auth.VerifierConfigFromEnv(auth.AudienceUser),
+		PrivacyPollInterval: time.Duration(getEnvInt("PRIVACY_POLL_SECONDS", 5)) * time.Second,
+	}
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return n
+}
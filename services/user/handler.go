@@ -4,17 +4,21 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/celesteyang/ChatOrbit/shared/auth"
+	"github.com/celesteyang/ChatOrbit/shared/logger"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
 )
 
 // GetUserHandler godoc
 // @Summary      Get user info
-// @Description  Returns user profile by user ID
+// @Description  Returns user profile by user ID. Requires a valid access token.
 // @Tags         User
 // @Produce      json
 // @Param        id   path      string  true  "User ID"
 // @Success      200  {object}  User
+// @Failure      401  {object}  map[string]string
 // @Failure      404  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /user/{id} [get]
@@ -31,3 +35,122 @@ func GetUserHandler(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, user)
 }
+
+// UserRoles is the role assignment of one user.
+type UserRoles struct {
+	UserID string   `json:"user_id"`
+	Roles  []string `json:"roles" binding:"required"`
+}
+
+// GetUserRolesHandler godoc
+// @Summary      Get user roles
+// @Description  Returns a user's global and room-scoped roles. Requires the admin or staff role.
+// @Tags         Roles
+// @Produce      json
+// @Param        id   path      string  true  "User ID"
+// @Success      200  {object}  UserRoles
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /user/{id}/roles [get]
+func GetUserRolesHandler(c *gin.Context) {
+	id := c.Param("id")
+	user, err := GetUserByID(c.Request.Context(), id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		}
+		return
+	}
+	roles := user.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+	c.JSON(http.StatusOK, UserRoles{UserID: id, Roles: roles})
+}
+
+// UpdateUserRolesHandler godoc
+// @Summary      Replace user roles
+// @Description  Replaces a user's roles. Global roles are admin, staff and moderator; room-scoped roles are written
+// @Description  "room:<roomID>:moderator". Staff may change room-scoped roles; only admins may change global roles.
+// @Description  The new roles apply from the user's next login.
+// @Tags         Roles
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string     true  "User ID"
+// @Param        request  body      UserRoles  true  "Roles"
+// @Success      200      {object}  UserRoles
+// @Failure      400      {object}  map[string]string
+// @Failure      403      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Router       /user/{id}/roles [put]
+func UpdateUserRolesHandler(c *gin.Context) {
+	id := c.Param("id")
+	var req UserRoles
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "roles is required"})
+		return
+	}
+	roles := make([]string, 0, len(req.Roles))
+	seen := make(map[string]bool)
+	for _, role := range req.Roles {
+		if !auth.ValidRole(role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role: " + role})
+			return
+		}
+		if !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+
+	user, err := GetUserByID(c.Request.Context(), id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		}
+		return
+	}
+	if !auth.RolesFrom(c).Has(auth.RoleAdmin) && !sameGlobalRoles(user.Roles, roles) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only admins may change global roles"})
+		return
+	}
+
+	if err := SetUserRoles(c.Request.Context(), id, roles); err != nil {
+		logger.Error("Failed to update user roles", zap.String("user_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	logger.Info("User roles updated",
+		zap.String("user_id", id),
+		zap.Strings("roles", roles),
+		zap.String("by", c.GetString("user_id")),
+	)
+	c.JSON(http.StatusOK, UserRoles{UserID: id, Roles: roles})
+}
+
+// sameGlobalRoles reports whether two role lists grant the same global roles.
+func sameGlobalRoles(a, b []string) bool {
+	global := func(roles []string) map[string]bool {
+		set := make(map[string]bool)
+		for _, role := range roles {
+			if _, _, scoped := auth.ParseRoomRole(role); !scoped {
+				set[role] = true
+			}
+		}
+		return set
+	}
+	ga, gb := global(a), global(b)
+	if len(ga) != len(gb) {
+		return false
+	}
+	for role := range ga {
+		if !gb[role] {
+			return false
+		}
+	}
+	return true
+}
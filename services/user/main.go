@@ -12,10 +12,12 @@ import (
 	"time"
 	_ "user/docs"
 
+	"github.com/celesteyang/ChatOrbit/shared/auth"
 	"github.com/celesteyang/ChatOrbit/shared/logger"
 	"github.com/celesteyang/ChatOrbit/shared/swagger"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
@@ -64,10 +66,30 @@ func main() {
 	db := client.Database("chatorbit")
 	InitCollections(db)
 
+	// 連接 Redis, where the auth service records revoked tokens
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: getEnvOrDefault("REDIS_ADDR", ""),
+		DB:   0,
+	})
+	if _, err := redisClient.Ping(context.Background()).Result(); err != nil {
+		logger.Fatal("Redis connection failed", zap.Error(err))
+	}
+
+	jwksURL := getEnvOrDefault("JWKS_URL", "")
+	if jwksURL == "" {
+		logger.Fatal("JWKS_URL environment variable is not set")
+	}
+	cfg := LoadConfig()
+	verifier := auth.NewVerifier(auth.NewJWKSClient(jwksURL, cfg.JWKSCache).Keyfunc, cfg.Verifier, auth.NewRevocations(redisClient))
+	go NewPrivacyWorker().Run(context.Background(), cfg.PrivacyPollInterval)
+
 	r := gin.Default()
 	r.Use(cors.Default())
 	swagger.InitSwagger(r, "User Service")
-	r.GET("/user/:id", GetUserHandler)
+	r.GET("/user/:id", verifier.Middleware(), GetUserHandler)
+	roles := r.Group("/user/:id/roles", verifier.Middleware(), auth.RequireRole(auth.RoleAdmin, auth.RoleStaff))
+	roles.GET("", GetUserRolesHandler)
+	roles.PUT("", UpdateUserRolesHandler)
 	// Run the server
 	if err := r.Run(":" + servicePort); err != nil {
 		logger.Fatal("Failed to run server", zap.Error(err))
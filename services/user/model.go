@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 
+	"github.com/celesteyang/ChatOrbit/shared/privacy"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -15,11 +16,16 @@ type User struct {
 	ID    string `json:"id" bson:"_id"`
 	Name  string `json:"name" bson:"username"`
 	Email string `json:"email" bson:"email"`
+	// Roles is managed through the roles endpoints and not exposed on the public profile.
+	Roles []string `json:"-" bson:"roles,omitempty"`
+	// Deleted marks accounts anonymized after their owner deleted them.
+	Deleted bool `json:"deleted,omitempty" bson:"deleted,omitempty"`
 }
 
 // InitCollections sets up the MongoDB collections and creates necessary indexes.
 func InitCollections(db *mongo.Database) {
 	userCollection = db.Collection("users")
+	privacyStore = privacy.NewStore(db)
 
 	// Create email index to optimize queries.
 	_, err := userCollection.Indexes().CreateOne(
@@ -34,6 +40,22 @@ func InitCollections(db *mongo.Database) {
 	}
 }
 
+// SetUserRoles replaces a user's roles. It returns mongo.ErrNoDocuments for unknown users.
+func SetUserRoles(ctx context.Context, id string, roles []string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	result, err := userCollection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"roles": roles}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
 // GetUserByID fetches a user from the database by ID.
 func GetUserByID(ctx context.Context, id string) (*User, error) {
 	objID, err := primitive.ObjectIDFromHex(id)
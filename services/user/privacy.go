@@ -0,0 +1,84 @@
+package main
+
+// The user service's part of data exports and account deletions, queued by the auth service
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/celesteyang/ChatOrbit/shared/logger"
+	"github.com/celesteyang/ChatOrbit/shared/privacy"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// deletedUsername replaces the name of anonymized users.
+const deletedUsername = "Deleted user"
+
+var privacyStore *privacy.Store
+
+// profileExport is the profile held by the user service.
+type profileExport struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Email string   `json:"email"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// NewPrivacyWorker returns the worker carrying out this service's tasks of privacy jobs.
+func NewPrivacyWorker() *privacy.Worker {
+	return privacy.NewWorker(privacyStore, privacy.ServiceUser, exportProfile, deleteProfile, reportPrivacyTask)
+}
+
+func reportPrivacyTask(job *privacy.Job, err error) {
+	switch {
+	case job == nil:
+		logger.Error("Failed to claim privacy task", zap.Error(err))
+	case err != nil:
+		logger.Error("Privacy task failed", zap.String("job", job.ID), zap.String("type", job.Type), zap.Error(err))
+	default:
+		logger.Info("Privacy task completed", zap.String("job", job.ID), zap.String("type", job.Type))
+	}
+}
+
+// exportProfile writes the user's profile to the export archive.
+func exportProfile(ctx context.Context, job *privacy.Job) error {
+	user, err := GetUserByID(ctx, job.UserID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	profile := profileExport{ID: user.ID, Name: user.Name, Email: user.Email, Roles: user.Roles}
+	return privacyStore.WriteFile(ctx, job, privacy.ServiceUser, "profile.json", profile)
+}
+
+// deleteProfile anonymizes or removes the user document according to the job's policy.
+// Anonymized users keep their ID under a placeholder name and an undeliverable email,
+// unique per user as emails must be.
+func deleteProfile(ctx context.Context, job *privacy.Job) error {
+	objID, err := primitive.ObjectIDFromHex(job.UserID)
+	if err != nil {
+		return err
+	}
+	if job.Policy == privacy.PolicyDelete {
+		_, err = userCollection.DeleteOne(ctx, bson.M{"_id": objID})
+		return err
+	}
+	_, err = userCollection.UpdateOne(ctx,
+		bson.M{"_id": objID},
+		bson.M{
+			"$set": bson.M{
+				"username":   deletedUsername,
+				"email":      "deleted-" + job.UserID + "@deleted.chatorbit.invalid",
+				"deleted":    true,
+				"deleted_at": time.Now(),
+			},
+			"$unset": bson.M{"roles": ""},
+		},
+	)
+	return err
+}
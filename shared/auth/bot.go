@@ -0,0 +1,54 @@
+package auth
+
+// API key scopes carried by bot tokens.
+const (
+	ScopeReadHistory = "history:read"
+	ScopePost        = "messages:post"
+	ScopeModerate    = "moderate"
+)
+
+// RoleBot is carried by tokens issued for a bot's API key.
+const RoleBot = "bot"
+
+// ValidScope reports whether scope is a known API key scope.
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeReadHistory, ScopePost, ScopeModerate:
+		return true
+	}
+	return false
+}
+
+// BotGrant is what a bot token allows: the scopes, rooms and rate limit of the API
+// key it was issued for. User tokens carry none and are not restricted by it.
+type BotGrant struct {
+	KeyID  string   `json:"key_id"`
+	Scopes []string `json:"scopes"`
+	// Rooms restricts the key to these rooms; empty allows every room.
+	Rooms []string `json:"rooms,omitempty"`
+	// RateLimit is how many messages the key may send per rate window; 0 uses the service default.
+	RateLimit int `json:"rate_limit,omitempty"`
+}
+
+// HasScope reports whether the key was granted scope.
+func (g *BotGrant) HasScope(scope string) bool {
+	for _, s := range g.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsRoom reports whether the key may be used in roomID.
+func (g *BotGrant) AllowsRoom(roomID string) bool {
+	if len(g.Rooms) == 0 {
+		return true
+	}
+	for _, room := range g.Rooms {
+		if room == roomID {
+			return true
+		}
+	}
+	return false
+}
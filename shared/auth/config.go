@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VerifierConfigFromEnv reads JWT_ISSUER, JWT_AUDIENCE, JWT_LEEWAY_SECONDS and
+// JWT_ALGORITHMS (comma separated), falling back to the defaults and the given audience.
+func VerifierConfigFromEnv(defaultAudience string) VerifierConfig {
+	cfg := VerifierConfig{
+		Algorithms: DefaultAlgorithms,
+		Issuer:     DefaultIssuer,
+		Audience:   defaultAudience,
+		Leeway:     30 * time.Second,
+	}
+	if value := strings.TrimSpace(os.Getenv("JWT_ISSUER")); value != "" {
+		cfg.Issuer = value
+	}
+	if value := strings.TrimSpace(os.Getenv("JWT_AUDIENCE")); value != "" {
+		cfg.Audience = value
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(os.Getenv("JWT_LEEWAY_SECONDS"))); err == nil && seconds >= 0 {
+		cfg.Leeway = time.Duration(seconds) * time.Second
+	}
+	if algorithms := splitList(os.Getenv("JWT_ALGORITHMS")); len(algorithms) > 0 {
+		cfg.Algorithms = algorithms
+	}
+	return cfg
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms supported for access tokens.
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// ErrUnknownKey is returned when a token names a key that is not published.
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// JWK is a public key in JSON Web Key format (RFC 7517). Only RSA and Ed25519
+// keys are used.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// AlgorithmFor returns the signing algorithm used with a public key.
+func AlgorithmFor(key crypto.PublicKey) (string, error) {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return AlgRS256, nil
+	case ed25519.PublicKey:
+		return AlgEdDSA, nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// NewJWK encodes a public key as a JWK with the given key ID.
+func NewJWK(kid string, key crypto.PublicKey) (JWK, error) {
+	b64 := base64.RawURLEncoding
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA", Kid: kid, Use: "sig", Alg: AlgRS256,
+			N: b64.EncodeToString(k.N.Bytes()),
+			E: b64.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Kid: kid, Use: "sig", Alg: AlgEdDSA, Crv: "Ed25519", X: b64.EncodeToString(k)}, nil
+	default:
+		return JWK{}, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// PublicKey decodes the key material of a JWK. A key without alg, as some
+// identity providers publish them, is used with its key type's algorithm.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding
+	switch {
+	case k.Kty == "RSA" && (k.Alg == AlgRS256 || k.Alg == ""):
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case k.Kty == "OKP" && k.Crv == "Ed25519" && (k.Alg == AlgEdDSA || k.Alg == ""):
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key %s/%s", k.Kty, k.Alg)
+	}
+}
+
+// publicKey is a verification key together with the only algorithm it may be used with.
+type publicKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// JWKSClient verifies tokens against keys fetched from a JWKS endpoint. Keys are
+// cached for the refresh interval; a token naming an unknown key triggers an early
+// refetch, so keys added by a rotation are picked up without a restart.
+type JWKSClient struct {
+	url     string
+	http    *http.Client
+	refresh time.Duration
+	// minRefetch limits how often unknown key IDs can force a refetch.
+	minRefetch time.Duration
+
+	mu        sync.RWMutex
+	keys      map[string]publicKey
+	fetchedAt time.Time
+	// retryAt delays the next fetch after a failed one.
+	retryAt time.Time
+}
+
+// NewJWKSClient returns a client for the JWKS document at url. Keys are fetched lazily.
+func NewJWKSClient(url string, refresh time.Duration) *JWKSClient {
+	return &JWKSClient{
+		url:        url,
+		http:       &http.Client{Timeout: 5 * time.Second},
+		refresh:    refresh,
+		minRefetch: 10 * time.Second,
+	}
+}
+
+// Keyfunc resolves the verification key for a token by its kid header. It rejects
+// tokens whose algorithm does not match the published key.
+func (c *JWKSClient) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, ErrUnknownKey
+	}
+	key, err := c.key(context.Background(), kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.alg {
+		return nil, fmt.Errorf("unexpected signing algorithm %s", token.Method.Alg())
+	}
+	return key.key, nil
+}
+
+func (c *JWKSClient) key(ctx context.Context, kid string) (publicKey, error) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	age := time.Since(c.fetchedAt)
+	retryAt := c.retryAt
+	c.mu.RUnlock()
+
+	switch {
+	case ok && age < c.refresh:
+		return key, nil
+	case !ok && age < c.minRefetch:
+		return publicKey{}, ErrUnknownKey
+	case time.Now().Before(retryAt):
+		// The endpoint failed recently; keep verifying with cached keys.
+		if ok {
+			return key, nil
+		}
+		return publicKey{}, ErrUnknownKey
+	}
+
+	if err := c.fetch(ctx); err != nil {
+		c.mu.Lock()
+		c.retryAt = time.Now().Add(c.minRefetch)
+		c.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+		return publicKey{}, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	return publicKey{}, ErrUnknownKey
+}
+
+func (c *JWKSClient) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("decode JWKS: %w", err)
+	}
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		key, err := jwk.PublicKey()
+		if err != nil || jwk.Kid == "" {
+			continue
+		}
+		alg := jwk.Alg
+		if alg == "" {
+			alg, _ = AlgorithmFor(key)
+		}
+		keys[jwk.Kid] = publicKey{alg: alg, key: key}
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	c.mu.Unlock()
+	return nil
+}
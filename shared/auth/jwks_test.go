@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksServer serves a JWKS document that tests can change, and counts fetches.
+type jwksServer struct {
+	mu      sync.Mutex
+	keys    map[string]ed25519.PrivateKey
+	fetches int
+}
+
+func (s *jwksServer) add(t *testing.T, kid string) ed25519.PrivateKey {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = map[string]ed25519.PrivateKey{}
+	}
+	s.keys[kid] = private
+	return private
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetches++
+	set := JWKS{Keys: []JWK{}}
+	for kid, private := range s.keys {
+		jwk, err := NewJWK(kid, private.Public())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
+func signEdDSA(t *testing.T, kid string, key ed25519.PrivateKey) *jwt.Token {
+	t.Helper()
+	token := jwt.New(jwt.SigningMethodEdDSA)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(signed, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestJWKSClientRefetchesUnknownKey(t *testing.T) {
+	server := &jwksServer{}
+	oldKey := server.add(t, "old")
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client := NewJWKSClient(ts.URL, time.Hour)
+	if _, err := client.Keyfunc(signEdDSA(t, "old", oldKey)); err != nil {
+		t.Fatalf("Keyfunc(old) error = %v", err)
+	}
+
+	// The auth service rotates to a new key; the cached document does not have it yet.
+	newKey := server.add(t, "new")
+	newToken := signEdDSA(t, "new", newKey)
+
+	// Within minRefetch of the last fetch, unknown key IDs cannot force a refetch.
+	if _, err := client.Keyfunc(newToken); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Keyfunc(new) right after a fetch error = %v, want ErrUnknownKey", err)
+	}
+	if got := server.fetchCount(); got != 1 {
+		t.Fatalf("fetches = %d, want 1", got)
+	}
+
+	client.mu.Lock()
+	client.fetchedAt = time.Now().Add(-client.minRefetch)
+	client.mu.Unlock()
+	key, err := client.Keyfunc(newToken)
+	if err != nil {
+		t.Fatalf("Keyfunc(new) after minRefetch error = %v", err)
+	}
+	if !newKey.Public().(ed25519.PublicKey).Equal(key) {
+		t.Fatal("Keyfunc(new) returned the wrong key")
+	}
+	if got := server.fetchCount(); got != 2 {
+		t.Fatalf("fetches = %d, want 2", got)
+	}
+
+	// Known keys are served from the cache.
+	if _, err := client.Keyfunc(signEdDSA(t, "old", oldKey)); err != nil {
+		t.Fatalf("Keyfunc(old) error = %v", err)
+	}
+	if got := server.fetchCount(); got != 2 {
+		t.Fatalf("fetches = %d, want 2", got)
+	}
+}
+
+func TestJWKSClientRejectsAlgorithmMismatch(t *testing.T) {
+	server := &jwksServer{}
+	server.add(t, "ed")
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	token := jwt.New(jwt.SigningMethodRS256)
+	token.Header["kid"] = "ed"
+	if _, err := NewJWKSClient(ts.URL, time.Hour).Keyfunc(token); err == nil {
+		t.Fatal("Keyfunc accepted an RS256 token for an Ed25519 key")
+	}
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gin context keys set by the authentication middleware.
+const (
+	ClaimsKey = "claims"
+	UserIDKey = "user_id"
+	EmailKey  = "email"
+	RolesKey  = "roles"
+)
+
+// TokenFromRequest reads an access token from the Authorization bearer header,
+// the token query parameter (used by WebSocket clients) or the token cookie.
+func TokenFromRequest(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	if token := c.Query("token"); token != "" {
+		return token
+	}
+	if token, err := c.Cookie("token"); err == nil {
+		return token
+	}
+	return ""
+}
+
+// Middleware rejects requests without a valid access token with 401 and stores
+// the token's claims in the context.
+func (v *Verifier) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := TokenFromRequest(c)
+		if tokenString == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		claims, err := v.Verify(c.Request.Context(), tokenString)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+		SetClaims(c, claims)
+		c.Next()
+	}
+}
+
+// OptionalMiddleware identifies the caller when a valid access token is present
+// but lets anonymous requests through.
+func (v *Verifier) OptionalMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if tokenString := TokenFromRequest(c); tokenString != "" {
+			if claims, err := v.Verify(c.Request.Context(), tokenString); err == nil {
+				SetClaims(c, claims)
+			}
+		}
+		c.Next()
+	}
+}
+
+// SetClaims stores the caller's claims in the context, together with the user ID,
+// email and roles under their own keys.
+func SetClaims(c *gin.Context, claims *Claims) {
+	c.Set(ClaimsKey, claims)
+	c.Set(UserIDKey, claims.UserID)
+	c.Set(EmailKey, claims.Email)
+	SetRoles(c, claims.Roles)
+}
+
+// ClaimsFrom returns the caller's claims, or nil for anonymous requests.
+func ClaimsFrom(c *gin.Context) *Claims {
+	value, _ := c.Get(ClaimsKey)
+	claims, _ := value.(*Claims)
+	return claims
+}
+
+// SetRoles stores the caller's roles in the context.
+func SetRoles(c *gin.Context, roles Roles) {
+	c.Set(RolesKey, roles)
+}
+
+// RolesFrom returns the caller's roles, or nil for anonymous requests.
+func RolesFrom(c *gin.Context) Roles {
+	roles, _ := c.Get(RolesKey)
+	r, _ := roles.(Roles)
+	return r
+}
+
+// CanModerate reports whether the caller may moderate roomID (service-wide when empty).
+func CanModerate(c *gin.Context, roomID string) bool {
+	return RolesFrom(c).CanModerate(roomID)
+}
+
+// RoomFromParam reads the room from a path parameter.
+func RoomFromParam(name string) func(*gin.Context) string {
+	return func(c *gin.Context) string {
+		return strings.TrimSpace(c.Param(name))
+	}
+}
+
+// RoomFromQuery reads the room from a query parameter.
+func RoomFromQuery(name string) func(*gin.Context) string {
+	return func(c *gin.Context) string {
+		return strings.TrimSpace(c.Query(name))
+	}
+}
+
+// Forbid aborts the request with 403.
+func Forbid(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+}
+
+// RequireRole lets callers with any of the global roles through.
+// It must run after the authentication middleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !RolesFrom(c).HasAny(roles...) {
+			Forbid(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// DenyGuests rejects anonymous guest tokens with 403. It must run after the
+// authentication middleware.
+func DenyGuests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if RolesFrom(c).IsGuest() {
+			Forbid(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireBotScope rejects bot tokens without scope with 403, and, when room is not
+// nil, bot tokens restricted to other rooms than the request's. Users and anonymous
+// requests pass. It must run after the authentication middleware.
+func RequireBotScope(scope string, room func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims := ClaimsFrom(c); claims != nil && claims.Bot != nil {
+			if !claims.Bot.HasScope(scope) || (room != nil && !claims.Bot.AllowsRoom(room(c))) {
+				Forbid(c)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// RequireAnyModerator lets through callers who moderate at least one room.
+// Handlers still check the concrete room with CanModerate or RequireRoomModerator.
+func RequireAnyModerator() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roles := RolesFrom(c)
+		if !roles.CanModerateAll() && len(roles.ModeratedRooms()) == 0 {
+			Forbid(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireRoomModerator lets through callers who moderate the room named by the
+// request. Requests without a room need a global moderation role.
+func RequireRoomModerator(room func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !CanModerate(c, room(c)) {
+			Forbid(c)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrTokenRevoked is returned for tokens that were logged out, belong to a revoked
+// session or were issued before the user's last "log out everywhere".
+var ErrTokenRevoked = errors.New("token revoked")
+
+// RevocationChannel is the Redis channel revocations are announced on, so
+// services can drop live connections without waiting for tokens to expire.
+const RevocationChannel = "auth:revocations"
+
+// RevocationEvent announces a revoked token (JTI), a revoked session, or a new token
+// version for a user, which revokes every token of that user with an older version.
+type RevocationEvent struct {
+	JTI       string `json:"jti,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	Version   int64  `json:"version,omitempty"`
+}
+
+// Revokes reports whether the event revokes a token with the given claims.
+func (e RevocationEvent) Revokes(jti, sessionID, userID string, version int64) bool {
+	if e.JTI != "" && e.JTI == jti {
+		return true
+	}
+	if e.SessionID != "" && e.SessionID == sessionID {
+		return true
+	}
+	return e.UserID != "" && e.UserID == userID && version < e.Version
+}
+
+func revokedTokenKey(jti string) string {
+	return "auth:revoked:jti:" + jti
+}
+
+func revokedSessionKey(sessionID string) string {
+	return "auth:revoked:sid:" + sessionID
+}
+
+func tokenVersionKey(userID string) string {
+	return "auth:token_version:user:" + userID
+}
+
+// Revocations records revoked tokens in Redis, where every service checks them.
+type Revocations struct {
+	redis *redis.Client
+}
+
+// NewRevocations returns a revocation store backed by the given Redis client.
+func NewRevocations(client *redis.Client) *Revocations {
+	return &Revocations{redis: client}
+}
+
+// RevokeToken revokes a single token until it would have expired anyway.
+func (r *Revocations) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if jti == "" || ttl <= 0 {
+		return nil
+	}
+	return r.store(ctx, revokedTokenKey(jti), 1, ttl, RevocationEvent{JTI: jti})
+}
+
+// RevokeSession revokes every token of a session. ttl must cover the lifetime of
+// the session's access tokens still in circulation.
+func (r *Revocations) RevokeSession(ctx context.Context, sessionID string, ttl time.Duration) error {
+	if sessionID == "" || ttl <= 0 {
+		return nil
+	}
+	return r.store(ctx, revokedSessionKey(sessionID), 1, ttl, RevocationEvent{SessionID: sessionID})
+}
+
+// SetTokenVersion publishes a user's current token version. Tokens carrying an
+// older version are rejected.
+func (r *Revocations) SetTokenVersion(ctx context.Context, userID string, version int64) error {
+	return r.store(ctx, tokenVersionKey(userID), version, 0, RevocationEvent{UserID: userID, Version: version})
+}
+
+// store sets a revocation key and announces the event in one round trip.
+func (r *Revocations) store(ctx context.Context, key string, value interface{}, ttl time.Duration, event RevocationEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	pipe := r.redis.TxPipeline()
+	pipe.Set(ctx, key, value, ttl)
+	pipe.Publish(ctx, RevocationChannel, payload)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Subscribe delivers revocation events announced by any service until ctx is done.
+// Events are best-effort; a missed event only leaves a token usable until it expires
+// or is checked again.
+func (r *Revocations) Subscribe(ctx context.Context) <-chan RevocationEvent {
+	events := make(chan RevocationEvent)
+	pubsub := r.redis.Subscribe(ctx, RevocationChannel)
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var event RevocationEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					continue
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events
+}
+
+// Check returns ErrTokenRevoked when the token was revoked, or another error when
+// revocations cannot be read; callers reject the token either way.
+// sessionID may be empty for tokens that do not belong to a session.
+func (r *Revocations) Check(ctx context.Context, jti, sessionID, userID string, version int64) error {
+	pipe := r.redis.Pipeline()
+	revoked := pipe.Exists(ctx, revokedTokenKey(jti))
+	var sessionRevoked *redis.IntCmd
+	if sessionID != "" {
+		sessionRevoked = pipe.Exists(ctx, revokedSessionKey(sessionID))
+	}
+	current := pipe.Get(ctx, tokenVersionKey(userID))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	if revoked.Val() > 0 || (sessionRevoked != nil && sessionRevoked.Val() > 0) {
+		return ErrTokenRevoked
+	}
+	if value, err := current.Result(); err == nil {
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil && version < v {
+			return ErrTokenRevoked
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package auth
+
+import "strings"
+
+// Global roles.
+const (
+	RoleAdmin     = "admin"
+	RoleStaff     = "staff"
+	RoleModerator = "moderator"
+)
+
+// RoleGuest is carried by anonymous guest tokens. It is issued by the auth service
+// only and never granted to an account.
+const RoleGuest = "guest"
+
+// roomRolePrefix starts a room-scoped role, written as "room:<roomID>:<role>".
+const roomRolePrefix = "room:"
+
+// roomScopedRoles are the roles that may be granted for a single room.
+var roomScopedRoles = map[string]bool{RoleModerator: true}
+
+// RoomRole returns the claim value granting role in one room.
+func RoomRole(roomID, role string) string {
+	return roomRolePrefix + roomID + ":" + role
+}
+
+// ParseRoomRole splits a room-scoped role. ok is false for global roles.
+func ParseRoomRole(value string) (roomID, role string, ok bool) {
+	if !strings.HasPrefix(value, roomRolePrefix) {
+		return "", "", false
+	}
+	rest := strings.TrimPrefix(value, roomRolePrefix)
+	i := strings.LastIndex(rest, ":")
+	if i <= 0 || i == len(rest)-1 {
+		return "", "", false
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+// ValidRole reports whether value is a known global role or a room-scoped role
+// for a concrete room.
+func ValidRole(value string) bool {
+	switch value {
+	case RoleAdmin, RoleStaff, RoleModerator:
+		return true
+	}
+	roomID, role, ok := ParseRoomRole(value)
+	return ok && roomID != "*" && roomScopedRoles[role]
+}
+
+// Roles is the set of roles carried in a token's roles claim.
+type Roles []string
+
+// RolesFromClaim reads a decoded roles claim, ignoring anything that is not a string.
+func RolesFromClaim(claim interface{}) Roles {
+	switch v := claim.(type) {
+	case []string:
+		return Roles(v)
+	case []interface{}:
+		roles := make(Roles, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+		return roles
+	}
+	return nil
+}
+
+// Has reports whether the global role is present.
+func (r Roles) Has(role string) bool {
+	for _, value := range r {
+		if value == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAny reports whether any of the global roles is present.
+func (r Roles) HasAny(roles ...string) bool {
+	for _, role := range roles {
+		if r.Has(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsGuest reports whether the roles belong to an anonymous guest.
+func (r Roles) IsGuest() bool {
+	return r.Has(RoleGuest)
+}
+
+// IsBot reports whether the roles belong to a bot account.
+func (r Roles) IsBot() bool {
+	return r.Has(RoleBot)
+}
+
+// IsStaff reports whether the roles include admin or staff.
+func (r Roles) IsStaff() bool {
+	return r.HasAny(RoleAdmin, RoleStaff)
+}
+
+// CanModerateAll reports whether the roles grant moderation in every room.
+func (r Roles) CanModerateAll() bool {
+	return r.HasAny(RoleAdmin, RoleStaff, RoleModerator)
+}
+
+// CanModerate reports whether the roles grant moderation in roomID. An empty
+// roomID means service-wide moderation, which only global roles grant.
+func (r Roles) CanModerate(roomID string) bool {
+	if r.CanModerateAll() {
+		return true
+	}
+	return roomID != "" && r.Has(RoomRole(roomID, RoleModerator))
+}
+
+// ModeratedRooms returns the rooms the roles grant room-scoped moderation in.
+func (r Roles) ModeratedRooms() []string {
+	var rooms []string
+	for _, value := range r {
+		if roomID, role, ok := ParseRoomRole(value); ok && role == RoleModerator {
+			rooms = append(rooms, roomID)
+		}
+	}
+	return rooms
+}
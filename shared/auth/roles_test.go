@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidRole(t *testing.T) {
+	tests := []struct {
+		role string
+		want bool
+	}{
+		{RoleAdmin, true},
+		{RoleStaff, true},
+		{RoleModerator, true},
+		{"room:lobby:moderator", true},
+		{"room:team:general:moderator", true},
+		{RoleGuest, false},
+		{RoleBot, false},
+		{"room:lobby:admin", false},
+		{"room:*:moderator", false},
+		{"room::moderator", false},
+		{"room:lobby:", false},
+		{"superuser", false},
+	}
+	for _, tt := range tests {
+		if got := ValidRole(tt.role); got != tt.want {
+			t.Errorf("ValidRole(%q) = %v, want %v", tt.role, got, tt.want)
+		}
+	}
+}
+
+func TestRolesCanModerate(t *testing.T) {
+	tests := []struct {
+		name   string
+		roles  Roles
+		roomID string
+		want   bool
+	}{
+		{"no roles", nil, "lobby", false},
+		{"admin", Roles{RoleAdmin}, "lobby", true},
+		{"staff service-wide", Roles{RoleStaff}, "", true},
+		{"global moderator", Roles{RoleModerator}, "lobby", true},
+		{"room moderator in the room", Roles{"room:lobby:moderator"}, "lobby", true},
+		{"room moderator in another room", Roles{"room:lobby:moderator"}, "games", false},
+		{"room moderator service-wide", Roles{"room:lobby:moderator"}, "", false},
+		{"room ID containing a colon", Roles{"room:team:general:moderator"}, "team:general", true},
+		{"guest", Roles{RoleGuest}, "lobby", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.roles.CanModerate(tt.roomID); got != tt.want {
+				t.Fatalf("CanModerate(%q) = %v, want %v", tt.roomID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRolesModeratedRooms(t *testing.T) {
+	roles := Roles{RoleStaff, "room:lobby:moderator", "room:games:moderator", "room:lobby:viewer"}
+	if got, want := roles.ModeratedRooms(), []string{"lobby", "games"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ModeratedRooms() = %v, want %v", got, want)
+	}
+}
+
+func TestRolesFromClaim(t *testing.T) {
+	// Roles decoded from JSON arrive as []interface{}; stray values are dropped.
+	got := RolesFromClaim([]interface{}{RoleAdmin, 42, "room:lobby:moderator"})
+	if want := (Roles{RoleAdmin, "room:lobby:moderator"}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("RolesFromClaim() = %v, want %v", got, want)
+	}
+	if got := RolesFromClaim("admin"); got != nil {
+		t.Fatalf("RolesFromClaim(string) = %v, want nil", got)
+	}
+}
+
+func TestRequireRoomModerator(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name  string
+		roles Roles
+		path  string
+		want  int
+	}{
+		{"anonymous", nil, "/rooms/lobby", http.StatusForbidden},
+		{"user", Roles{}, "/rooms/lobby", http.StatusForbidden},
+		{"room moderator", Roles{"room:lobby:moderator"}, "/rooms/lobby", http.StatusOK},
+		{"room moderator of another room", Roles{"room:games:moderator"}, "/rooms/lobby", http.StatusForbidden},
+		{"global moderator", Roles{RoleModerator}, "/rooms/lobby", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/rooms/:roomID", func(c *gin.Context) {
+				if tt.roles != nil {
+					SetRoles(c, tt.roles)
+				}
+			}, RequireRoomModerator(RoomFromParam("roomID")), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
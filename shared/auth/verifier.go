@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Default issuer and audiences of ChatOrbit access tokens.
+const (
+	DefaultIssuer = "chatorbit-auth"
+	AudienceAuth  = "chatorbit-auth"
+	AudienceChat  = "chatorbit-chat"
+	AudienceUser  = "chatorbit-user"
+)
+
+// DefaultAlgorithms are the signing algorithms accepted unless configured otherwise.
+var DefaultAlgorithms = []string{AlgRS256, AlgEdDSA}
+
+// ErrInvalidToken is returned for tokens that fail signature or claim validation.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the claims of a ChatOrbit access token.
+type Claims struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email,omitempty"`
+	Roles  Roles  `json:"roles,omitempty"`
+	// Name is the display name of guests and bots.
+	Name string `json:"name,omitempty"`
+	// AccountCreatedAt is the account creation time in Unix seconds.
+	AccountCreatedAt int64 `json:"account_created_at,omitempty"`
+	// Version is the user's token version when the token was issued.
+	Version int64 `json:"ver"`
+	// SessionID identifies the login session (device) the token belongs to.
+	SessionID string `json:"sid,omitempty"`
+	// Bot restricts tokens issued for a bot's API key; nil for users.
+	Bot *BotGrant `json:"bot,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// AccountCreated returns the account creation time, or the zero time when the token does not carry it.
+func (c *Claims) AccountCreated() time.Time {
+	if c.AccountCreatedAt <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.AccountCreatedAt, 0)
+}
+
+// VerifierConfig lists what a service requires of the tokens it accepts.
+type VerifierConfig struct {
+	// Algorithms is the allowlist of signing algorithms; empty means DefaultAlgorithms.
+	Algorithms []string
+	// Issuer and Audience must match the iss and aud claims.
+	Issuer   string
+	Audience string
+	// Leeway tolerates clock skew when checking exp, nbf and iat.
+	Leeway time.Duration
+}
+
+// RevocationChecker reports whether a token was revoked. *Revocations implements it.
+type RevocationChecker interface {
+	Check(ctx context.Context, jti, sessionID, userID string, version int64) error
+}
+
+// Verifier validates access tokens: signature, algorithm, iss, aud, exp, nbf and
+// iat, and, when revocations are set, that the token was not revoked.
+type Verifier struct {
+	keyfunc     jwt.Keyfunc
+	parser      *jwt.Parser
+	revocations RevocationChecker
+}
+
+// NewVerifier returns a verifier that resolves signing keys with keyfunc.
+// revocations may be nil when revoked tokens need not be rejected.
+func NewVerifier(keyfunc jwt.Keyfunc, cfg VerifierConfig, revocations RevocationChecker) *Verifier {
+	algorithms := cfg.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = DefaultAlgorithms
+	}
+	return &Verifier{
+		keyfunc: keyfunc,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(algorithms),
+			jwt.WithIssuer(cfg.Issuer),
+			jwt.WithAudience(cfg.Audience),
+			jwt.WithLeeway(cfg.Leeway),
+			jwt.WithExpirationRequired(),
+			jwt.WithIssuedAt(),
+		),
+		revocations: revocations,
+	}
+}
+
+// Verify parses and validates a token. It returns ErrTokenRevoked for revoked
+// tokens and ErrInvalidToken for anything else that fails validation.
+func (v *Verifier) Verify(ctx context.Context, tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := v.parser.ParseWithClaims(tokenString, claims, v.keyfunc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if !token.Valid || claims.UserID == "" || claims.ID == "" {
+		return nil, ErrInvalidToken
+	}
+	if v.revocations != nil {
+		if err := v.revocations.Check(ctx, claims.ID, claims.SessionID, claims.UserID, claims.Version); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeRevocations revokes the tokens matched by any of its events, like the
+// Redis-backed store does.
+type fakeRevocations []RevocationEvent
+
+func (f fakeRevocations) Check(_ context.Context, jti, sessionID, userID string, version int64) error {
+	for _, event := range f {
+		if event.Revokes(jti, sessionID, userID, version) {
+			return ErrTokenRevoked
+		}
+	}
+	return nil
+}
+
+const testKid = "test-key"
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testClaims(now time.Time) *Claims {
+	return &Claims{
+		UserID:    "user-1",
+		Version:   1,
+		SessionID: "session-1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti-1",
+			Issuer:    DefaultIssuer,
+			Audience:  jwt.ClaimStrings{AudienceChat},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(15 * time.Minute)),
+		},
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, claims *Claims, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = testKid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerifierVerify(t *testing.T) {
+	key := testRSAKey(t)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	// The keyfunc hands out the public key for any algorithm, so the verifier's
+	// own allowlist has to reject HS256 and none.
+	keyfunc := func(token *jwt.Token) (interface{}, error) {
+		if token.Method == jwt.SigningMethodHS256 {
+			return publicPEM, nil
+		}
+		return &key.PublicKey, nil
+	}
+	cfg := VerifierConfig{Issuer: DefaultIssuer, Audience: AudienceChat, Leeway: 30 * time.Second}
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		token       func() string
+		revocations fakeRevocations
+		wantErr     error
+	}{
+		{
+			name:  "valid",
+			token: func() string { return sign(t, jwt.SigningMethodRS256, testClaims(now), key) },
+		},
+		{
+			name:    "HS256 signed with the public key",
+			token:   func() string { return sign(t, jwt.SigningMethodHS256, testClaims(now), publicPEM) },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "alg none",
+			token: func() string {
+				return sign(t, jwt.SigningMethodNone, testClaims(now), jwt.UnsafeAllowNoneSignatureType)
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				claims := testClaims(now)
+				claims.Issuer = "someone-else"
+				return sign(t, jwt.SigningMethodRS256, claims, key)
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "wrong audience",
+			token: func() string {
+				claims := testClaims(now)
+				claims.Audience = jwt.ClaimStrings{AudienceUser}
+				return sign(t, jwt.SigningMethodRS256, claims, key)
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "expired inside leeway",
+			token: func() string {
+				claims := testClaims(now.Add(-time.Hour))
+				claims.ExpiresAt = jwt.NewNumericDate(now.Add(-10 * time.Second))
+				return sign(t, jwt.SigningMethodRS256, claims, key)
+			},
+		},
+		{
+			name: "expired outside leeway",
+			token: func() string {
+				claims := testClaims(now.Add(-time.Hour))
+				claims.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+				return sign(t, jwt.SigningMethodRS256, claims, key)
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "missing expiry",
+			token: func() string {
+				claims := testClaims(now)
+				claims.ExpiresAt = nil
+				return sign(t, jwt.SigningMethodRS256, claims, key)
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "missing jti",
+			token: func() string {
+				claims := testClaims(now)
+				claims.ID = ""
+				return sign(t, jwt.SigningMethodRS256, claims, key)
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:        "revoked jti",
+			token:       func() string { return sign(t, jwt.SigningMethodRS256, testClaims(now), key) },
+			revocations: fakeRevocations{{JTI: "jti-1"}},
+			wantErr:     ErrTokenRevoked,
+		},
+		{
+			name:        "revoked session",
+			token:       func() string { return sign(t, jwt.SigningMethodRS256, testClaims(now), key) },
+			revocations: fakeRevocations{{SessionID: "session-1"}},
+			wantErr:     ErrTokenRevoked,
+		},
+		{
+			name:        "older token version",
+			token:       func() string { return sign(t, jwt.SigningMethodRS256, testClaims(now), key) },
+			revocations: fakeRevocations{{UserID: "user-1", Version: 2}},
+			wantErr:     ErrTokenRevoked,
+		},
+		{
+			name:        "current token version",
+			token:       func() string { return sign(t, jwt.SigningMethodRS256, testClaims(now), key) },
+			revocations: fakeRevocations{{UserID: "user-1", Version: 1}, {JTI: "jti-2"}, {SessionID: "session-2"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := NewVerifier(keyfunc, cfg, tt.revocations)
+			claims, err := verifier.Verify(context.Background(), tt.token())
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if claims.UserID != "user-1" || claims.SessionID != "session-1" {
+				t.Fatalf("Verify() claims = %+v", claims)
+			}
+		})
+	}
+}
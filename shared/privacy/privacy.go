@@ -0,0 +1,269 @@
+// Package privacy holds the data export and account deletion jobs. The auth service
+// queues them and every service carries out its own task of each job.
+package privacy
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Types of jobs.
+const (
+	Export = "export"
+	Delete = "delete"
+)
+
+// Deletion policies. Anonymize keeps messages under a pseudonym and leaves a
+// placeholder profile; delete removes both.
+const (
+	PolicyAnonymize = "anonymize"
+	PolicyDelete    = "delete"
+)
+
+// Statuses of a job and of each service's task in it.
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// Services carrying out a task of every job.
+const (
+	ServiceAuth = "auth"
+	ServiceUser = "user"
+	ServiceChat = "chat"
+)
+
+// Services lists every service with a task in each job.
+var Services = []string{ServiceAuth, ServiceUser, ServiceChat}
+
+// TaskTimeout is how long a task may stay running before another instance takes it over.
+const TaskTimeout = 15 * time.Minute
+
+// MaxAttempts is how often a task is tried before the job fails.
+const MaxAttempts = 5
+
+// ErrJobNotFound is returned for unknown and expired jobs.
+var ErrJobNotFound = errors.New("privacy: job not found")
+
+// Task is one service's part of a job.
+type Task struct {
+	Status      string     `bson:"status"`
+	Attempts    int        `bson:"attempts,omitempty"`
+	Error       string     `bson:"error,omitempty"`
+	StartedAt   *time.Time `bson:"started_at,omitempty"`
+	CompletedAt *time.Time `bson:"completed_at,omitempty"`
+}
+
+// Job is a data export or an account deletion. Its ID cannot be guessed, so the
+// status can be polled without a token, which a deletion revokes.
+type Job struct {
+	ID     string `bson:"_id"`
+	UserID string `bson:"user_id"`
+	Type   string `bson:"type"`
+	// Policy is the deletion policy in force when the deletion was requested.
+	Policy    string          `bson:"policy,omitempty"`
+	Tasks     map[string]Task `bson:"tasks"`
+	CreatedAt time.Time       `bson:"created_at"`
+	ExpiresAt time.Time       `bson:"expires_at"`
+	// Status and CompletedAt sum up the tasks.
+	Status      string     `bson:"-"`
+	CompletedAt *time.Time `bson:"-"`
+}
+
+// summarize sets the job status from its tasks: failed if any task failed,
+// completed once every task is, and running once any has started.
+func (j *Job) summarize() {
+	j.Status = StatusPending
+	j.CompletedAt = nil
+	var completed int
+	var last time.Time
+	for _, service := range Services {
+		task := j.Tasks[service]
+		switch task.Status {
+		case StatusFailed:
+			j.Status = StatusFailed
+			return
+		case StatusCompleted:
+			completed++
+			if task.CompletedAt != nil && task.CompletedAt.After(last) {
+				last = *task.CompletedAt
+			}
+			j.Status = StatusRunning
+		case StatusRunning:
+			j.Status = StatusRunning
+		}
+	}
+	if completed == len(Services) {
+		j.Status = StatusCompleted
+		j.CompletedAt = &last
+	}
+}
+
+// ExportFile is one file of an export archive, written by the service owning the data.
+type ExportFile struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	JobID     string             `bson:"job_id"`
+	Service   string             `bson:"service"`
+	Name      string             `bson:"name"`
+	Data      []byte             `bson:"data"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// Store keeps jobs and export files in the database shared by the services.
+type Store struct {
+	jobs  *mongo.Collection
+	files *mongo.Collection
+}
+
+// NewStore returns a store using the privacy_jobs and export_files collections of db.
+func NewStore(db *mongo.Database) *Store {
+	return &Store{
+		jobs:  db.Collection("privacy_jobs"),
+		files: db.Collection("export_files"),
+	}
+}
+
+// CreateIndexes creates the indexes of both collections. Jobs and their files are
+// removed by TTL indexes once they expire.
+func (s *Store) CreateIndexes(ctx context.Context) error {
+	jobIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "type", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+	for _, service := range Services {
+		jobIndexes = append(jobIndexes, mongo.IndexModel{Keys: bson.D{{Key: "tasks." + service + ".status", Value: 1}}})
+	}
+	if _, err := s.jobs.Indexes().CreateMany(ctx, jobIndexes); err != nil {
+		return err
+	}
+	_, err := s.files.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "job_id", Value: 1}, {Key: "service", Value: 1}, {Key: "name", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// Start queues a job with a pending task for every service, or returns the user's
+// unfinished job of the same type. Policy is only kept for deletions.
+func (s *Store) Start(ctx context.Context, userID, jobType, policy string, retention time.Duration) (*Job, error) {
+	unfinished := make([]bson.M, 0, len(Services))
+	for _, service := range Services {
+		unfinished = append(unfinished, bson.M{"tasks." + service + ".status": bson.M{"$in": []string{StatusPending, StatusRunning}}})
+	}
+	var job Job
+	err := s.jobs.FindOne(ctx, bson.M{"user_id": userID, "type": jobType, "$or": unfinished}).Decode(&job)
+	if err == nil {
+		job.summarize()
+		return &job, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	job = Job{
+		ID:        id,
+		UserID:    userID,
+		Type:      jobType,
+		Tasks:     make(map[string]Task, len(Services)),
+		CreatedAt: now,
+		ExpiresAt: now.Add(retention),
+	}
+	if jobType == Delete {
+		job.Policy = policy
+	}
+	for _, service := range Services {
+		job.Tasks[service] = Task{Status: StatusPending}
+	}
+	if _, err := s.jobs.InsertOne(ctx, job); err != nil {
+		return nil, err
+	}
+	job.summarize()
+	return &job, nil
+}
+
+// newJobID returns 16 random bytes, URL-safe encoded.
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Get returns a job with its status.
+func (s *Store) Get(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	err := s.jobs.FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	job.summarize()
+	return &job, nil
+}
+
+// WriteFile adds v as a JSON file to the service's folder of the job's archive.
+func (s *Store) WriteFile(ctx context.Context, job *Job, service, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = s.files.InsertOne(ctx, ExportFile{
+		JobID:     job.ID,
+		Service:   service,
+		Name:      name,
+		Data:      data,
+		ExpiresAt: job.ExpiresAt,
+	})
+	return err
+}
+
+// WriteArchive writes the files of an export job as a zip archive with a folder per service.
+func (s *Store) WriteArchive(ctx context.Context, w io.Writer, job *Job) error {
+	cursor, err := s.files.Find(ctx, bson.M{"job_id": job.ID},
+		options.Find().SetSort(bson.D{{Key: "service", Value: 1}, {Key: "name", Value: 1}}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	archive := zip.NewWriter(w)
+	for cursor.Next(ctx) {
+		var file ExportFile
+		if err := cursor.Decode(&file); err != nil {
+			return err
+		}
+		entry, err := archive.Create(file.Service + "/" + file.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := entry.Write(file.Data); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return archive.Close()
+}
@@ -0,0 +1,126 @@
+package privacy
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TaskFunc carries out a service's task of a job. Export tasks add their files with
+// Store.WriteFile; files of an earlier attempt that stopped halfway are already removed.
+type TaskFunc func(ctx context.Context, job *Job) error
+
+// ReportFunc is told the outcome of every task the worker ran, with a nil error once it
+// completed. Errors reading the queue are reported with a nil job.
+type ReportFunc func(job *Job, err error)
+
+// Worker carries out one service's tasks of every job.
+type Worker struct {
+	store   *Store
+	service string
+	tasks   map[string]TaskFunc
+	report  ReportFunc
+}
+
+// NewWorker returns a worker running export and deletion tasks for service.
+func NewWorker(store *Store, service string, export, del TaskFunc, report ReportFunc) *Worker {
+	return &Worker{
+		store:   store,
+		service: service,
+		tasks:   map[string]TaskFunc{Export: export, Delete: del},
+		report:  report,
+	}
+}
+
+// Run carries out the service's tasks until ctx is done, looking for new ones every interval.
+func (w *Worker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for w.RunNext(ctx) {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunNext claims and carries out one task. It reports whether one succeeded, so
+// failed tasks are retried on the next poll rather than right away.
+func (w *Worker) RunNext(ctx context.Context) bool {
+	job, err := w.claim(ctx)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			w.report(nil, err)
+		}
+		return false
+	}
+
+	err = w.run(ctx, job)
+	if finishErr := w.finish(ctx, job, err); finishErr != nil && err == nil {
+		err = finishErr
+	}
+	w.report(job, err)
+	return err == nil
+}
+
+func (w *Worker) run(ctx context.Context, job *Job) error {
+	task := w.tasks[job.Type]
+	if task == nil {
+		return nil
+	}
+	if job.Type == Export {
+		if _, err := w.store.files.DeleteMany(ctx, bson.M{"job_id": job.ID, "service": w.service}); err != nil {
+			return err
+		}
+	}
+	return task(ctx, job)
+}
+
+// claim marks the oldest pending task of the service running. Tasks left running by
+// an instance that stopped are taken over after TaskTimeout.
+func (w *Worker) claim(ctx context.Context) (*Job, error) {
+	prefix := "tasks." + w.service + "."
+	now := time.Now()
+	var job Job
+	err := w.store.jobs.FindOneAndUpdate(ctx,
+		bson.M{"$or": []bson.M{
+			{prefix + "status": StatusPending},
+			{prefix + "status": StatusRunning, prefix + "started_at": bson.M{"$lt": now.Add(-TaskTimeout)}},
+		}},
+		bson.M{
+			"$set": bson.M{prefix + "status": StatusRunning, prefix + "started_at": now},
+			"$inc": bson.M{prefix + "attempts": 1},
+		},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetReturnDocument(options.After),
+	).Decode(&job)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// finish records the outcome of the service's task. A failed task is retried until
+// MaxAttempts, after which the job fails.
+func (w *Worker) finish(ctx context.Context, job *Job, taskErr error) error {
+	prefix := "tasks." + w.service + "."
+	update := bson.M{
+		"$set":   bson.M{prefix + "status": StatusCompleted, prefix + "completed_at": time.Now()},
+		"$unset": bson.M{prefix + "error": ""},
+	}
+	if taskErr != nil {
+		status := StatusPending
+		if job.Tasks[w.service].Attempts >= MaxAttempts {
+			status = StatusFailed
+		}
+		update = bson.M{"$set": bson.M{prefix + "status": status, prefix + "error": taskErr.Error()}}
+	}
+	_, err := w.store.jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, update)
+	return err
+}
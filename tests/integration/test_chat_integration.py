@@ -1,14 +1,25 @@
 # test_chat_integration.py
 import asyncio
+import base64
+import datetime
+import hashlib
+import hmac
+import io
+import os
+import struct
+import time
 import websockets
 import requests
 import uuid
 import json
+import zipfile
+from pymongo import MongoClient
 
 AUTH_URL = "http://localhost:8089"
 CHAT_URL = "http://localhost:8088"
 USER_URL = "http://localhost:8087"
 WS_BASE = "ws://localhost:8088/ws/chat"
+MONGO_URL = os.getenv("MONGO_TEST_URL", "mongodb://localhost:27019")
 
 
 async def connect(token, room_id):
@@ -17,17 +28,19 @@ async def connect(token, room_id):
     return ws
 
 
-async def register(email, password, username):
+async def register(email, password, username, verified=True):
     """
-    Register a new user
+    Register a new user, marking the email verified unless asked not to
     """
     res = requests.post(
         f"{AUTH_URL}/register",
         json={"email": email, "password": password, "username": username},
     )
-    if res.status_code == 200:
-        return True
-    return False
+    if res.status_code != 200:
+        return False
+    if verified:
+        verify_email(email)
+    return True
 
 
 async def login(email, password):
@@ -55,6 +68,51 @@ async def create_room(room_id):
     return False
 
 
+def verify_email(email):
+    """
+    Mark an email verified in the database, as following the emailed link would
+    """
+    client = MongoClient(MONGO_URL)
+    try:
+        client["chatorbit"]["users"].update_one({"email": email}, {"$set": {"email_verified": True}})
+    finally:
+        client.close()
+
+
+def grant_roles(email, roles):
+    """
+    Set a user's roles in the database; they are in the tokens of later logins
+    """
+    client = MongoClient(MONGO_URL)
+    try:
+        client["chatorbit"]["users"].update_one({"email": email}, {"$set": {"roles": roles}})
+    finally:
+        client.close()
+
+
+async def new_user(roles=None):
+    """
+    Register a random user, optionally with roles, and return the email, password and access token
+    """
+    name = "user_" + str(uuid.uuid4())[:8]
+    password = str(uuid.uuid4())[:8]
+    email = f"{name}@gmail.com"
+    assert await register(email, password, name), "Registration failed"
+    if roles:
+        grant_roles(email, roles)
+    return email, password, await login(email, password)
+
+
+async def recv_until(ws, match, timeout=5):
+    """
+    Receive frames until one matches, skipping presence and other events
+    """
+    while True:
+        frame = json.loads(await asyncio.wait_for(ws.recv(), timeout=timeout))
+        if match(frame):
+            return frame
+
+
 async def test_chat_flow():
     # Generate 2 random user names:
     name1 = "user_" + str(uuid.uuid4())[:8]
@@ -109,5 +167,976 @@ async def test_chat_flow():
     await ws2.close()
 
 
+async def test_rejects_empty_message():
+    _, _, token = await new_user()
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+
+    ws = await connect(token, room_id)
+
+    # Whitespace-only content is trimmed to nothing and rejected
+    await ws.send(json.dumps({"room_id": room_id, "content": "   \u0007  "}))
+
+    response = json.loads(await ws.recv())
+    print(f"Error frame: {response}")
+
+    assert response["type"] == "error"
+    assert response["code"] == "empty_message"
+
+    # Invalid UTF-8 is caught on the raw frame, before JSON decoding would mask it
+    await ws.send(b'{"room_id": "' + room_id.encode() + b'", "content": "\xff\xfe"}')
+    response = json.loads(await ws.recv())
+    assert response["code"] == "invalid_encoding"
+
+    await ws.close()
+
+
+async def test_refresh_token_rotation():
+    email, password, _ = await new_user()
+    res = requests.post(f"{AUTH_URL}/login", json={"email": email, "password": password})
+    first = res.json()["refresh_token"]
+
+    # A refresh token can be exchanged once
+    res = requests.post(f"{AUTH_URL}/refresh", json={"refresh_token": first})
+    assert res.status_code == 200
+    second = res.json()["refresh_token"]
+    assert res.json()["token"]
+
+    # Reusing the rotated token revokes the whole family, including the new token
+    res = requests.post(f"{AUTH_URL}/refresh", json={"refresh_token": first})
+    assert res.status_code == 401
+    res = requests.post(f"{AUTH_URL}/refresh", json={"refresh_token": second})
+    assert res.status_code == 401
+
+
+async def test_logout_closes_socket():
+    email, password, token = await new_user()
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    ws = await connect(token, room_id)
+
+    # A fresh token replaces the connection's token without reconnecting
+    fresh = await login(email, password)
+    await ws.send(json.dumps({"type": "reauth", "token": fresh}))
+    response = json.loads(await ws.recv())
+    assert response["type"] == "reauthenticated"
+
+    # Logging out revokes the fresh token and closes the live connection
+    res = requests.post(f"{AUTH_URL}/logout", headers={"Authorization": f"Bearer {fresh}"})
+    assert res.status_code == 200
+    try:
+        await asyncio.wait_for(ws.recv(), timeout=5)
+        assert False, "connection was not closed"
+    except websockets.ConnectionClosed as closed:
+        assert closed.code == 4002
+
+
+async def test_reauth_is_admitted_like_a_new_connection():
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    email, password, token = await new_user([f"room:{room_id}:moderator"])
+    lockdown_url = f"{CHAT_URL}/chat/moderation/rooms/{room_id}/lockdown"
+    res = requests.put(lockdown_url, json={"reason": "raid"}, headers={"Authorization": f"Bearer {token}"})
+    assert res.status_code == 200
+
+    # Moderators pass the lockdown; the same user without the role is a new account it keeps out
+    ws = await connect(token, room_id)
+    grant_roles(email, [])
+    fresh = await login(email, password)
+    try:
+        await connect(fresh, room_id)
+        assert False, "new account joined a locked room"
+    except websockets.InvalidStatusCode as rejected:
+        assert rejected.status_code == 403
+
+    # A reauth with that token is refused the same way and the moderator token stays in place
+    await ws.send(json.dumps({"type": "reauth", "token": fresh}))
+    frame = await recv_until(ws, lambda f: f.get("type") == "error")
+    assert frame["code"] == "reauth_failed"
+    await ws.send(json.dumps({"room_id": room_id, "content": "still here"}))
+    frame = await recv_until(ws, lambda f: "content" in f)
+    assert frame["content"] == "still here"
+    requests.delete(lockdown_url, headers={"Authorization": f"Bearer {token}"})
+    await ws.close()
+
+
+async def test_login_lockout():
+    email, password, _ = await new_user()
+
+    # Unknown accounts and wrong passwords get the same response
+    unknown = requests.post(f"{AUTH_URL}/login", json={"email": f"nobody_{email}", "password": password})
+    wrong = requests.post(f"{AUTH_URL}/login", json={"email": email, "password": "wrong-password"})
+    assert unknown.status_code == wrong.status_code == 401
+    assert unknown.json() == wrong.json()
+
+    # After repeated failures even the correct password is refused for a while
+    for _ in range(4):
+        requests.post(f"{AUTH_URL}/login", json={"email": email, "password": "wrong-password"})
+    res = requests.post(f"{AUTH_URL}/login", json={"email": email, "password": password})
+    assert res.status_code == 429
+    assert int(res.headers["Retry-After"]) > 0
+
+
+def totp(secret, at=None):
+    key = base64.b32decode(secret + "=" * (-len(secret) % 8))
+    step = int((at or time.time()) // 30)
+    digest = hmac.new(key, struct.pack(">Q", step), hashlib.sha1).digest()
+    offset = digest[-1] & 0x0F
+    value = struct.unpack(">I", digest[offset:offset + 4])[0] & 0x7FFFFFFF
+    return f"{value % 1000000:06d}"
+
+
+async def test_two_factor_login():
+    email, password, token = await new_user()
+    headers = {"Authorization": f"Bearer {token}"}
+
+    res = requests.post(f"{AUTH_URL}/2fa/enroll", headers=headers)
+    assert res.status_code == 200
+    secret = res.json()["secret"]
+    assert res.json()["otpauth_uri"].startswith("otpauth://totp/")
+
+    res = requests.post(f"{AUTH_URL}/2fa/confirm", headers=headers, json={"code": totp(secret)})
+    assert res.status_code == 200
+    recovery_codes = res.json()["recovery_codes"]
+
+    # The password alone now only yields a partial token
+    res = requests.post(f"{AUTH_URL}/login", json={"email": email, "password": password})
+    assert res.status_code == 200
+    assert res.json()["mfa_required"] and not res.json()["token"]
+    mfa_token = res.json()["mfa_token"]
+
+    res = requests.post(f"{AUTH_URL}/login/2fa", json={"mfa_token": mfa_token, "code": "000000"})
+    assert res.status_code == 401
+    res = requests.post(f"{AUTH_URL}/login/2fa", json={"mfa_token": mfa_token, "code": recovery_codes[0]})
+    assert res.status_code == 200
+    assert res.json()["token"]
+
+    # Partial tokens and recovery codes work once
+    res = requests.post(f"{AUTH_URL}/login/2fa", json={"mfa_token": mfa_token, "code": recovery_codes[1]})
+    assert res.status_code == 401
+
+    res = requests.post(f"{AUTH_URL}/2fa/disable", headers=headers, json={"password": password, "code": recovery_codes[0]})
+    assert res.status_code == 401
+    res = requests.post(f"{AUTH_URL}/2fa/disable", headers=headers, json={"password": password, "code": recovery_codes[1]})
+    assert res.status_code == 200
+    assert await login(email, password)
+
+
+async def test_guest_read_only():
+    res = requests.post(f"{AUTH_URL}/guest")
+    assert res.status_code == 200
+    guest = res.json()
+    assert guest["user_id"].startswith("guest:") and guest["display_name"]
+
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+
+    # Guests can watch but not send
+    ws = await connect(guest["token"], room_id)
+    await ws.send(json.dumps({"content": "hello", "room_id": room_id}))
+    response = json.loads(await ws.recv())
+    assert response["type"] == "error" and response["code"] == "guest_read_only"
+    await ws.close()
+
+    res = requests.post(f"{CHAT_URL}/chat/reports", headers={"Authorization": f"Bearer {guest['token']}"}, json={})
+    assert res.status_code == 403
+
+
+async def test_revoke_session():
+    email, password, phone = await new_user()
+    laptop = await login(email, password)
+
+    res = requests.get(f"{AUTH_URL}/sessions", headers={"Authorization": f"Bearer {laptop}"})
+    assert res.status_code == 200
+    sessions = res.json()
+    assert len(sessions) == 2
+    other = next(s for s in sessions if not s["current"])
+
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    ws = await connect(phone, room_id)
+
+    # Revoking the phone's session closes its socket and rejects its token
+    res = requests.delete(f"{AUTH_URL}/sessions/{other['id']}", headers={"Authorization": f"Bearer {laptop}"})
+    assert res.status_code == 200
+    try:
+        await asyncio.wait_for(ws.recv(), timeout=5)
+        assert False, "connection was not closed"
+    except websockets.ConnectionClosed as closed:
+        assert closed.code == 4002
+    res = requests.get(f"{AUTH_URL}/sessions", headers={"Authorization": f"Bearer {phone}"})
+    assert res.status_code == 401
+    res = requests.get(f"{AUTH_URL}/sessions", headers={"Authorization": f"Bearer {laptop}"})
+    assert len(res.json()) == 1
+
+
+def token_claims(token):
+    payload = token.split(".")[1]
+    return json.loads(base64.urlsafe_b64decode(payload + "=" * (-len(payload) % 4)))
+
+
+async def test_logout_and_reuse_end_session():
+    email, password, _ = await new_user()
+
+    # Logging out with one access token also revokes the others of the same session
+    res = requests.post(f"{AUTH_URL}/login", json={"email": email, "password": password}).json()
+    first = res["token"]
+    second = requests.post(f"{AUTH_URL}/refresh", json={"refresh_token": res["refresh_token"]}).json()["token"]
+    requests.post(f"{AUTH_URL}/logout", headers={"Authorization": f"Bearer {first}"})
+    res = requests.get(f"{AUTH_URL}/sessions", headers={"Authorization": f"Bearer {second}"})
+    assert res.status_code == 401
+    # The user service checks revocations as well
+    res = requests.get(f"{USER_URL}/user/{token_claims(second)['user_id']}", headers={"Authorization": f"Bearer {second}"})
+    assert res.status_code == 401
+
+    # Reusing a rotated refresh token revokes the session's access tokens and closes its sockets
+    res = requests.post(f"{AUTH_URL}/login", json={"email": email, "password": password}).json()
+    leaked = res["refresh_token"]
+    token = requests.post(f"{AUTH_URL}/refresh", json={"refresh_token": leaked}).json()["token"]
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    ws = await connect(token, room_id)
+    assert requests.post(f"{AUTH_URL}/refresh", json={"refresh_token": leaked}).status_code == 401
+    try:
+        await asyncio.wait_for(ws.recv(), timeout=5)
+        assert False, "connection was not closed"
+    except websockets.ConnectionClosed as closed:
+        assert closed.code == 4002
+    res = requests.get(f"{AUTH_URL}/sessions", headers={"Authorization": f"Bearer {token}"})
+    assert res.status_code == 401
+
+
+def wait_for_job(job_id, timeout=60):
+    deadline = time.time() + timeout
+    while time.time() < deadline:
+        job = requests.get(f"{AUTH_URL}/account/jobs/{job_id}").json()
+        if job["status"] in ("completed", "failed"):
+            return job
+        time.sleep(1)
+    assert False, f"job {job_id} did not finish"
+
+
+async def test_export_and_delete_account():
+    email, password, token = await new_user()
+    headers = {"Authorization": f"Bearer {token}"}
+
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    ws = await connect(token, room_id)
+    await ws.send(json.dumps({"room_id": room_id, "content": "remember me"}))
+    await ws.recv()
+    await ws.close()
+
+    # The export bundles the data of every service into one archive
+    res = requests.post(f"{AUTH_URL}/account/export", headers=headers)
+    assert res.status_code == 202
+    job = wait_for_job(res.json()["id"])
+    assert job["status"] == "completed"
+    assert set(job["tasks"]) == {"auth", "user", "chat"}
+    res = requests.get(f"{AUTH_URL}/account/export/{job['id']}", headers=headers)
+    assert res.status_code == 200
+    archive = zipfile.ZipFile(io.BytesIO(res.content))
+    assert {"auth/account.json", "user/profile.json", "chat/messages-0001.json"} <= set(archive.namelist())
+    messages = json.loads(archive.read("chat/messages-0001.json"))
+    assert [m["content"] for m in messages] == ["remember me"]
+    user_id = json.loads(archive.read("auth/account.json"))["id"]
+
+    # Deletion logs the account out at once; the job status stays pollable without a token
+    res = requests.post(f"{AUTH_URL}/account/delete", headers=headers, json={"password": password})
+    assert res.status_code == 202
+    assert requests.get(f"{AUTH_URL}/sessions", headers=headers).status_code == 401
+    assert wait_for_job(res.json()["id"])["status"] == "completed"
+    res = requests.post(f"{AUTH_URL}/login", json={"email": email, "password": password})
+    assert res.status_code == 401
+    history = requests.get(f"{CHAT_URL}/chat/history/{room_id}").json()
+    assert all(m["user_id"] != user_id for m in history)
+
+
+async def test_room_filters_reject_and_hold():
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    _, _, mod_token = await new_user([f"room:{room_id}:moderator"])
+    _, _, token = await new_user()
+    mod = {"Authorization": f"Bearer {mod_token}"}
+
+    # Only moderators of the room may change its filters
+    filters = {
+        "terms": [
+            {"pattern": "darn", "match": "exact", "action": "mask"},
+            {"pattern": "scam*", "match": "wildcard", "action": "reject"},
+            {"pattern": "giveaway", "match": "exact", "action": "hold"},
+        ],
+        "link_policy": {"mode": "allowlist", "domains": ["example.com"], "action": "reject"},
+    }
+    res = requests.put(f"{CHAT_URL}/chat/moderation/rooms/{room_id}/filters", json=filters,
+                       headers={"Authorization": f"Bearer {token}"})
+    assert res.status_code == 403
+    res = requests.put(f"{CHAT_URL}/chat/moderation/rooms/{room_id}/filters", json=filters, headers=mod)
+    assert res.status_code == 200
+
+    ws = await connect(token, room_id)
+
+    # Masks apply in place, rejects and holds stop the message
+    await ws.send(json.dumps({"room_id": room_id, "content": "oh darn it"}))
+    frame = await recv_until(ws, lambda f: "content" in f)
+    assert frame["content"] == "oh **** it"
+    await ws.send(json.dumps({"room_id": room_id, "content": "great scammers here"}))
+    frame = await recv_until(ws, lambda f: f.get("type") == "error")
+    assert frame["code"] == "message_rejected"
+    await ws.send(json.dumps({"room_id": room_id, "content": "see https://evil.test/x"}))
+    frame = await recv_until(ws, lambda f: f.get("type") == "error")
+    assert frame["code"] == "message_rejected"
+    await ws.send(json.dumps({"room_id": room_id, "content": "join the giveaway now"}))
+    frame = await recv_until(ws, lambda f: f.get("type") == "held")
+    assert frame["code"] == "held_for_review"
+    held_id = frame["id"]
+
+    # The held message waits in the queue until a moderator approves it
+    res = requests.get(f"{CHAT_URL}/chat/moderation/held", params={"room_id": room_id}, headers=mod)
+    assert res.status_code == 200
+    assert [h["id"] for h in res.json()] == [held_id]
+    res = requests.post(f"{CHAT_URL}/chat/moderation/held/{held_id}/approve", headers=mod)
+    assert res.status_code == 200
+    frame = await recv_until(ws, lambda f: "content" in f)
+    assert frame["content"] == "join the giveaway now"
+    history = requests.get(f"{CHAT_URL}/chat/history/{room_id}").json()
+    assert [m["content"] for m in history] == ["join the giveaway now", "oh **** it"]
+    await ws.close()
+
+
+async def test_rejected_held_message_stays_out():
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    _, _, mod_token = await new_user([f"room:{room_id}:moderator"])
+    _, _, token = await new_user()
+    _, _, viewer = await new_user()
+    mod = {"Authorization": f"Bearer {mod_token}"}
+    res = requests.put(f"{CHAT_URL}/chat/moderation/rooms/{room_id}/filters",
+                       json={"terms": [{"pattern": "giveaway", "action": "hold"}]}, headers=mod)
+    assert res.status_code == 200
+
+    feed = await websockets.connect(f"ws://localhost:8088/ws/moderation?token={mod_token}&room_id={room_id}")
+    await asyncio.sleep(0.5)  # the feed subscribes after the handshake
+    ws = await connect(token, room_id)
+    viewer_ws = await connect(viewer, room_id)
+
+    # Moderators watching the room see the message arrive in the queue
+    await ws.send(json.dumps({"room_id": room_id, "content": "free giveaway inside"}))
+    held_id = (await recv_until(ws, lambda f: f.get("type") == "held"))["id"]
+    event = await recv_until(feed, lambda f: f.get("type") == "held_created")
+    assert event["item"]["id"] == held_id and event["item"]["content"] == "free giveaway inside"
+
+    # Rejecting tells the author and the feed, and the message never reaches the room
+    res = requests.post(f"{CHAT_URL}/chat/moderation/held/{held_id}/reject", json={"note": "no giveaways"}, headers=mod)
+    assert res.status_code == 200
+    frame = await recv_until(ws, lambda f: f.get("type") == "rejected")
+    assert frame["code"] == "rejected_by_moderator" and frame["id"] == held_id
+    assert frame["message"].endswith("no giveaways")
+    event = await recv_until(feed, lambda f: f.get("type") == "held_resolved")
+    assert event["item"]["id"] == held_id and event["item"]["status"] == "rejected"
+    try:
+        await recv_until(viewer_ws, lambda f: "content" in f, timeout=2)
+        assert False, "rejected message was delivered"
+    except asyncio.TimeoutError:
+        pass
+    assert requests.get(f"{CHAT_URL}/chat/history/{room_id}").json() == []
+    assert requests.get(f"{CHAT_URL}/chat/history/{room_id}", headers=mod).json() == []
+
+    # The decision is final
+    res = requests.post(f"{CHAT_URL}/chat/moderation/held/{held_id}/approve", headers=mod)
+    assert res.status_code == 404
+    res = requests.get(f"{CHAT_URL}/chat/moderation/held", params={"room_id": room_id}, headers=mod)
+    assert res.json() == []
+    await feed.close()
+    await ws.close()
+    await viewer_ws.close()
+
+
+async def test_reports_hide_message_at_threshold():
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    _, _, author = await new_user()
+    _, _, mod_token = await new_user(["moderator"])
+    mod = {"Authorization": f"Bearer {mod_token}"}
+
+    ws = await connect(author, room_id)
+    await ws.send(json.dumps({"room_id": room_id, "content": "report me"}))
+    message_id = (await recv_until(ws, lambda f: "content" in f))["id"]
+    report = {"target_type": "message", "target_id": message_id, "room_id": room_id, "category": "spam"}
+
+    res = requests.post(f"{CHAT_URL}/chat/reports", json=report, headers={"Authorization": f"Bearer {author}"})
+    assert res.status_code == 400, "authors cannot report their own messages"
+
+    # Each user counts once; the third distinct reporter hides the message
+    for i in range(3):
+        _, _, reporter = await new_user()
+        headers = {"Authorization": f"Bearer {reporter}"}
+        res = requests.post(f"{CHAT_URL}/chat/reports", json=report, headers=headers)
+        assert res.status_code == 201 and res.json()["status"] == "received"
+        res = requests.post(f"{CHAT_URL}/chat/reports", json=report, headers=headers)
+        assert res.status_code == 200 and res.json()["status"] == "already_reported"
+        history = requests.get(f"{CHAT_URL}/chat/history/{room_id}").json()
+        assert [m["id"] for m in history] == ([message_id] if i < 2 else [])
+
+    event = await recv_until(ws, lambda f: f.get("type") == "message_hidden")
+    assert event["message_id"] == message_id
+    history = requests.get(f"{CHAT_URL}/chat/history/{room_id}", headers=mod).json()
+    assert history[0]["id"] == message_id and history[0]["hidden_reason"] == "reports"
+
+    # The report waits for a moderator; dismissing it restores the message
+    res = requests.get(f"{CHAT_URL}/chat/moderation/reports", params={"room_id": room_id}, headers=mod)
+    reports = res.json()
+    assert len(reports) == 1 and reports[0]["count"] == 3 and reports[0]["auto_hidden"]
+    res = requests.post(f"{CHAT_URL}/chat/moderation/reports/{reports[0]['id']}/resolve",
+                        json={"action": "dismiss"}, headers=mod)
+    assert res.status_code == 200
+    event = await recv_until(ws, lambda f: f.get("type") == "message_restored")
+    assert event["message_id"] == message_id
+    history = requests.get(f"{CHAT_URL}/chat/history/{room_id}").json()
+    assert [m["id"] for m in history] == [message_id]
+    await ws.close()
+
+
+async def test_moderation_audit_export():
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    _, _, mod_token = await new_user([f"room:{room_id}:moderator"])
+    _, _, token = await new_user()
+    mod = {"Authorization": f"Bearer {mod_token}"}
+    moderator_id = token_claims(mod_token)["user_id"]
+
+    res = requests.put(f"{CHAT_URL}/chat/moderation/rooms/{room_id}/filters",
+                       json={"terms": [{"pattern": "spoiler", "action": "mask"}]}, headers=mod)
+    assert res.status_code == 200
+    res = requests.put(f"{CHAT_URL}/chat/moderation/rooms/{room_id}/slowmode",
+                       json={"interval_seconds": 10, "reason": "busy"}, headers=mod)
+    assert res.status_code == 200
+    res = requests.delete(f"{CHAT_URL}/chat/moderation/rooms/{room_id}/slowmode", headers=mod)
+    assert res.status_code == 200
+
+    # The log is filterable and newest first
+    res = requests.get(f"{CHAT_URL}/chat/moderation/actions", params={"room_id": room_id}, headers=mod)
+    assert res.status_code == 200
+    assert [a["action"] for a in res.json()] == ["slow_mode_disabled", "slow_mode_enabled", "filters_updated"]
+    assert all(a["actor_id"] == moderator_id for a in res.json())
+    res = requests.get(f"{CHAT_URL}/chat/moderation/actions",
+                       params={"room_id": room_id, "action": "slow_mode_enabled"}, headers=mod)
+    assert len(res.json()) == 1 and res.json()[0]["reason"] == "busy"
+
+    # The export streams the same entries as NDJSON, oldest first
+    res = requests.get(f"{CHAT_URL}/chat/moderation/actions/export", params={"room_id": room_id}, headers=mod)
+    assert res.status_code == 200
+    assert res.headers["Content-Type"].startswith("application/x-ndjson")
+    assert "attachment" in res.headers["Content-Disposition"]
+    entries = [json.loads(line) for line in res.text.splitlines()]
+    assert [e["action"] for e in entries] == ["filters_updated", "slow_mode_enabled", "slow_mode_disabled"]
+
+    # Room moderators cannot read other rooms or the whole log; users cannot read it at all
+    res = requests.get(f"{CHAT_URL}/chat/moderation/actions/export", headers=mod)
+    assert res.status_code == 403
+    res = requests.get(f"{CHAT_URL}/chat/moderation/actions/export", params={"room_id": room_id},
+                       headers={"Authorization": f"Bearer {token}"})
+    assert res.status_code == 403
+
+
+async def test_mute_expires():
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    _, _, mod_token = await new_user([f"room:{room_id}:moderator"])
+    _, _, token = await new_user()
+    mod = {"Authorization": f"Bearer {mod_token}"}
+    user_id = token_claims(token)["user_id"]
+    ws = await connect(token, room_id)
+
+    # Room moderators can only time out users in their own rooms
+    res = requests.post(f"{CHAT_URL}/chat/moderation/mutes", json={"user_id": user_id, "duration_seconds": 3}, headers=mod)
+    assert res.status_code == 403
+    res = requests.post(f"{CHAT_URL}/chat/moderation/mutes",
+                        json={"user_id": user_id, "room_id": room_id, "duration_seconds": 3}, headers=mod)
+    assert res.status_code == 200 and res.json()["scope"] == "room"
+
+    # The user is told at once and every send is refused while the timeout lasts
+    frame = await recv_until(ws, lambda f: f.get("type") == "muted")
+    assert 0 < frame["remaining_seconds"] <= 3
+    await ws.send(json.dumps({"room_id": room_id, "content": "let me talk"}))
+    frame = await recv_until(ws, lambda f: f.get("type") == "error")
+    assert frame["code"] == "muted" and frame["remaining_seconds"] > 0
+    res = requests.get(f"{CHAT_URL}/chat/moderation/mutes/{user_id}", params={"room_id": room_id}, headers=mod)
+    assert res.json()["muted"]
+
+    # The timeout lifts itself
+    await asyncio.sleep(4)
+    res = requests.get(f"{CHAT_URL}/chat/moderation/mutes/{user_id}", params={"room_id": room_id}, headers=mod)
+    assert not res.json()["muted"]
+    await ws.send(json.dumps({"room_id": room_id, "content": "back again"}))
+    frame = await recv_until(ws, lambda f: "content" in f)
+    assert frame["content"] == "back again"
+
+    # A moderator can also lift it early
+    requests.post(f"{CHAT_URL}/chat/moderation/mutes",
+                  json={"user_id": user_id, "room_id": room_id, "duration_seconds": 600}, headers=mod)
+    res = requests.delete(f"{CHAT_URL}/chat/moderation/mutes", params={"user_id": user_id, "room_id": room_id}, headers=mod)
+    assert res.status_code == 200
+    res = requests.delete(f"{CHAT_URL}/chat/moderation/mutes", params={"user_id": user_id, "room_id": room_id}, headers=mod)
+    assert res.status_code == 404
+    await ws.send(json.dumps({"room_id": room_id, "content": "thanks"}))
+    frame = await recv_until(ws, lambda f: "content" in f)
+    assert frame["content"] == "thanks"
+    await ws.close()
+
+
+async def test_shadow_ban_hides_history():
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    _, _, room_mod = await new_user([f"room:{room_id}:moderator"])
+    _, _, mod_token = await new_user(["moderator"])
+    _, _, banned = await new_user()
+    _, _, viewer = await new_user()
+    mod = {"Authorization": f"Bearer {mod_token}"}
+    banned_id = token_claims(banned)["user_id"]
+
+    # Shadow bans apply everywhere, so room moderators cannot issue them
+    res = requests.post(f"{CHAT_URL}/chat/moderation/shadowbans", json={"user_id": banned_id},
+                        headers={"Authorization": f"Bearer {room_mod}"})
+    assert res.status_code == 403
+    res = requests.post(f"{CHAT_URL}/chat/moderation/shadowbans", json={"user_id": banned_id, "reason": "spam"}, headers=mod)
+    assert res.status_code == 200
+
+    banned_ws = await connect(banned, room_id)
+    viewer_ws = await connect(viewer, room_id)
+
+    # The author sees their message as usual; nobody else receives it
+    await banned_ws.send(json.dumps({"room_id": room_id, "content": "buy followers"}))
+    echo = await recv_until(banned_ws, lambda f: "content" in f)
+    assert echo["content"] == "buy followers" and "hidden" not in echo
+    try:
+        await recv_until(viewer_ws, lambda f: "content" in f, timeout=2)
+        assert False, "shadow-banned message was delivered"
+    except asyncio.TimeoutError:
+        pass
+
+    def history(token=None):
+        headers = {"Authorization": f"Bearer {token}"} if token else {}
+        return requests.get(f"{CHAT_URL}/chat/history/{room_id}", headers=headers).json()
+
+    # History behaves the same way; only moderators see that the message is hidden
+    assert history() == []
+    assert history(viewer) == []
+    own = history(banned)
+    assert [m["content"] for m in own] == ["buy followers"] and "hidden" not in own[0]
+    moderated = history(mod_token)
+    assert moderated[0]["id"] == echo["id"] and moderated[0]["hidden_reason"] == "shadow_ban"
+
+    # Once the ban is lifted, new messages reach the room again
+    res = requests.delete(f"{CHAT_URL}/chat/moderation/shadowbans/{banned_id}", headers=mod)
+    assert res.status_code == 200
+    await banned_ws.send(json.dumps({"room_id": room_id, "content": "sorry"}))
+    frame = await recv_until(viewer_ws, lambda f: "content" in f)
+    assert frame["content"] == "sorry"
+    assert [m["content"] for m in history(viewer)] == ["sorry"]
+    await banned_ws.close()
+    await viewer_ws.close()
+
+
+async def test_slow_mode_and_flood_limits():
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    _, _, mod_token = await new_user([f"room:{room_id}:moderator"])
+    _, _, token = await new_user()
+    mod = {"Authorization": f"Bearer {mod_token}"}
+    ws = await connect(token, room_id)
+
+    async def send(content):
+        await ws.send(json.dumps({"room_id": room_id, "content": content}))
+        return await recv_until(ws, lambda f: "content" in f or f.get("type") == "error")
+
+    # Near-identical repeats count as the same message; the third one is dropped
+    assert (await send("Hello there"))["content"] == "Hello there"
+    assert (await send("hello... theeere!"))["content"] == "hello... theeere!"
+    assert (await send("HELLO THERE"))["code"] == "duplicate_message"
+
+    # In slow mode each user may send one message per interval
+    res = requests.put(f"{CHAT_URL}/chat/moderation/rooms/{room_id}/slowmode",
+                       json={"interval_seconds": 2}, headers=mod)
+    assert res.status_code == 200
+    assert (await send("first"))["content"] == "first"
+    frame = await send("second")
+    assert frame["code"] == "slow_mode" and 0 < frame["remaining_seconds"] <= 2
+    await asyncio.sleep(2.5)
+    assert (await send("third"))["content"] == "third"
+    requests.delete(f"{CHAT_URL}/chat/moderation/rooms/{room_id}/slowmode", headers=mod)
+    await ws.close()
+
+
+async def test_raid_turns_on_slow_mode():
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    _, _, mod_token = await new_user([f"room:{room_id}:moderator"])
+    raid = "join my channel for free stuff " + str(uuid.uuid4())[:8]
+
+    # The same message from five accounts within a minute is a raid
+    frames = []
+    for _ in range(5):
+        _, _, token = await new_user()
+        ws = await connect(token, room_id)
+        await ws.send(json.dumps({"room_id": room_id, "content": raid}))
+        frames.append(await recv_until(ws, lambda f: "content" in f or f.get("type") == "error"))
+        await ws.close()
+    assert all(f.get("content") == raid for f in frames[:4])
+    assert frames[4]["code"] == "flood_detected"
+
+    res = requests.get(f"{CHAT_URL}/chat/moderation/rooms/{room_id}/slowmode",
+                       headers={"Authorization": f"Bearer {mod_token}"})
+    assert res.status_code == 200 and res.json()["interval_seconds"] == 10
+    res = requests.get(f"{CHAT_URL}/chat/moderation/actions",
+                       params={"room_id": room_id, "action": "slow_mode_enabled"},
+                       headers={"Authorization": f"Bearer {mod_token}"})
+    assert res.json()[0]["actor_id"] == "system"
+
+
+async def test_lockdown_admits_established_members():
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    _, _, mod_token = await new_user([f"room:{room_id}:moderator"])
+    _, _, member = await new_user()
+    _, _, newcomer = await new_user()
+    mod = {"Authorization": f"Bearer {mod_token}"}
+    lockdown_url = f"{CHAT_URL}/chat/moderation/rooms/{room_id}/lockdown"
+
+    async def refused(token):
+        try:
+            ws = await connect(token, room_id)
+        except websockets.InvalidStatusCode as rejected:
+            return rejected.status_code == 403
+        await ws.close()
+        return False
+
+    # The member chats before the lockdown; the newcomer only watches
+    member_ws = await connect(member, room_id)
+    await member_ws.send(json.dumps({"room_id": room_id, "content": "hi all"}))
+    await recv_until(member_ws, lambda f: "content" in f)
+    await member_ws.close()
+    newcomer_ws = await connect(newcomer, room_id)
+
+    # Only moderators lock a room, and by default accounts younger than a day stay out; moderators never do
+    res = requests.put(lockdown_url, json={}, headers={"Authorization": f"Bearer {member}"})
+    assert res.status_code == 403
+    res = requests.put(lockdown_url, json={"reason": "raid"}, headers=mod)
+    assert res.status_code == 200
+    assert res.json()["trigger"] == "manual" and res.json()["min_account_age_seconds"] == 86400
+    assert await refused(member)
+    assert not await refused(mod_token)
+
+    # Without an age limit, having chatted in the room is enough; members-only also silences everyone else
+    res = requests.put(lockdown_url, json={"min_account_age_seconds": 0, "members_only": True}, headers=mod)
+    assert res.status_code == 200
+    assert await refused(newcomer)
+    member_ws = await connect(member, room_id)
+    await member_ws.send(json.dumps({"room_id": room_id, "content": "still here"}))
+    frame = await recv_until(member_ws, lambda f: "content" in f)
+    assert frame["content"] == "still here"
+    await newcomer_ws.send(json.dumps({"room_id": room_id, "content": "let me in"}))
+    frame = await recv_until(newcomer_ws, lambda f: f.get("type") == "error")
+    assert frame["code"] == "room_locked"
+
+    # Once the lockdown is lifted the newcomer gets in and can talk
+    res = requests.delete(lockdown_url, headers=mod)
+    assert res.status_code == 200
+    assert requests.get(lockdown_url, headers=mod).status_code == 404
+    assert not await refused(newcomer)
+    await newcomer_ws.send(json.dumps({"room_id": room_id, "content": "hello"}))
+    await recv_until(newcomer_ws, lambda f: f.get("content") == "hello")
+    await member_ws.close()
+    await newcomer_ws.close()
+
+
+async def test_lockdown_slow_mode_ends_with_it():
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    _, _, mod_token = await new_user([f"room:{room_id}:moderator"])
+    mod = {"Authorization": f"Bearer {mod_token}"}
+    lockdown_url = f"{CHAT_URL}/chat/moderation/rooms/{room_id}/lockdown"
+    slowmode_url = f"{CHAT_URL}/chat/moderation/rooms/{room_id}/slowmode"
+
+    # The lockdown's own slow mode is lifted along with it
+    res = requests.put(lockdown_url, json={"slow_mode_seconds": 20}, headers=mod)
+    assert res.status_code == 200 and res.json()["slow_mode_applied"]
+    assert requests.get(slowmode_url, headers=mod).json()["interval_seconds"] == 20
+    assert requests.delete(lockdown_url, headers=mod).status_code == 200
+    assert requests.get(slowmode_url, headers=mod).status_code == 404
+
+    # A slow mode a moderator set before is kept as it was
+    res = requests.put(slowmode_url, json={"interval_seconds": 5}, headers=mod)
+    assert res.status_code == 200
+    res = requests.put(lockdown_url, json={"slow_mode_seconds": 20}, headers=mod)
+    assert res.status_code == 200 and not res.json().get("slow_mode_applied")
+    assert requests.get(slowmode_url, headers=mod).json()["interval_seconds"] == 5
+    assert requests.delete(lockdown_url, headers=mod).status_code == 200
+    assert requests.get(slowmode_url, headers=mod).json()["interval_seconds"] == 5
+
+
+async def test_join_spike_locks_room():
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    _, _, mod_token = await new_user([f"room:{room_id}:moderator"])
+    _, _, token = await new_user()
+    mod = {"Authorization": f"Bearer {mod_token}"}
+    lockdown_url = f"{CHAT_URL}/chat/moderation/rooms/{room_id}/lockdown"
+
+    # More than 60 joins within ten seconds lock the room by themselves
+    for _ in range(61):
+        ws = await connect(token, room_id)
+        await ws.close()
+    res = requests.get(lockdown_url, headers=mod)
+    assert res.status_code == 200
+    assert res.json()["trigger"] == "join_rate" and res.json()["enabled_by"] == "system"
+    assert 0 < res.json()["remaining_seconds"] <= 900
+    try:
+        await connect(token, room_id)
+        assert False, "new account joined a locked room"
+    except websockets.InvalidStatusCode as rejected:
+        assert rejected.status_code == 403
+    res = requests.get(f"{CHAT_URL}/chat/moderation/actions",
+                       params={"room_id": room_id, "action": "room_locked"}, headers=mod)
+    assert res.json()[0]["actor_id"] == "system"
+    requests.delete(lockdown_url, headers=mod)
+
+
+async def test_roles_grant_room_moderation():
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    other_room = "room_" + str(uuid.uuid4())[:8]
+    _, _, admin = await new_user(["admin"])
+    _, _, staff = await new_user(["staff"])
+    email, password, token = await new_user()
+    user_id = token_claims(token)["user_id"]
+    roles_url = f"{USER_URL}/user/{user_id}/roles"
+
+    # Only admins and staff manage roles, and only admins grant global roles
+    res = requests.put(roles_url, json={"roles": ["moderator"]}, headers={"Authorization": f"Bearer {token}"})
+    assert res.status_code == 403
+    res = requests.put(roles_url, json={"roles": ["moderator"]}, headers={"Authorization": f"Bearer {staff}"})
+    assert res.status_code == 403
+    res = requests.put(roles_url, json={"roles": ["room:*:moderator"]}, headers={"Authorization": f"Bearer {admin}"})
+    assert res.status_code == 400
+    res = requests.put(roles_url, json={"roles": [f"room:{room_id}:moderator"]}, headers={"Authorization": f"Bearer {staff}"})
+    assert res.status_code == 200
+
+    # The role is in the token from the next login and scoped to its room
+    assert "roles" not in token_claims(token)
+    token = await login(email, password)
+    assert token_claims(token)["roles"] == [f"room:{room_id}:moderator"]
+    headers = {"Authorization": f"Bearer {token}"}
+    res = requests.get(f"{CHAT_URL}/chat/moderation/rooms/{room_id}/filters", headers=headers)
+    assert res.status_code == 200
+    res = requests.get(f"{CHAT_URL}/chat/moderation/rooms/{other_room}/filters", headers=headers)
+    assert res.status_code == 403
+    res = requests.get(f"{CHAT_URL}/chat/moderation/shadowbans", headers=headers)
+    assert res.status_code == 403
+
+    res = requests.put(roles_url, json={"roles": ["moderator"]}, headers={"Authorization": f"Bearer {admin}"})
+    assert res.status_code == 200
+    headers = {"Authorization": f"Bearer {await login(email, password)}"}
+    res = requests.get(f"{CHAT_URL}/chat/moderation/rooms/{other_room}/filters", headers=headers)
+    assert res.status_code == 200
+
+
+async def test_login_requires_verified_email():
+    name = "user_" + str(uuid.uuid4())[:8]
+    password = str(uuid.uuid4())[:8]
+    email = f"{name}@gmail.com"
+    assert await register(email, password, name, verified=False), "Registration failed"
+
+    # The password is checked first, so the answer reveals nothing to someone guessing it
+    res = requests.post(f"{AUTH_URL}/login", json={"email": email, "password": "wrong-password"})
+    assert res.status_code == 401
+    res = requests.post(f"{AUTH_URL}/login", json={"email": email, "password": password})
+    assert res.status_code == 403
+    assert "token" not in res.json()
+
+    # Resending answers the same for known and unknown addresses
+    known = requests.post(f"{AUTH_URL}/resend-verification", json={"email": email})
+    unknown = requests.post(f"{AUTH_URL}/resend-verification", json={"email": f"nobody_{email}"})
+    assert known.status_code == unknown.status_code == 200
+    assert known.json() == unknown.json()
+
+    res = requests.get(f"{AUTH_URL}/verify-email", params={"token": "not-a-token"})
+    assert res.status_code == 400
+
+    verify_email(email)
+    res = requests.post(f"{AUTH_URL}/login", json={"email": email, "password": password})
+    assert res.status_code == 200 and res.json()["token"]
+
+
+def issue_reset_token(email):
+    """
+    Store a password reset token for the account, as /forgot-password does, and return it
+    """
+    token = uuid.uuid4().hex
+    now = datetime.datetime.now(datetime.timezone.utc)
+    client = MongoClient(MONGO_URL)
+    try:
+        db = client["chatorbit"]
+        user = db["users"].find_one({"email": email})
+        db["password_resets"].insert_one({
+            "user_id": user["_id"],
+            "token_hash": hashlib.sha256(token.encode()).hexdigest(),
+            "created_at": now,
+            "expires_at": now + datetime.timedelta(minutes=10),
+        })
+    finally:
+        client.close()
+    return token
+
+
+async def test_password_reset_is_single_use():
+    email, password, token = await new_user()
+    refresh = requests.post(f"{AUTH_URL}/login", json={"email": email, "password": password}).json()["refresh_token"]
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    ws = await connect(token, room_id)
+
+    # Asking again replaces the earlier link, and the answer does not reveal whether the account exists
+    stale = issue_reset_token(email)
+    known = requests.post(f"{AUTH_URL}/forgot-password", json={"email": email})
+    unknown = requests.post(f"{AUTH_URL}/forgot-password", json={"email": f"nobody_{email}"})
+    assert known.status_code == unknown.status_code == 200 and known.json() == unknown.json()
+    res = requests.post(f"{AUTH_URL}/reset-password", json={"token": stale, "new_password": "new-password"})
+    assert res.status_code == 400
+
+    reset = issue_reset_token(email)
+    res = requests.post(f"{AUTH_URL}/reset-password", json={"token": reset, "new_password": "new-password"})
+    assert res.status_code == 200
+    res = requests.post(f"{AUTH_URL}/reset-password", json={"token": reset, "new_password": "other-password"})
+    assert res.status_code == 400
+
+    # The reset logs the account out everywhere
+    try:
+        await asyncio.wait_for(ws.recv(), timeout=5)
+        assert False, "connection was not closed"
+    except websockets.ConnectionClosed as closed:
+        assert closed.code == 4002
+    res = requests.get(f"{AUTH_URL}/sessions", headers={"Authorization": f"Bearer {token}"})
+    assert res.status_code == 401
+    assert requests.post(f"{AUTH_URL}/refresh", json={"refresh_token": refresh}).status_code == 401
+    res = requests.post(f"{AUTH_URL}/login", json={"email": email, "password": password})
+    assert res.status_code == 401
+    assert await login(email, "new-password")
+
+
+async def test_forgot_password_rate_limit():
+    email = "nobody_" + str(uuid.uuid4())[:8] + "@gmail.com"
+
+    # Each address gets a few links per window, whether or not it belongs to an account
+    for _ in range(3):
+        res = requests.post(f"{AUTH_URL}/forgot-password", json={"email": email})
+        assert res.status_code == 200
+    res = requests.post(f"{AUTH_URL}/forgot-password", json={"email": email.upper()})
+    assert res.status_code == 429
+    assert int(res.headers["Retry-After"]) > 0
+
+
+async def test_bot_api_key_limits():
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    other_room = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    assert await create_room(other_room), "Room creation failed"
+    _, _, admin_token = await new_user(["admin"])
+    admin = {"Authorization": f"Bearer {admin_token}"}
+
+    res = requests.post(f"{AUTH_URL}/bots", json={"username": "bot_" + str(uuid.uuid4())[:8]}, headers=admin)
+    assert res.status_code == 200
+    bot_id = res.json()["id"]
+
+    def create_key(scopes, rate_limit=0):
+        res = requests.post(f"{AUTH_URL}/bots/{bot_id}/keys", headers=admin,
+                            json={"scopes": scopes, "rooms": [room_id], "rate_limit": rate_limit})
+        assert res.status_code == 200
+        return res.json()["key"], res.json()["api_key"]["id"]
+
+    def bot_token(key):
+        return requests.post(f"{AUTH_URL}/bots/token", headers={"X-API-Key": key})
+
+    post_key, post_key_id = create_key(["messages:post"], rate_limit=3)
+    read_key, _ = create_key(["history:read"])
+    poster = bot_token(post_key).json()["token"]
+    reader = bot_token(read_key).json()["token"]
+
+    # Keys only reach their rooms and their scopes
+    try:
+        await connect(poster, other_room)
+        assert False, "bot joined a room outside its key"
+    except websockets.InvalidStatusCode as rejected:
+        assert rejected.status_code == 403
+    res = requests.get(f"{CHAT_URL}/chat/history/{room_id}", headers={"Authorization": f"Bearer {poster}"})
+    assert res.status_code == 403
+    res = requests.get(f"{CHAT_URL}/chat/history/{room_id}", headers={"Authorization": f"Bearer {reader}"})
+    assert res.status_code == 200
+    res = requests.get(f"{CHAT_URL}/chat/history/{other_room}", headers={"Authorization": f"Bearer {reader}"})
+    assert res.status_code == 403
+    res = requests.get(f"{CHAT_URL}/chat/moderation/reports", params={"room_id": room_id},
+                       headers={"Authorization": f"Bearer {poster}"})
+    assert res.status_code == 403
+    ws = await connect(reader, room_id)
+    await ws.send(json.dumps({"room_id": room_id, "content": "hello"}))
+    frame = await recv_until(ws, lambda f: f.get("type") == "error")
+    assert frame["code"] == "bot_not_allowed"
+    await ws.close()
+
+    # The key's rate limit replaces slow mode and flood detection for the bot
+    ws = await connect(poster, room_id)
+    for i in range(3):
+        await ws.send(json.dumps({"room_id": room_id, "content": f"update {i}"}))
+        frame = await recv_until(ws, lambda f: "content" in f)
+        assert frame["content"] == f"update {i}" and frame["bot"]
+    await ws.send(json.dumps({"room_id": room_id, "content": "update 3"}))
+    frame = await recv_until(ws, lambda f: f.get("type") == "error")
+    assert frame["code"] == "bot_rate_limited" and frame["remaining_seconds"] > 0
+
+    # Revoking the key ends the bot's tokens and connections
+    res = requests.delete(f"{AUTH_URL}/bots/{bot_id}/keys/{post_key_id}", headers=admin)
+    assert res.status_code == 200
+    try:
+        await asyncio.wait_for(ws.recv(), timeout=5)
+        assert False, "connection was not closed"
+    except websockets.ConnectionClosed as closed:
+        assert closed.code == 4002
+    assert bot_token(post_key).status_code == 401
+
+
+async def test_bot_reauth_keeps_key_limits():
+    room_id = "room_" + str(uuid.uuid4())[:8]
+    other_room = "room_" + str(uuid.uuid4())[:8]
+    assert await create_room(room_id), "Room creation failed"
+    assert await create_room(other_room), "Room creation failed"
+    _, _, admin_token = await new_user(["admin"])
+    admin = {"Authorization": f"Bearer {admin_token}"}
+    res = requests.post(f"{AUTH_URL}/bots", json={"username": "bot_" + str(uuid.uuid4())[:8]}, headers=admin)
+    bot_id = res.json()["id"]
+
+    def bot_token(scopes, room):
+        res = requests.post(f"{AUTH_URL}/bots/{bot_id}/keys", headers=admin, json={"scopes": scopes, "rooms": [room]})
+        assert res.status_code == 200
+        return requests.post(f"{AUTH_URL}/bots/token", headers={"X-API-Key": res.json()["key"]}).json()["token"]
+
+    ws = await connect(bot_token(["messages:post"], room_id), room_id)
+
+    # A key for another room cannot take over the connection; the old key keeps working
+    await ws.send(json.dumps({"type": "reauth", "token": bot_token(["messages:post"], other_room)}))
+    frame = await recv_until(ws, lambda f: f.get("type") == "error")
+    assert frame["code"] == "reauth_failed"
+    await ws.send(json.dumps({"room_id": room_id, "content": "update"}))
+    frame = await recv_until(ws, lambda f: "content" in f)
+    assert frame["content"] == "update"
+
+    # A key for the same room is accepted, and from then on its scopes apply
+    await ws.send(json.dumps({"type": "reauth", "token": bot_token(["history:read"], room_id)}))
+    frame = await recv_until(ws, lambda f: f.get("type") in ("reauthenticated", "error"))
+    assert frame["type"] == "reauthenticated"
+    await ws.send(json.dumps({"room_id": room_id, "content": "update"}))
+    frame = await recv_until(ws, lambda f: f.get("type") == "error")
+    assert frame["code"] == "bot_not_allowed"
+    await ws.close()
+
+
 if __name__ == "__main__":
     asyncio.run(test_chat_flow())